@@ -0,0 +1,237 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pborman/getopt/v2"
+
+	"github.com/antst/mzotbc/internal/config"
+	"github.com/antst/mzotbc/internal/db"
+	"github.com/antst/mzotbc/internal/logger"
+	"github.com/antst/mzotbc/internal/safe_mqtt"
+)
+
+// Alarm severities
+const (
+	severityWarning  = "warning"
+	severityCritical = "critical"
+)
+
+// alarmEvent is published to `<control_topic>/alarms/<name>`, list of active alarms
+// goes to `<control_topic>/alarms`.
+type alarmEvent struct {
+	Name      string     `json:"name"`
+	Active    bool       `json:"active"`
+	Severity  string     `json:"severity"`
+	Message   string     `json:"message"`
+	RaisedAt  time.Time  `json:"raised_at"`
+	ClearedAt *time.Time `json:"cleared_at,omitempty"`
+}
+
+type activeAlarm struct {
+	id    int64
+	event alarmEvent
+}
+
+// alarmManager keeps active alarms, raising the same alarm again is a no-op. Every alarm is
+// stored to DB with the times it was raised and cleared, active ones survive restart.
+type alarmManager struct {
+	mqtt    safe_mqtt.MqttClient
+	queries *db.Queries
+	topic   string
+
+	mu     sync.Mutex
+	active map[string]*activeAlarm
+}
+
+func newAlarmManager(_mqttCfg *config.MQTTConfig, _q *db.Queries) *alarmManager {
+	a := &alarmManager{
+		mqtt:    safe_mqtt.InitMQTTClient(_mqttCfg.URL, "otbs-alarms-"+uuid.New().String()),
+		queries: _q,
+		topic:   _mqttCfg.ControlTopic + "/alarms",
+		active:  make(map[string]*activeAlarm),
+	}
+
+	rows, err := _q.ListActiveAlarms(context.Background())
+	if err != nil {
+		logger.L().Error(err)
+	}
+	for _, r := range rows {
+		a.active[r.Name] = &activeAlarm{
+			id: r.ID,
+			event: alarmEvent{
+				Name: r.Name, Active: true, Severity: r.Severity, Message: r.Message, RaisedAt: r.RaisedAt.Local(),
+			},
+		}
+	}
+	a.publishActive()
+	return a
+}
+
+// set raises or clears the alarm.
+func (a *alarmManager) set(name string, active bool, severity, message string) {
+	if active {
+		a.raise(name, severity, message)
+	} else {
+		a.clear(name)
+	}
+}
+
+// raise raises the alarm, unless it is already active with the same severity and message.
+// Alarm with changed message is cleared and raised again.
+func (a *alarmManager) raise(name, severity, message string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if al, ok := a.active[name]; ok {
+		if al.event.Severity == severity && al.event.Message == message {
+			return
+		}
+		a.clearLocked(name)
+	}
+
+	now := clk.Now()
+	e := alarmEvent{Name: name, Active: true, Severity: severity, Message: message, RaisedAt: now}
+	id, err := a.queries.InsertAlarm(context.Background(), db.InsertAlarmParams{
+		Name: name, Severity: severity, Message: message, RaisedAt: now.UTC(),
+	})
+	if err != nil {
+		logger.L().Errorf("Failed to store alarm `%s`: %v", name, err)
+	}
+	a.active[name] = &activeAlarm{id: id, event: e}
+	logger.L().Warnf("Alarm `%s` (%s): %s", name, severity, message)
+	a.publish(e)
+	a.publishActive()
+}
+
+// clear clears the alarm, if it is active.
+func (a *alarmManager) clear(name string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.active[name]; ok {
+		a.clearLocked(name)
+		a.publishActive()
+	}
+}
+
+func (a *alarmManager) clearLocked(name string) {
+	al := a.active[name]
+	delete(a.active, name)
+
+	now := clk.Now()
+	if al.id != 0 {
+		err := a.queries.ClearAlarm(context.Background(), db.ClearAlarmParams{
+			ClearedAt: sql.NullTime{Time: now.UTC(), Valid: true}, ID: al.id,
+		})
+		if err != nil {
+			logger.L().Errorf("Failed to store clearing of alarm `%s`: %v", name, err)
+		}
+	}
+	e := al.event
+	e.Active, e.ClearedAt = false, &now
+	logger.L().Infof("Alarm `%s` cleared: %s", name, e.Message)
+	a.publish(e)
+}
+
+func (a *alarmManager) publish(e alarmEvent) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		logger.L().Error(err)
+		return
+	}
+	a.mqtt.SafePublish(a.topic+"/"+e.Name, mqttQoS, true, payload)
+}
+
+func (a *alarmManager) publishActive() {
+	list := make([]alarmEvent, 0, len(a.active))
+	for _, al := range a.active {
+		list = append(list, al.event)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].RaisedAt.Before(list[j].RaisedAt) })
+	payload, err := json.Marshal(list)
+	if err != nil {
+		logger.L().Error(err)
+		return
+	}
+	a.mqtt.SafePublish(a.topic, mqttQoS, true, payload)
+}
+
+// listAlarms returns alarms raised in the period, with name containing `name`.
+func listAlarms(q *db.Queries, since, until time.Time, name string) ([]alarmEvent, error) {
+	rows, err := q.ListAlarms(context.Background(), db.ListAlarmsParams{Since: since.UTC(), Until: until.UTC()})
+	if err != nil {
+		return nil, err
+	}
+	events := []alarmEvent{}
+	for _, r := range rows {
+		if !strings.Contains(r.Name, name) {
+			continue
+		}
+		e := alarmEvent{
+			Name: r.Name, Active: !r.ClearedAt.Valid, Severity: r.Severity, Message: r.Message,
+			RaisedAt: r.RaisedAt.Local(),
+		}
+		if r.ClearedAt.Valid {
+			cleared := r.ClearedAt.Time.Local()
+			e.ClearedAt = &cleared
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// Alarms prints recorded alarm history as CSV.
+func Alarms() {
+	dbFile := getopt.StringLong("alarms-db", 0, "", "DB file with alarm history, `db_file` by default")
+	from := getopt.StringLong("alarms-from", 0, "", "start of the period")
+	to := getopt.StringLong("alarms-to", 0, "", "end of the period")
+	name := getopt.StringLong("alarms-name", 0, "", "only alarms, containing this text in the name")
+
+	cfg := config.Get()
+	since, until, err := parsePeriod(*from, *to)
+	if err != nil {
+		logger.L().Panic(err)
+	}
+
+	events, err := listAlarms(openHistoryDB(cfg, *dbFile), since, until, *name)
+	if err != nil {
+		logger.L().Panic(err)
+	}
+	out := csv.NewWriter(os.Stdout)
+	_ = out.Write([]string{"raised_at", "cleared_at", "name", "severity", "message"})
+	for _, e := range events {
+		cleared := ""
+		if e.ClearedAt != nil {
+			cleared = e.ClearedAt.Format(time.RFC3339)
+		}
+		_ = out.Write([]string{e.RaisedAt.Format(time.RFC3339), cleared, e.Name, e.Severity, e.Message})
+	}
+	out.Flush()
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"context"
+	"encoding/csv"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pborman/getopt/v2"
+
+	"github.com/antst/mzotbc/internal/config"
+	"github.com/antst/mzotbc/internal/db"
+	"github.com/antst/mzotbc/internal/logger"
+)
+
+// auditRecord is a control change, as it is reported by `mzotbc audit` and HTTP API.
+type auditRecord struct {
+	Time     time.Time `json:"time"`
+	Topic    string    `json:"topic"`
+	Old      string    `json:"old"`
+	New      string    `json:"new"`
+	Accepted bool      `json:"accepted"`
+}
+
+// auditControl records control request, received on the topic. Accepted request is recorded
+// with the resulting value and only if the value has changed (e.g. not retained message,
+// repeated on reconnect), rejected one is recorded with the payload.
+func auditControl(q *db.Queries, topic, old, value, payload string, accepted bool) {
+	if accepted && old == value {
+		return
+	}
+	if !accepted {
+		value = payload
+		logger.L().Warnf("Rejected control request %v : %v", topic, payload)
+	}
+	err := q.InsertControlAudit(context.Background(), db.InsertControlAuditParams{
+		Topic:     topic,
+		OldValue:  old,
+		NewValue:  value,
+		Accepted:  accepted,
+		ChangedAt: clk.Now().UTC(),
+	})
+	if err != nil {
+		logger.L().Errorf("Failed to record control change of %s: %v", topic, err)
+	}
+}
+
+// formatControlValue formats optional float config value for the audit log.
+func formatControlValue(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
+
+// listAudit returns control changes in the period, with topic containing `topic`.
+func listAudit(q *db.Queries, since, until time.Time, topic string) ([]auditRecord, error) {
+	rows, err := q.ListControlAudit(context.Background(), db.ListControlAuditParams{
+		Since: since.UTC(), Until: until.UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	records := []auditRecord{}
+	for _, r := range rows {
+		if !strings.Contains(r.Topic, topic) {
+			continue
+		}
+		records = append(records, auditRecord{
+			Time: r.ChangedAt.Local(), Topic: r.Topic, Old: r.OldValue, New: r.NewValue, Accepted: r.Accepted,
+		})
+	}
+	return records, nil
+}
+
+// Audit prints recorded control changes as CSV.
+func Audit() {
+	dbFile := getopt.StringLong("audit-db", 0, "", "DB file with audit log, `db_file` by default")
+	from := getopt.StringLong("audit-from", 0, "", "start of the period")
+	to := getopt.StringLong("audit-to", 0, "", "end of the period")
+	topic := getopt.StringLong("audit-topic", 0, "", "only topics, containing this text")
+
+	cfg := config.Get()
+	since, until, err := parsePeriod(*from, *to)
+	if err != nil {
+		logger.L().Panic(err)
+	}
+
+	records, err := listAudit(openHistoryDB(cfg, *dbFile), since, until, *topic)
+	if err != nil {
+		logger.L().Panic(err)
+	}
+	out := csv.NewWriter(os.Stdout)
+	_ = out.Write([]string{"time", "topic", "old", "new", "accepted"})
+	for _, r := range records {
+		_ = out.Write([]string{
+			r.Time.Format(time.RFC3339), r.Topic, r.Old, r.New, strconv.FormatBool(r.Accepted),
+		})
+	}
+	out.Flush()
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/antst/mzotbc/internal/db"
+	"github.com/antst/mzotbc/internal/logger"
+)
+
+const (
+	autotuneCheckInterval = time.Hour
+	autotuneHPValue       = "heating_parameter"
+	autotuneCheckedValue  = "autotune_checked_at"
+)
+
+// autotuneReport is published to `<control_topic>/zone/<name>/autotune` on every change.
+type autotuneReport struct {
+	OldValue  float64   `json:"old_value"`
+	NewValue  float64   `json:"new_value"`
+	MeanError float64   `json:"mean_error"`
+	Samples   int       `json:"samples"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+func (c *ThermoController) autotuneEnabled(zone *ZoneController) bool {
+	if c.cfg.Autotune == nil {
+		return false
+	}
+	if zone.cfg.Autotune != nil {
+		return *zone.cfg.Autotune
+	}
+	return c.cfg.Autotune.Enabled
+}
+
+// restoreHeatingParameters loads tuned heating parameters from DB,
+// they take precedence over config values.
+func (c *ThermoController) restoreHeatingParameters() {
+	for _, zone := range c.zones {
+		if !c.autotuneEnabled(zone) {
+			continue
+		}
+		if hp, err := zone.readValue(autotuneHPValue); err == nil {
+			logger.L().Infof("Loaded tuned heating parameter for zone %v: %v", zone.name, hp)
+			zone.cfg.HeatingParameter = &hp
+		}
+	}
+}
+
+func (c *ThermoController) autotuner() {
+	ticker := newTicker(autotuneCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.autotune(clk.Now())
+	}
+}
+
+// autotune makes a tuning step for every zone, which has collected history for a full interval.
+func (c *ThermoController) autotune(now time.Time) {
+	cfg := c.cfg.Autotune
+	changed := false
+	for _, zone := range c.zones {
+		if !c.autotuneEnabled(zone) {
+			continue
+		}
+		checked, err := zone.readValue(autotuneCheckedValue)
+		if err != nil {
+			// first run, interval starts now
+			if err := zone.writeValue(autotuneCheckedValue, float64(now.Unix())); err != nil {
+				logger.L().Error(err)
+			}
+			continue
+		}
+		since := time.Unix(int64(checked), 0)
+		if now.Sub(since) < cfg.Interval {
+			continue
+		}
+		if err := zone.writeValue(autotuneCheckedValue, float64(now.Unix())); err != nil {
+			logger.L().Error(err)
+		}
+
+		history, err := c.queries.ListZoneHistory(
+			context.Background(), db.ListZoneHistoryParams{ZoneName: zone.name, RecordedAt: since.UTC()},
+		)
+		if err != nil {
+			logger.L().Errorf("Failed to read history of zone %s: %v", zone.name, err)
+			continue
+		}
+		e, n := steadyError(history, cfg.Settle)
+		if n < cfg.MinSamples {
+			logger.L().Infof("Zone %s: not enough steady history for tuning (%d samples)", zone.name, n)
+			continue
+		}
+		if math.Abs(e) < *cfg.Deadband {
+			logger.L().Infof("Zone %s: heating parameter is fine, mean error %.2f", zone.name, e)
+			continue
+		}
+
+		old := c.getHeatingParameter(zone)
+		step := max(min(*cfg.LearningRate*e, *cfg.MaxStep), -*cfg.MaxStep)
+		hp := math.Round(max(min(old+step, *cfg.Max), *cfg.Min)*100) / 100
+		if hp == old {
+			continue
+		}
+		c.applyTunedHeatingParameter(zone, autotuneReport{
+			OldValue: old, NewValue: hp, MeanError: e, Samples: n, ChangedAt: now,
+		})
+		changed = true
+	}
+	if changed {
+		c.requestUpdate()
+	}
+}
+
+func (c *ThermoController) applyTunedHeatingParameter(zone *ZoneController, r autotuneReport) {
+	logger.L().Infof("Zone %s: tuned heating parameter %.2f -> %.2f, mean error %.2f over %d samples",
+		zone.name, r.OldValue, r.NewValue, r.MeanError, r.Samples)
+	zone.cfg.HeatingParameter = &r.NewValue
+
+	if err := zone.writeValue(autotuneHPValue, r.NewValue); err != nil {
+		logger.L().Error(err)
+	}
+	err := c.queries.InsertHeatingParameterChange(context.Background(), db.InsertHeatingParameterChangeParams{
+		ZoneName:  zone.name,
+		OldValue:  r.OldValue,
+		NewValue:  r.NewValue,
+		MeanError: r.MeanError,
+		Samples:   int64(r.Samples),
+		ChangedAt: r.ChangedAt.UTC(),
+	})
+	if err != nil {
+		logger.L().Error(err)
+	}
+
+	payload, err := json.Marshal(r)
+	if err != nil {
+		logger.L().Error(err)
+		return
+	}
+	c.mqtt.SafePublish(zone.controlTopic+"autotune", mqttQoS, true, payload)
+}
+
+// steadyError returns mean (setpoint - temperature) error of history records, taken at
+// least `settle` after the last change of effective setpoint, while zone requested heat.
+func steadyError(history []db.ZoneHistory, settle time.Duration) (float64, int) {
+	var sum float64
+	var steadySince time.Time
+	n := 0
+	for i, h := range history {
+		if i == 0 || h.EffectiveSetpoint != history[i-1].EffectiveSetpoint ||
+			h.RecordedAt.Sub(history[i-1].RecordedAt) > heatupMaxGap {
+			steadySince = h.RecordedAt
+		}
+		if h.RecordedAt.Sub(steadySince) < settle || h.Tset < minEnableTemp {
+			continue
+		}
+		sum += h.EffectiveSetpoint - h.Temperature
+		n++
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	return sum / float64(n), n
+}
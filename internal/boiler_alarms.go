@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/antst/mzotbc/internal/opentherm"
+)
+
+// flameLostGap is how far below control setpoint flow must be, for missing flame to be a fault,
+// not an anti-cycling pause after overshoot.
+const flameLostGap = 5.0
+
+// checkAlarms turns boiler state into alarms: fault with its flags and OEM fault code, lockout,
+// low water pressure and flame lost (gas/flame fault, or no flame while CH is active).
+// Alarms clear, once the condition is gone.
+func (b *BoilerController) checkAlarms() {
+	st := b.State()
+	now := clk.Now()
+	cfg := b.cfg.Alarms
+
+	fault := st.Fault()
+	msg := "boiler fault"
+	if faults := st.Faults(); len(faults) > 0 {
+		msg += ": " + strings.Join(faults, ", ")
+	}
+	if st.OEMFaultCode != 0 {
+		msg += fmt.Sprintf(", OEM fault code %d", st.OEMFaultCode)
+	}
+	b.alarms.set(b.alarmPrefix+"/fault", fault, severityCritical, msg)
+	b.alarms.set(
+		b.alarmPrefix+"/lockout", fault && st.FaultFlags&opentherm.FaultLockoutReset != 0,
+		severityCritical, "boiler lockout, manual reset required",
+	)
+
+	lowFlag := fault && st.LowWaterPressure()
+	severity := severityWarning
+	if lowFlag {
+		severity = severityCritical
+	}
+	b.alarms.set(
+		b.alarmPrefix+"/low_water_pressure", lowFlag || (st.CHPressure > 0 && st.CHPressure < *cfg.MinPressure),
+		severity, fmt.Sprintf("water pressure below %.1f bar", *cfg.MinPressure),
+	)
+
+	noFlame := st.CHActive() && !st.Flame() && st.BoilerWaterTemperature < st.ControlSetpoint-flameLostGap
+	b.stateLock.Lock()
+	switch {
+	case !noFlame:
+		b.noFlameSince = time.Time{}
+	case b.noFlameSince.IsZero():
+		b.noFlameSince = now
+	}
+	lost := noFlame && now.Sub(b.noFlameSince) >= cfg.FlameGrace
+	b.stateLock.Unlock()
+	// gas/flame fault is reported by the boiler itself, missing flame alone may be a long
+	// anti-cycling pause, so it waits for the grace time
+	flameFault := fault && st.FaultFlags&opentherm.FaultGasFlame != 0
+	severity = severityWarning
+	if flameFault {
+		severity = severityCritical
+	}
+	b.alarms.set(b.alarmPrefix+"/flame_lost", flameFault || lost, severity, "no flame while CH is active")
+
+	if b.cfg.Verify == nil {
+		b.alarms.clear(b.alarmPrefix + "/not_following")
+	}
+}
@@ -19,49 +19,202 @@
 package internal
 
 import (
-	"fmt"
+	"encoding/json"
+	"errors"
 	"sync"
+	"time"
 
+	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/google/uuid"
 
 	"github.com/antst/mzotbc/internal/config"
 	"github.com/antst/mzotbc/internal/logger"
+	"github.com/antst/mzotbc/internal/opentherm"
+	"github.com/antst/mzotbc/internal/otgw"
 	"github.com/antst/mzotbc/internal/safe_mqtt"
 
 	"github.com/antst/mzotbc/internal/db"
 )
 
+const statusPublishInterval = 10 * time.Second
+
 type BoilerController struct {
-	lock    sync.Mutex
-	cfg     *config.BoilerConfig
-	mqtt    safe_mqtt.MqttClient
-	queries *db.Queries
+	lock             sync.Mutex
+	cfg              *config.BoilerConfig
+	mqtt             safe_mqtt.MqttClient
+	queries          *db.Queries
+	driver           BoilerDriver
+	statusTopic      string
+	stateLock        sync.RWMutex
+	state            opentherm.BoilerState
+	statePublishedAt time.Time
+	statePending     bool
+	alarms           *alarmManager
+	alarmPrefix      string
+	noFlameSince     time.Time
+	checkLock        sync.Mutex
+	check            commandCheck
 }
 
-func NewBoilerController(_cfg *config.BoilerConfig, _mqttCfg *config.MQTTConfig, _q *db.Queries) *BoilerController {
-	b := &BoilerController{cfg: _cfg, queries: _q}
+func NewBoilerController(
+	_cfg *config.BoilerConfig, _mqttCfg *config.MQTTConfig, _q *db.Queries, _alarms *alarmManager,
+) *BoilerController {
+	b := &BoilerController{
+		cfg:         _cfg,
+		queries:     _q,
+		statusTopic: _mqttCfg.ControlTopic + "/boiler/status",
+		alarms:      _alarms,
+		alarmPrefix: "boiler",
+	}
 	b.mqtt = safe_mqtt.InitMQTTClient(_mqttCfg.URL, "otbs-boiler-"+uuid.New().String())
 	//b.mqtt.SafeSubscribe(_cfg.Topic, 1, b.TemperatureUpdateHandler)
 
+	b.driver = b.newDriver()
+	if _cfg.MessageTopic != "" {
+		b.mqtt.SafeSubscribe(_cfg.MessageTopic, mqttQoS, b.messageHandler)
+	}
+
 	return b
 }
 
-func (b *BoilerController) Update(Tset float64, chEnable bool) {
+func (b *BoilerController) newDriver() BoilerDriver {
+	switch {
+	case !config.KnownBoilerType(b.cfg.Type):
+		logger.L().Panicf("Unknown boiler type: `%v`", b.cfg.Type)
+	case b.cfg.Type == config.BoilerTypeOTGW:
+		if b.cfg.OTGW == nil {
+			logger.L().Panic("Boiler type `otgw` requires `otgw` section in config")
+		}
+		gw := otgw.NewClient(
+			b.cfg.OTGW.Address, b.cfg.OTGW.Device, b.cfg.OTGW.RefreshInterval, b.cfg.OTGW.CommandTimeout,
+		)
+		gw.OnStatus(b.statusHandler)
+		return &otgwDriver{gw: gw}
+	}
+
+	d, err := newTemplateDriver(b.cfg, b.mqtt)
+	if err != nil {
+		logger.L().Panic(err)
+	}
+	return d
+}
+
+// State returns the latest known boiler state.
+func (b *BoilerController) State() opentherm.BoilerState {
+	b.stateLock.RLock()
+	defer b.stateLock.RUnlock()
+	return b.state
+}
+
+// SetFlowTemperature updates flow temperature from external feedback, e.g. heat pump flow sensor.
+func (b *BoilerController) SetFlowTemperature(v float64) {
+	b.stateLock.Lock()
+	b.state.BoilerWaterTemperature = v
+	b.state.Updated = clk.Now()
+	b.stateLock.Unlock()
+	b.publishState()
+}
+
+func (b *BoilerController) statusHandler(st otgw.Status) {
+	b.stateLock.Lock()
+	fault, oemFault, diag := b.state.FaultFlags, b.state.OEMFaultCode, b.state.OEMDiagnosticCode
+	b.state = st.BoilerState()
+	// PS=1 report doesn't carry fault flags, keep whatever we know
+	b.state.FaultFlags, b.state.OEMFaultCode, b.state.OEMDiagnosticCode = fault, oemFault, diag
+	b.stateLock.Unlock()
+	b.publishState()
+	b.readback()
+	b.checkAlarms()
+}
+
+func (b *BoilerController) messageHandler(client mqtt.Client, message mqtt.Message) {
+	m, err := opentherm.ParseMessage(string(message.Payload()))
+	if err != nil {
+		logger.L().Debug(err)
+		return
+	}
+	logger.L().Debugf("OpenTherm message: %v %v", m.Source, m.Frame)
+
+	b.stateLock.Lock()
+	applied := b.state.Apply(m)
+	b.stateLock.Unlock()
+	if applied {
+		b.publishState()
+		b.readback()
+		b.checkAlarms()
+	}
+}
 
-	if token := b.mqtt.SafePublish(
-		b.cfg.TSetTopic, 1, true, fmt.Sprintf("%.1f", Tset),
-	); token.Wait() && token.Error() != nil {
-		logger.L().Error(token.Error())
+// publishState publishes boiler state, not more often than statusPublishInterval.
+// Changes within the interval are published once it is over, so the last one isn't lost.
+func (b *BoilerController) publishState() {
+	b.stateLock.Lock()
+	if wait := statusPublishInterval - clk.Now().Sub(b.statePublishedAt); wait > 0 {
+		if !b.statePending {
+			b.statePending = true
+			afterFunc(wait, b.publishPending)
+		}
+		b.stateLock.Unlock()
+		return
 	}
+	b.statePublishedAt = clk.Now()
+	report := struct {
+		opentherm.BoilerState
+		Faults []string `json:"faults,omitempty"`
+	}{b.state, b.state.Faults()}
+	b.stateLock.Unlock()
 
-	SchEnable := "0"
-	if chEnable {
-		SchEnable = "1"
+	payload, err := json.Marshal(report)
+	if err != nil {
+		logger.L().Error(err)
+		return
 	}
+	b.mqtt.SafePublish(b.statusTopic, mqttQoS, false, payload)
+}
+
+func (b *BoilerController) publishPending() {
+	b.stateLock.Lock()
+	b.statePending = false
+	b.stateLock.Unlock()
+	b.publishState()
+}
+
+// Update sends Tset and CH enable to the boiler, together with max modulation
+// level, if modulation control is configured.
+func (b *BoilerController) Update(Tset float64, chEnable bool, maxModulation float64) {
+	b.lock.Lock()
+	sent := b.sendCommand(Tset, chEnable, maxModulation)
+	b.lock.Unlock()
+	b.expect(Tset, chEnable, maxModulation, sent)
+}
+
+func (b *BoilerController) sendCommand(Tset float64, chEnable bool, maxModulation float64) bool {
+	sent := true
+	if b.cfg.MaxModulation != nil {
+		sent = b.send(config.BoilerCmdMaxMod, maxModulation) && sent
+	}
+	sent = b.send(config.BoilerCmdTSet, Tset) && sent
+	return b.send(config.BoilerCmdCHEnable, boolToFloat(chEnable)) && sent
+}
+
+// UpdateDHW sends domestic hot water setpoint and enable to the boiler.
+func (b *BoilerController) UpdateDHW(setpoint float64, enable bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.send(config.BoilerCmdDHWSetpoint, setpoint)
+	b.send(config.BoilerCmdDHWEnable, boolToFloat(enable))
+}
 
-	if token := b.mqtt.SafePublish(
-		b.cfg.CHEnableTopic, 1, true, SchEnable,
-	); token.Wait() && token.Error() != nil {
-		logger.L().Error(token.Error())
+// send sends the command and reports whether it was sent, unsupported command is not a failure.
+func (b *BoilerController) send(cmd string, value float64) bool {
+	err := b.driver.Send(cmd, value)
+	switch {
+	case errors.Is(err, errUnsupportedCommand):
+		logger.L().Debugf("Boiler driver `%v` doesn't support `%v`", b.cfg.Type, cmd)
+	case err != nil:
+		logger.L().Errorf("Boiler command `%v`=%v failed: %v", cmd, value, err)
+		return false
 	}
+	return true
 }
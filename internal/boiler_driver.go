@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"text/template"
+
+	"github.com/antst/mzotbc/internal/config"
+	"github.com/antst/mzotbc/internal/otgw"
+	"github.com/antst/mzotbc/internal/safe_mqtt"
+)
+
+var errUnsupportedCommand = errors.New("command is not supported by boiler driver")
+
+// BoilerDriver delivers boiler commands (see config.BoilerCmd*) to the gateway.
+// Boolean commands are sent with value 1 (on) or 0 (off).
+type BoilerDriver interface {
+	Send(cmd string, value float64) error
+}
+
+type commandTemplate struct {
+	topic   *template.Template
+	payload *template.Template
+}
+
+type templateData struct {
+	Base  string
+	Value float64
+	On    bool
+}
+
+// templateDriver publishes commands to MQTT, topic and payload are rendered from templates.
+type templateDriver struct {
+	base     string
+	mqtt     safe_mqtt.MqttClient
+	commands map[string]*commandTemplate
+}
+
+func newTemplateDriver(cfg *config.BoilerConfig, client safe_mqtt.MqttClient) (*templateDriver, error) {
+	d := &templateDriver{
+		base:     cfg.BaseTopic,
+		mqtt:     client,
+		commands: make(map[string]*commandTemplate, len(cfg.Templates)),
+	}
+
+	for cmd, t := range cfg.Templates {
+		topic, err := template.New(cmd + "-topic").Parse(t.Topic)
+		if err != nil {
+			return nil, fmt.Errorf("boiler command `%s` topic: %w", cmd, err)
+		}
+		payload, err := template.New(cmd + "-payload").Parse(t.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("boiler command `%s` payload: %w", cmd, err)
+		}
+		d.commands[cmd] = &commandTemplate{topic: topic, payload: payload}
+	}
+	return d, nil
+}
+
+func (d *templateDriver) Send(cmd string, value float64) error {
+	t, ok := d.commands[cmd]
+	if !ok {
+		return errUnsupportedCommand
+	}
+
+	data := templateData{Base: d.base, Value: value, On: value != 0}
+	var topic, payload bytes.Buffer
+	if err := t.topic.Execute(&topic, data); err != nil {
+		return fmt.Errorf("boiler command `%s` topic: %w", cmd, err)
+	}
+	if err := t.payload.Execute(&payload, data); err != nil {
+		return fmt.Errorf("boiler command `%s` payload: %w", cmd, err)
+	}
+
+	if token := d.mqtt.SafePublish(topic.String(), mqttQoS, true, payload.String()); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	return nil
+}
+
+// otgwDriver talks to the OpenTherm Gateway directly.
+type otgwDriver struct {
+	gw *otgw.Client
+}
+
+func (d *otgwDriver) Send(cmd string, value float64) error {
+	switch cmd {
+	case config.BoilerCmdTSet:
+		return d.gw.SetControlSetpoint(value)
+	case config.BoilerCmdCHEnable:
+		return d.gw.SetCHEnable(value != 0)
+	case config.BoilerCmdDHWSetpoint:
+		return d.gw.SetDHWSetpoint(value)
+	case config.BoilerCmdDHWEnable:
+		return d.gw.SetDHWEnable(value != 0)
+	case config.BoilerCmdMaxMod:
+		return d.gw.SetMaxModulation(value)
+	default:
+		return errUnsupportedCommand
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"math"
+	"time"
+
+	"github.com/antst/mzotbc/internal/logger"
+)
+
+// commandCheck is the last command, sent to the boiler, and state of its verification.
+type commandCheck struct {
+	tSet          float64
+	chEnable      bool
+	maxModulation float64
+	sentAt        time.Time
+	pending       bool
+	generation    int
+	failures      int
+	backoff       time.Duration
+	alarm         bool
+}
+
+// expect starts verification of the sent command. The same command, resent as keepalive,
+// doesn't restart pending verification.
+func (b *BoilerController) expect(tSet float64, chEnable bool, maxModulation float64, sent bool) {
+	if b.cfg.Verify == nil {
+		return
+	}
+	b.checkLock.Lock()
+	defer b.checkLock.Unlock()
+
+	c := &b.check
+	same := c.tSet == tSet && c.chEnable == chEnable && c.maxModulation == maxModulation
+	if same && c.pending && sent {
+		return
+	}
+	c.tSet, c.chEnable, c.maxModulation = tSet, chEnable, maxModulation
+	c.sentAt = clk.Now()
+	c.pending = true
+	c.generation++
+	if !sent {
+		b.failLocked("command wasn't sent")
+		return
+	}
+	b.scheduleLocked(b.cfg.Verify.Timeout, b.verifyTimeout)
+}
+
+// scheduleLocked runs f after d, unless a newer command or verification comes meanwhile.
+func (b *BoilerController) scheduleLocked(d time.Duration, f func()) {
+	generation := b.check.generation
+	afterFunc(d, func() {
+		b.checkLock.Lock()
+		current := generation == b.check.generation
+		b.checkLock.Unlock()
+		if current {
+			f()
+		}
+	})
+}
+
+// readback compares reported control setpoint with the pending command.
+func (b *BoilerController) readback() {
+	if b.cfg.Verify == nil {
+		return
+	}
+	st := b.State()
+	b.checkLock.Lock()
+	defer b.checkLock.Unlock()
+
+	c := &b.check
+	if !c.pending || !st.Updated.After(c.sentAt) {
+		return
+	}
+	if math.Abs(st.ControlSetpoint-c.tSet) > *b.cfg.Verify.Tolerance {
+		return
+	}
+	c.pending = false
+	c.generation++
+	if c.failures > 0 {
+		logger.L().Infof("Boiler accepted Tset %.1f after %d failures", c.tSet, c.failures)
+	}
+	c.failures, c.backoff = 0, 0
+	if c.alarm {
+		c.alarm = false
+		b.alarms.clear(b.alarmPrefix + "/not_following")
+	}
+}
+
+func (b *BoilerController) verifyTimeout() {
+	b.checkLock.Lock()
+	defer b.checkLock.Unlock()
+	if b.check.pending {
+		b.failLocked("no readback of control setpoint")
+	}
+}
+
+// failLocked counts a failure and schedules resend of the command with backoff.
+func (b *BoilerController) failLocked(reason string) {
+	c := &b.check
+	cfg := b.cfg.Verify
+	c.failures++
+	if c.backoff == 0 {
+		c.backoff = cfg.Backoff
+	} else {
+		c.backoff = min(2*c.backoff, cfg.MaxBackoff)
+	}
+	logger.L().Warnf(
+		"Boiler didn't accept Tset %.1f (%s), failure %d, alarm after %d, retry in %v",
+		c.tSet, reason, c.failures, cfg.MaxFailures, c.backoff,
+	)
+	if c.failures >= cfg.MaxFailures && !c.alarm {
+		c.alarm = true
+		b.alarms.raise(b.alarmPrefix+"/not_following", severityCritical, "boiler not following commands")
+	}
+	c.generation++
+	b.scheduleLocked(c.backoff, b.resend)
+}
+
+// resend sends the last command again and restarts its verification.
+func (b *BoilerController) resend() {
+	b.checkLock.Lock()
+	tSet, chEnable, mm := b.check.tSet, b.check.chEnable, b.check.maxModulation
+	b.check.pending = false
+	b.checkLock.Unlock()
+
+	b.lock.Lock()
+	sent := b.sendCommand(tSet, chEnable, mm)
+	b.lock.Unlock()
+	b.expect(tSet, chEnable, mm, sent)
+}
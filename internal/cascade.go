@@ -0,0 +1,346 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/antst/mzotbc/internal/config"
+	"github.com/antst/mzotbc/internal/db"
+	"github.com/antst/mzotbc/internal/logger"
+	"github.com/antst/mzotbc/internal/opentherm"
+	"github.com/antst/mzotbc/internal/safe_mqtt"
+)
+
+// cascadeReport is published to `<control_topic>/heat_source`.
+type cascadeReport struct {
+	Strategy string         `json:"strategy"`
+	Serving  []string       `json:"serving"`
+	Sources  []sourceReport `json:"sources"`
+}
+
+type sourceReport struct {
+	Name         string   `json:"name"`
+	On           bool     `json:"on"`
+	TSet         float64  `json:"tset"`
+	RuntimeHours float64  `json:"runtime_hours"`
+	Flow         *float64 `json:"flow,omitempty"`
+}
+
+// heatSource is a source of the cascade with own driver and feedback.
+type heatSource struct {
+	cfg      *config.HeatSourceConfig
+	boiler   *BoilerController
+	heatPump *heatPump
+	on       bool
+	tSet     float64
+	runtime  time.Duration
+	saved    time.Duration
+}
+
+// flow returns current flow temperature of the source.
+func (h *heatSource) flow(now time.Time) (float64, bool) {
+	st := h.boiler.State()
+	if now.Sub(st.Updated) >= flowValidity || st.BoilerWaterTemperature <= 0 {
+		return 0, false
+	}
+	return st.BoilerWaterTemperature, true
+}
+
+// cascade sequences several heat sources. All its methods are called from the controller loop.
+type cascade struct {
+	cfg     *config.SequencingConfig
+	sources []*heatSource
+	queries *db.Queries
+	mqtt    safe_mqtt.MqttClient
+	topic   string
+
+	lead      *heatSource
+	stages    int
+	lowSince  time.Time
+	highSince time.Time
+	updatedAt time.Time
+	last      []byte
+}
+
+func newCascade(
+	_cfg *config.BoilerConfig, _mqttCfg *config.MQTTConfig, _q *db.Queries, _alarms *alarmManager, _force func(),
+) *cascade {
+	s := &cascade{
+		cfg:     _cfg.Sequencing,
+		queries: _q,
+		mqtt:    safe_mqtt.InitMQTTClient(_mqttCfg.URL, "otbs-cascade-"+uuid.New().String()),
+		topic:   _mqttCfg.ControlTopic + "/heat_source",
+	}
+
+	names := make(map[string]bool)
+	for _, src := range _cfg.Sources {
+		if src.Name == "" || names[src.Name] {
+			logger.L().Panicf("Heat source needs a unique name, got `%s`", src.Name)
+		}
+		if len(src.Sources) > 0 {
+			logger.L().Panicf("Heat source `%s` can't have own sources", src.Name)
+		}
+		names[src.Name] = true
+
+		b := NewBoilerController(&src.BoilerConfig, _mqttCfg, _q, _alarms)
+		b.statusTopic = _mqttCfg.ControlTopic + "/boiler/" + src.Name + "/status"
+		b.alarmPrefix = "boiler/" + src.Name
+		h := &heatSource{cfg: src, boiler: b, tSet: defaultTSet}
+		if src.HeatPump != nil {
+			h.heatPump = newHeatPump(src.HeatPump, _mqttCfg, b, _force)
+			h.heatPump.topic = _mqttCfg.ControlTopic + "/heat_pump/" + src.Name
+		}
+		h.runtime = s.readRuntime(src.Name)
+		h.saved = h.runtime
+		s.sources = append(s.sources, h)
+	}
+	s.lead = slices.MinFunc(s.sources, func(a, b *heatSource) int { return cmp.Compare(a.runtime, b.runtime) })
+	return s
+}
+
+func (s *cascade) readRuntime(name string) time.Duration {
+	val, err := s.queries.GetControllerValue(context.Background(), "runtime_"+name)
+	if err != nil {
+		return 0
+	}
+	sec, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		logger.L().Warnf("Invalid runtime of heat source `%s`: %v", name, val)
+		return 0
+	}
+	return time.Duration(sec) * time.Second
+}
+
+// saveRuntime persists runtime of sources with minute resolution.
+func (s *cascade) saveRuntime() {
+	for _, h := range s.sources {
+		if h.runtime-h.saved < time.Minute {
+			continue
+		}
+		err := s.queries.UpsertControllerValue(context.Background(), db.UpsertControllerValueParams{
+			Name:  "runtime_" + h.cfg.Name,
+			Value: strconv.FormatInt(int64(h.runtime.Seconds()), 10),
+		})
+		if err != nil {
+			logger.L().Error(err)
+			continue
+		}
+		h.saved = h.runtime
+	}
+}
+
+// order returns sources in order of staging by the strategy, together with the number
+// of sources, which always serve the load, and the number of sources, which may serve it.
+func (s *cascade) order(tSet, OT float64) ([]*heatSource, int, int) {
+	switch s.cfg.Strategy {
+	case config.SequencingHeatPumpFirst:
+		var heatPumps, boilers []*heatSource
+		for _, h := range s.sources {
+			if h.heatPump != nil {
+				heatPumps = append(heatPumps, h)
+			} else {
+				boilers = append(boilers, h)
+			}
+		}
+		order := append(heatPumps, boilers...)
+		switch {
+		case len(heatPumps) == 0 || len(boilers) == 0 || OT <= minValidTemp:
+			return order, 1, len(order)
+		case OT < *s.cfg.BivalentTemperature:
+			// boiler assists heat pumps
+			return order, len(heatPumps) + 1, len(order)
+		}
+		return order, 1, len(heatPumps)
+
+	case config.SequencingCapacity:
+		total := 0.0
+		for _, h := range s.sources {
+			total += *h.cfg.Capacity
+		}
+		load := total * min(max((tSet-minTSet)/(maxTSet-minTSet), 0), 1)
+		base, capacity := 0, 0.0
+		for base < len(s.sources) && (base == 0 || capacity < load) {
+			capacity += *s.sources[base].cfg.Capacity
+			base++
+		}
+		return s.sources, base, len(s.sources)
+	}
+
+	// lead/lag: the lead rotates by runtime, lag sources are staged in order of their runtime
+	least := slices.MinFunc(s.sources, func(a, b *heatSource) int { return cmp.Compare(a.runtime, b.runtime) })
+	if s.lead.runtime-least.runtime > s.cfg.RotateAfter {
+		logger.L().Infof("Lead heat source: `%s` -> `%s`", s.lead.cfg.Name, least.cfg.Name)
+		s.lead = least
+	}
+	order := []*heatSource{s.lead}
+	for _, h := range s.sources {
+		if h != s.lead {
+			order = append(order, h)
+		}
+	}
+	slices.SortStableFunc(order[1:], func(a, b *heatSource) int { return cmp.Compare(a.runtime, b.runtime) })
+	return order, 1, len(order)
+}
+
+// plan returns sources, which should serve the load. Next source is staged, while flow
+// temperature stays below Tset by the stage gap, and staged down, once Tset is reached.
+func (s *cascade) plan(tSet float64, demand bool, OT float64, now time.Time) []*heatSource {
+	if !demand {
+		s.stages = 0
+		s.lowSince, s.highSince = time.Time{}, time.Time{}
+		return nil
+	}
+	order, base, limit := s.order(tSet, OT)
+	stages := min(max(s.stages, base), limit)
+	if stages != s.stages {
+		// strategy changed the stages, delays start over
+		s.lowSince, s.highSince = time.Time{}, time.Time{}
+	}
+
+	flow, ok := 0.0, false
+	for _, h := range order[:stages] {
+		if f, fresh := h.flow(now); fresh {
+			flow, ok = max(flow, f), true
+		}
+	}
+	switch {
+	case ok && flow < tSet-*s.cfg.StageGap:
+		s.highSince = time.Time{}
+		if s.lowSince.IsZero() {
+			s.lowSince = now
+		}
+		if stages < limit && now.Sub(s.lowSince) >= s.cfg.StageDelay {
+			stages++
+			s.lowSince = now
+			logger.L().Infof("Stage up heat source `%s`, flow %.1f, Tset %.1f", order[stages-1].cfg.Name, flow, tSet)
+		}
+	case ok && flow >= tSet:
+		s.lowSince = time.Time{}
+		if s.highSince.IsZero() {
+			s.highSince = now
+		}
+		if stages > base && now.Sub(s.highSince) >= s.cfg.StageDelay {
+			logger.L().Infof("Stage down heat source `%s`, flow %.1f, Tset %.1f", order[stages-1].cfg.Name, flow, tSet)
+			stages--
+			s.highSince = now
+		}
+	default:
+		s.lowSince, s.highSince = time.Time{}, time.Time{}
+	}
+	s.stages = stages
+	return order[:stages]
+}
+
+// update sends commands to every source and returns the highest Tset and whether any source is on.
+func (s *cascade) update(tSet float64, chEnable bool, maxModulation, OT float64, now time.Time) (float64, bool) {
+	serving := s.plan(tSet, chEnable, OT, now)
+	sent, anyOn := defaultTSet, false
+	for _, h := range s.sources {
+		t, on := defaultTSet, false
+		if slices.Contains(serving, h) {
+			t, on = tSet, true
+		}
+		if h.heatPump != nil {
+			t, on = h.heatPump.command(t, on, now)
+		}
+		if h.on && !s.updatedAt.IsZero() {
+			h.runtime += now.Sub(s.updatedAt)
+		}
+		h.on, h.tSet = on, t
+		h.boiler.Update(t, on, maxModulation)
+		if on {
+			sent, anyOn = max(sent, t), true
+		}
+	}
+	s.updatedAt = now
+	s.saveRuntime()
+	s.publish(now)
+	return sent, anyOn
+}
+
+// serving returns the source, which serves the load, or the first source without demand.
+func (s *cascade) serving() *heatSource {
+	for _, h := range s.sources {
+		if h.on {
+			return h
+		}
+	}
+	return s.sources[0]
+}
+
+// publish publishes state of the sources, if it has changed.
+func (s *cascade) publish(now time.Time) {
+	r := cascadeReport{Strategy: s.cfg.Strategy, Serving: []string{}}
+	for _, h := range s.sources {
+		sr := sourceReport{
+			Name: h.cfg.Name, On: h.on, TSet: h.tSet, RuntimeHours: math.Round(h.runtime.Hours()*10) / 10,
+		}
+		if f, ok := h.flow(now); ok {
+			sr.Flow = config.GetPTR(math.Round(f))
+		}
+		if h.on {
+			r.Serving = append(r.Serving, h.cfg.Name)
+		}
+		r.Sources = append(r.Sources, sr)
+	}
+	payload, err := json.Marshal(r)
+	if err != nil {
+		logger.L().Error(err)
+		return
+	}
+	if bytes.Equal(payload, s.last) {
+		return
+	}
+	if s.last != nil {
+		var prev cascadeReport
+		if err := json.Unmarshal(s.last, &prev); err == nil && !slices.Equal(prev.Serving, r.Serving) {
+			logger.L().Infof("Heat sources serving the load: [%s]", strings.Join(r.Serving, ", "))
+		}
+	}
+	s.last = payload
+	s.mqtt.SafePublish(s.topic, mqttQoS, true, payload)
+}
+
+// boilerState returns feedback of the heat source, which serves the load.
+func (c *ThermoController) boilerState() opentherm.BoilerState {
+	if c.cascade != nil {
+		return c.cascade.serving().boiler.State()
+	}
+	return c.boiler.State()
+}
+
+// defrosting reports whether heat pump, which serves the load, defrosts.
+func (c *ThermoController) defrosting() bool {
+	if c.cascade != nil {
+		h := c.cascade.serving()
+		return h.heatPump != nil && h.heatPump.defrosting()
+	}
+	return c.heatPump != nil && c.heatPump.defrosting()
+}
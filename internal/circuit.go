@@ -0,0 +1,246 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"encoding/json"
+	"maps"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+
+	"github.com/antst/mzotbc/internal/config"
+	"github.com/antst/mzotbc/internal/logger"
+	"github.com/antst/mzotbc/internal/safe_mqtt"
+)
+
+// circuitFlowTimeout is an age of the flow temperature, after which mixing valve holds its position
+const circuitFlowTimeout = 10 * time.Minute
+
+// circuitReport is published to `<control_topic>/circuit/<name>/status`.
+type circuitReport struct {
+	Mixed    bool     `json:"mixed"`
+	Demand   bool     `json:"demand"`
+	Target   float64  `json:"target"`
+	Flow     *float64 `json:"flow,omitempty"`
+	Position *float64 `json:"position,omitempty"`
+}
+
+// heatingCircuit is a group of zones with own flow temperature target. Mixed circuit keeps
+// its flow temperature at the target with PI loop, driving the mixing valve.
+type heatingCircuit struct {
+	name        string
+	cfg         *config.CircuitConfig
+	mqtt        safe_mqtt.MqttClient
+	statusTopic string
+	zones       []*ZoneController
+	valve       *mixingValve
+
+	mu       sync.Mutex
+	target   float64
+	demand   bool
+	flow     float64
+	flowAt   time.Time
+	integral float64
+	last     circuitReport
+}
+
+func newHeatingCircuit(
+	_name string, _cfg *config.CircuitConfig, _mqttCfg *config.MQTTConfig, _zones []*ZoneController,
+) *heatingCircuit {
+	h := &heatingCircuit{
+		name:        _name,
+		cfg:         _cfg,
+		mqtt:        safe_mqtt.InitMQTTClient(_mqttCfg.URL, "otbs-circuit-"+uuid.New().String()),
+		statusTopic: _mqttCfg.ControlTopic + "/circuit/" + _name + "/status",
+		zones:       _zones,
+		target:      fallbackTSet,
+	}
+	if _cfg.Flow != nil {
+		h.mqtt.SafeSubscribe(_cfg.Flow.Topic, mqttQoS, h.flowHandler)
+	}
+	if _cfg.Valve != nil {
+		h.valve = newMixingValve(_name, _cfg.Valve, h.mqtt)
+		go h.run()
+	}
+	return h
+}
+
+func (h *heatingCircuit) flowHandler(client mqtt.Client, message mqtt.Message) {
+	v, err := extractF64PlainOrJson(message, h.cfg.Flow.JSONEntry)
+	if err != nil {
+		logger.L().Error(err)
+		return
+	}
+	h.mu.Lock()
+	h.flow, h.flowAt = v**h.cfg.Flow.Scale+*h.cfg.Flow.Offset, clk.Now()
+	h.mu.Unlock()
+}
+
+// setTarget sets flow temperature target of the circuit from Tset of its zones,
+// and returns it, capped by the circuit max flow.
+func (h *heatingCircuit) setTarget(tSet float64) (float64, bool) {
+	demand := tSet >= minEnableTemp
+	target := min(tSet, *h.cfg.MaxFlow)
+
+	h.mu.Lock()
+	if demand != h.demand || target != h.target {
+		logger.L().Infof("Circuit `%s`: target %.1f, demand: %v", h.name, target, demand)
+	}
+	h.target, h.demand = target, demand
+	h.mu.Unlock()
+
+	h.publish()
+	return target, demand
+}
+
+func (h *heatingCircuit) run() {
+	ticker := newTicker(h.cfg.Valve.Interval)
+	defer ticker.Stop()
+	last := clk.Now()
+	for range ticker.C {
+		now := clk.Now()
+		h.control(now, now.Sub(last))
+		last = now
+	}
+}
+
+// control is a step of PI loop of the mixing valve. Integral doesn't grow while the valve is
+// saturated in the direction of the error, without demand the valve is closed.
+func (h *heatingCircuit) control(now time.Time, dt time.Duration) {
+	h.mu.Lock()
+	if !h.demand {
+		h.integral = 0
+		h.mu.Unlock()
+		h.valve.set(0)
+		h.publish()
+		return
+	}
+	if h.flowAt.IsZero() || now.Sub(h.flowAt) > circuitFlowTimeout {
+		h.mu.Unlock()
+		logger.L().Debugf("Circuit `%s`: no recent flow temperature, valve holds position", h.name)
+		return
+	}
+
+	e := h.target - h.flow
+	kp, ki := *h.cfg.Valve.Kp, *h.cfg.Valve.Ki
+	out := kp*e + h.integral
+	if !(out >= 100 && e > 0) && !(out <= 0 && e < 0) {
+		h.integral = min(max(h.integral+ki*e*dt.Minutes(), 0), 100)
+	}
+	pos := min(max(kp*e+h.integral, 0), 100)
+	h.mu.Unlock()
+
+	h.valve.set(pos)
+	h.publish()
+}
+
+// publish publishes circuit state, if it has changed.
+func (h *heatingCircuit) publish() {
+	h.mu.Lock()
+	r := circuitReport{Mixed: h.valve != nil, Demand: h.demand, Target: h.target}
+	if !h.flowAt.IsZero() {
+		r.Flow = config.GetPTR(math.Round(h.flow*10) / 10)
+	}
+	if h.valve != nil {
+		r.Position = config.GetPTR(math.Round(h.valve.currentPosition()))
+	}
+	if r.equal(&h.last) {
+		h.mu.Unlock()
+		return
+	}
+	h.last = r
+	h.mu.Unlock()
+
+	payload, err := json.Marshal(r)
+	if err != nil {
+		logger.L().Error(err)
+		return
+	}
+	h.mqtt.SafePublish(h.statusTopic, mqttQoS, true, payload)
+}
+
+func (r *circuitReport) equal(o *circuitReport) bool {
+	eq := func(a, b *float64) bool { return (a == nil) == (b == nil) && (a == nil || *a == *b) }
+	return r.Mixed == o.Mixed && r.Demand == o.Demand && r.Target == o.Target &&
+		eq(r.Flow, o.Flow) && eq(r.Position, o.Position)
+}
+
+func (c *ThermoController) initializeCircuits() {
+	names := make([]string, 0, len(c.cfg.Circuits))
+	for name := range c.cfg.Circuits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	assigned := make(map[string]string)
+	for _, name := range names {
+		cfg := c.cfg.Circuits[name]
+		if cfg.Valve != nil && cfg.Flow == nil {
+			logger.L().Panicf("Circuit `%s` has mixing valve, but no flow sensor", name)
+		}
+		zones := make([]*ZoneController, 0, len(cfg.Zones))
+		for _, zn := range cfg.Zones {
+			zone, ok := c.zones[zn]
+			if !ok {
+				logger.L().Panicf("Circuit `%s` refers to unknown zone `%s`", name, zn)
+			}
+			if other, ok := assigned[zn]; ok {
+				logger.L().Panicf("Zone `%s` is in circuits `%s` and `%s`", zn, other, name)
+			}
+			assigned[zn] = name
+			zones = append(zones, zone)
+		}
+		c.circuits = append(c.circuits, newHeatingCircuit(name, cfg, c.cfg.MQTTConfig, zones))
+	}
+}
+
+// circuitSetpoint returns boiler Tset, which is the highest demand of circuits. Mixed circuits
+// need a margin above their flow target, zones outside of circuits are on the direct boiler flow.
+func (c *ThermoController) circuitSetpoint(now time.Time) float64 {
+	if len(c.circuits) == 0 {
+		return aggregateTsets(c.zoneTRs, now)
+	}
+
+	tSet := defaultTSet
+	direct := maps.Clone(c.zoneTRs)
+	for _, h := range c.circuits {
+		trs := make(map[*ZoneController]float64, len(h.zones))
+		for _, zone := range h.zones {
+			trs[zone] = c.zoneTRs[zone]
+			delete(direct, zone)
+		}
+		target, demand := h.setTarget(aggregateTsets(trs, now))
+		if !demand {
+			continue
+		}
+		if h.valve != nil {
+			target += *c.cfg.CircuitMargin
+		}
+		tSet = max(tSet, target)
+	}
+	if len(direct) > 0 {
+		tSet = max(tSet, aggregateTsets(direct, now))
+	}
+	return boundTset(tSet)
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"sync"
+	"time"
+)
+
+// minRealDuration keeps scaled timers from becoming zero.
+const minRealDuration = time.Microsecond
+
+// clock is a time source of the controller. Simulation replaces it with an
+// accelerated one, so timestamps and all timers run faster together.
+type clock interface {
+	Now() time.Time
+	// Real converts controller duration into the wall-clock duration
+	Real(d time.Duration) time.Duration
+}
+
+var clk clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                     { return time.Now() }
+func (realClock) Real(d time.Duration) time.Duration { return d }
+
+// scaledClock runs `speed` times faster than the wall clock, starting at `start`.
+type scaledClock struct {
+	start  time.Time
+	origin time.Time
+	speed  float64
+}
+
+func newScaledClock(start time.Time, speed float64) *scaledClock {
+	return &scaledClock{start: start, origin: time.Now(), speed: speed}
+}
+
+func (c *scaledClock) Now() time.Time {
+	return c.start.Add(time.Duration(float64(time.Since(c.origin)) * c.speed))
+}
+
+func (c *scaledClock) Real(d time.Duration) time.Duration {
+	return max(time.Duration(float64(d)/c.speed), minRealDuration)
+}
+
+func newTicker(d time.Duration) *time.Ticker {
+	return time.NewTicker(clk.Real(d))
+}
+
+func newTimer(d time.Duration) *time.Timer {
+	return time.NewTimer(clk.Real(d))
+}
+
+func afterFunc(d time.Duration, f func()) *time.Timer {
+	return time.AfterFunc(clk.Real(d), f)
+}
+
+// manualClock shows time, which is set explicitly, e.g. moments of recorded history.
+type manualClock struct {
+	mu sync.RWMutex
+	t  time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.t
+}
+
+func (c *manualClock) Real(d time.Duration) time.Duration { return d }
+
+func (c *manualClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = t
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import "time"
+
+const (
+	defaultAutotuneLearningRate = 0.5
+	defaultAutotuneMaxStep      = 0.5
+	defaultAutotuneMin          = 5.0
+	defaultAutotuneMax          = 40.0
+	defaultAutotuneDeadband     = 0.1
+	defaultAutotuneInterval     = 24 * time.Hour
+	defaultAutotuneSettle       = 2 * time.Hour
+	defaultAutotuneMinSamples   = 36
+)
+
+// AutotuneConfig configures automatic tuning of zone heating parameters. Once per Interval,
+// heating parameter is moved by LearningRate × average (setpoint - temperature) error
+// of steady-state periods, but not more than MaxStep and within [Min, Max].
+type AutotuneConfig struct {
+	// Enabled turns tuning on for all zones, zones can override it with `autotune`
+	Enabled      bool     `yaml:"enabled"`
+	LearningRate *float64 `yaml:"learning_rate"`
+	MaxStep      *float64 `yaml:"max_step"`
+	Min          *float64 `yaml:"min"`
+	Max          *float64 `yaml:"max"`
+	// Deadband is an average error, which is considered good enough
+	Deadband *float64      `yaml:"deadband"`
+	Interval time.Duration `yaml:"interval"`
+	// Settle is how long setpoint must stay unchanged, before zone is considered steady
+	Settle time.Duration `yaml:"settle"`
+	// MinSamples is a number of steady history records needed for a step
+	MinSamples int `yaml:"min_samples"`
+}
+
+func (c *AutotuneConfig) FillDefaults() {
+	if c.LearningRate == nil {
+		c.LearningRate = GetPTR(defaultAutotuneLearningRate)
+	}
+	if c.MaxStep == nil {
+		c.MaxStep = GetPTR(defaultAutotuneMaxStep)
+	}
+	if c.Min == nil {
+		c.Min = GetPTR(defaultAutotuneMin)
+	}
+	if c.Max == nil {
+		c.Max = GetPTR(defaultAutotuneMax)
+	}
+	if c.Deadband == nil {
+		c.Deadband = GetPTR(defaultAutotuneDeadband)
+	}
+	if c.Interval == 0 {
+		c.Interval = defaultAutotuneInterval
+	}
+	if c.Settle == 0 {
+		c.Settle = defaultAutotuneSettle
+	}
+	if c.MinSamples == 0 {
+		c.MinSamples = defaultAutotuneMinSamples
+	}
+}
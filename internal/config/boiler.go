@@ -18,12 +18,125 @@
 
 package config
 
-import "time"
+import (
+	"time"
+
+	"github.com/antst/mzotbc/internal/logger"
+)
+
+// Boiler driver types
+const (
+	BoilerTypeTemplate = "template"
+	BoilerTypeOTGW     = "otgw"
+	BoilerTypeOTGWMQTT = "otgw-mqtt"
+	BoilerTypeEMSESP   = "ems-esp"
+	BoilerTypeESPHome  = "esphome"
+)
+
+// Boiler commands
+const (
+	BoilerCmdTSet        = "tset"
+	BoilerCmdCHEnable    = "ch_enable"
+	BoilerCmdDHWSetpoint = "dhw_setpoint"
+	BoilerCmdDHWEnable   = "dhw_enable"
+	BoilerCmdMaxMod      = "max_modulation"
+)
+
+const (
+	tsetPayload     = `{{printf "%.1f" .Value}}`
+	chEnablePayload = `{{if .On}}1{{else}}0{{end}}`
+	onOffPayload    = `{{if .On}}ON{{else}}OFF{{end}}`
+	levelPayload    = `{{printf "%.0f" .Value}}`
+)
+
+// boilerPresets are command templates of the common gateways
+var boilerPresets = map[string]map[string]CommandTemplate{
+	// OTGW firmware with MQTT, base topic is e.g. `OTGW/set/otgw`
+	BoilerTypeOTGWMQTT: {
+		BoilerCmdTSet:        {Topic: "{{.Base}}/ctrlsetpt", Payload: tsetPayload},
+		BoilerCmdCHEnable:    {Topic: "{{.Base}}/chenable", Payload: chEnablePayload},
+		BoilerCmdDHWSetpoint: {Topic: "{{.Base}}/maxdhwsetpt", Payload: tsetPayload},
+		BoilerCmdDHWEnable:   {Topic: "{{.Base}}/hotwater", Payload: chEnablePayload},
+		BoilerCmdMaxMod:      {Topic: "{{.Base}}/maxmodulation", Payload: levelPayload},
+	},
+	// EMS-ESP (Bosch/Buderus/Nefit), base topic is e.g. `ems-esp`
+	BoilerTypeEMSESP: {
+		BoilerCmdTSet: {
+			Topic: "{{.Base}}/boiler", Payload: `{"cmd":"selflowtemp","value":{{printf "%.0f" .Value}}}`,
+		},
+		BoilerCmdCHEnable: {
+			Topic:   "{{.Base}}/boiler",
+			Payload: `{"cmd":"heatingactivated","value":"{{if .On}}on{{else}}off{{end}}"}`,
+		},
+		BoilerCmdDHWSetpoint: {
+			Topic: "{{.Base}}/boiler", Payload: `{"cmd":"wwseltemp","value":{{printf "%.0f" .Value}}}`,
+		},
+		BoilerCmdDHWEnable: {
+			Topic:   "{{.Base}}/boiler",
+			Payload: `{"cmd":"wwactivated","value":"{{if .On}}on{{else}}off{{end}}"}`,
+		},
+		BoilerCmdMaxMod: {
+			Topic: "{{.Base}}/boiler", Payload: `{"cmd":"burnmaxpower","value":{{printf "%.0f" .Value}}}`,
+		},
+	},
+	// ESPHome OpenTherm component, base topic is the node name
+	BoilerTypeESPHome: {
+		BoilerCmdTSet:        {Topic: "{{.Base}}/number/t_set/command", Payload: tsetPayload},
+		BoilerCmdCHEnable:    {Topic: "{{.Base}}/switch/ch_enable/command", Payload: onOffPayload},
+		BoilerCmdDHWSetpoint: {Topic: "{{.Base}}/number/t_dhw_set/command", Payload: tsetPayload},
+		BoilerCmdDHWEnable:   {Topic: "{{.Base}}/switch/dhw_enable/command", Payload: onOffPayload},
+		BoilerCmdMaxMod:      {Topic: "{{.Base}}/number/max_rel_mod_level/command", Payload: levelPayload},
+	},
+}
+
+// defaultUpdateInterval is a default keepalive of boiler commands
+const defaultUpdateInterval = 30 * time.Second
 
 type BoilerConfig struct {
-	TSetTopic      string        `yaml:"tset_topic"`
-	CHEnableTopic  string        `yaml:"ch_enable_topic,omitempty"`
+	// Type of the driver, used to talk to the boiler. When empty, it is `otgw` if
+	// `otgw` section is present, and `template` with TSetTopic/CHEnableTopic otherwise.
+	Type          string `yaml:"type,omitempty"`
+	BaseTopic     string `yaml:"base_topic,omitempty"`
+	TSetTopic     string `yaml:"tset_topic"`
+	CHEnableTopic string `yaml:"ch_enable_topic,omitempty"`
+	MaxModTopic   string `yaml:"max_modulation_topic,omitempty"`
+	// UpdateInterval is a keepalive, commands are resent that often
 	UpdateInterval time.Duration `yaml:"update_interval"`
+	OTGW           *OTGWConfig   `yaml:"otgw,omitempty"`
+	// Templates override (or, for `template` type, define) commands of the driver
+	Templates map[string]*CommandTemplate `yaml:"templates,omitempty"`
+	// MessageTopic carries raw OpenTherm messages, as logged by OTGW or ESP gateways
+	MessageTopic string `yaml:"message_topic,omitempty"`
+	// MaxModulation enables max relative modulation control
+	MaxModulation *MaxModulationConfig `yaml:"max_modulation,omitempty"`
+	// HeatPump switches heat source to a heat pump
+	HeatPump *HeatPumpConfig `yaml:"heat_pump,omitempty"`
+	// Verify enables verification of commands by the control setpoint, reported back by the gateway
+	Verify *VerifyConfig `yaml:"verify,omitempty"`
+	// Alarms tunes fault, water pressure and flame alarms
+	Alarms *BoilerAlarmConfig `yaml:"alarms,omitempty"`
+	// ReturnLimit reduces Tset, while return temperature is too high for condensing
+	ReturnLimit *ReturnLimitConfig `yaml:"return_limit,omitempty"`
+	// Sources make a cascade of heat sources, driver settings above are not used then
+	Sources []*HeatSourceConfig `yaml:"sources,omitempty"`
+	// Sequencing selects sources of the cascade, which serve the load
+	Sequencing *SequencingConfig `yaml:"sequencing,omitempty"`
+}
+
+// CommandTemplate defines MQTT topic and payload of the boiler command as Go templates.
+// Templates are executed with `.Base` (base topic), `.Value` (float) and `.On` (bool).
+type CommandTemplate struct {
+	Topic   string `yaml:"topic"`
+	Payload string `yaml:"payload"`
+}
+
+// OTGWConfig configures direct connection to the OpenTherm Gateway,
+// either over serial-over-TCP (`address`) or local serial `device`.
+type OTGWConfig struct {
+	Address         string        `yaml:"address,omitempty"`
+	Device          string        `yaml:"device,omitempty"`
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+	CommandTimeout  time.Duration `yaml:"command_timeout,omitempty"`
 }
 
 func NewBoilerConfig() *BoilerConfig {
@@ -32,3 +145,93 @@ func NewBoilerConfig() *BoilerConfig {
 	cfg.CHEnableTopic = "test_OTGW/set/otgw/ch_enable"
 	return cfg
 }
+
+func (c *BoilerConfig) FillDefaults() {
+	if c.MaxModulation != nil {
+		c.MaxModulation.FillDefaults()
+	}
+	if c.HeatPump != nil {
+		c.HeatPump.FillDefaults()
+	}
+	if c.UpdateInterval == 0 {
+		c.UpdateInterval = defaultUpdateInterval
+	}
+	if c.Verify != nil {
+		c.Verify.FillDefaults()
+	}
+	if c.Alarms == nil {
+		c.Alarms = &BoilerAlarmConfig{}
+	}
+	c.Alarms.FillDefaults()
+	if c.ReturnLimit != nil {
+		c.ReturnLimit.FillDefaults()
+	}
+	for _, src := range c.Sources {
+		if src.MaxModulation == nil {
+			src.MaxModulation = c.MaxModulation
+		}
+		if src.UpdateInterval == 0 {
+			src.UpdateInterval = c.UpdateInterval
+		}
+		src.FillDefaults()
+	}
+	if len(c.Sources) > 0 && c.Sequencing == nil {
+		c.Sequencing = &SequencingConfig{}
+	}
+	if c.Sequencing != nil {
+		c.Sequencing.FillDefaults()
+	}
+	if c.Type == "" {
+		if c.OTGW != nil {
+			c.Type = BoilerTypeOTGW
+		} else {
+			c.Type = BoilerTypeTemplate
+		}
+	}
+	if c.Verify != nil && c.Type != BoilerTypeOTGW && c.MessageTopic == "" {
+		// only OTGW status and OpenTherm message log report control setpoint back
+		logger.L().Panicf("Boiler `verify` needs setpoint feedback: `otgw` type or `message_topic`")
+	}
+	if c.Templates == nil {
+		c.Templates = make(map[string]*CommandTemplate)
+	}
+
+	if c.Type == BoilerTypeTemplate {
+		// legacy configuration with plain topics
+		if _, ok := c.Templates[BoilerCmdTSet]; !ok && c.TSetTopic != "" {
+			c.Templates[BoilerCmdTSet] = &CommandTemplate{Topic: c.TSetTopic, Payload: tsetPayload}
+		}
+		if _, ok := c.Templates[BoilerCmdCHEnable]; !ok && c.CHEnableTopic != "" {
+			c.Templates[BoilerCmdCHEnable] = &CommandTemplate{Topic: c.CHEnableTopic, Payload: chEnablePayload}
+		}
+		if _, ok := c.Templates[BoilerCmdMaxMod]; !ok && c.MaxModTopic != "" {
+			c.Templates[BoilerCmdMaxMod] = &CommandTemplate{Topic: c.MaxModTopic, Payload: levelPayload}
+		}
+		return
+	}
+
+	// explicitly configured templates take precedence over the preset
+	for cmd, t := range boilerPresets[c.Type] {
+		if _, ok := c.Templates[cmd]; !ok {
+			c.Templates[cmd] = &CommandTemplate{Topic: t.Topic, Payload: t.Payload}
+		}
+	}
+}
+
+// KeepaliveInterval returns how often commands are resent: the update interval of the boiler,
+// or the shortest one of the cascade sources.
+func (c *BoilerConfig) KeepaliveInterval() time.Duration {
+	d := c.UpdateInterval
+	for i, src := range c.Sources {
+		if i == 0 || src.UpdateInterval < d {
+			d = src.UpdateInterval
+		}
+	}
+	return d
+}
+
+// KnownBoilerType reports whether driver type is supported.
+func KnownBoilerType(t string) bool {
+	_, ok := boilerPresets[t]
+	return ok || t == BoilerTypeTemplate || t == BoilerTypeOTGW
+}
@@ -0,0 +1,43 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import "time"
+
+const (
+	defaultAlarmMinPressure = 0.8
+	defaultAlarmFlameGrace  = 30 * time.Minute
+)
+
+// BoilerAlarmConfig tunes boiler alarms: water pressure below `min_pressure` (bar), and
+// no flame for `flame_grace`, while boiler is in CH mode and flow is below control setpoint.
+// Grace time is longer than anti-cycling pauses, gas/flame fault of the boiler raises it at once.
+type BoilerAlarmConfig struct {
+	MinPressure *float64      `yaml:"min_pressure"`
+	FlameGrace  time.Duration `yaml:"flame_grace"`
+}
+
+func (c *BoilerAlarmConfig) FillDefaults() {
+	if c.MinPressure == nil {
+		c.MinPressure = GetPTR(defaultAlarmMinPressure)
+	}
+	if c.FlameGrace == 0 {
+		c.FlameGrace = defaultAlarmFlameGrace
+	}
+}
@@ -0,0 +1,59 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import "time"
+
+const (
+	defaultVerifyTimeout     = time.Minute
+	defaultVerifyTolerance   = 0.5
+	defaultVerifyMaxFailures = 3
+	defaultVerifyBackoff     = 10 * time.Second
+	defaultVerifyMaxBackoff  = 5 * time.Minute
+)
+
+// VerifyConfig enables verification of boiler commands: control setpoint, reported back by
+// the gateway, must match the sent Tset within `tolerance` in `timeout`. Otherwise command is
+// resent with backoff, from `backoff` doubling up to `max_backoff`, and after `max_failures`
+// failures in a row "boiler not following commands" alarm is raised.
+type VerifyConfig struct {
+	Timeout     time.Duration `yaml:"timeout"`
+	Tolerance   *float64      `yaml:"tolerance"`
+	MaxFailures int           `yaml:"max_failures"`
+	Backoff     time.Duration `yaml:"backoff"`
+	MaxBackoff  time.Duration `yaml:"max_backoff"`
+}
+
+func (c *VerifyConfig) FillDefaults() {
+	if c.Timeout == 0 {
+		c.Timeout = defaultVerifyTimeout
+	}
+	if c.Tolerance == nil {
+		c.Tolerance = GetPTR(defaultVerifyTolerance)
+	}
+	if c.MaxFailures == 0 {
+		c.MaxFailures = defaultVerifyMaxFailures
+	}
+	if c.Backoff == 0 {
+		c.Backoff = defaultVerifyBackoff
+	}
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = defaultVerifyMaxBackoff
+	}
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Sequencing strategies of heat sources
+const (
+	SequencingLeadLag       = "lead_lag"
+	SequencingHeatPumpFirst = "heat_pump_first"
+	SequencingCapacity      = "capacity"
+)
+
+const (
+	defaultSourceCapacity      = 10.0
+	defaultRotateAfter         = 24 * time.Hour
+	defaultBivalentTemperature = 0.0
+	defaultStageGap            = 5.0
+	defaultStageDelay          = 15 * time.Minute
+)
+
+// HeatSourceConfig is one of heat sources of a cascade, with own driver and feedback,
+// configured like a single boiler. Source with `heat_pump` section is a heat pump.
+type HeatSourceConfig struct {
+	Name string `yaml:"name"`
+	// Capacity is a nominal output of the source, kW
+	Capacity     *float64 `yaml:"capacity"`
+	BoilerConfig `yaml:",inline"`
+}
+
+// SequencingConfig selects heat sources, which serve the load:
+// `lead_lag` runs the source with the least runtime and rotates the lead, once its runtime
+// is `rotate_after` above the others; `heat_pump_first` runs heat pumps and lets boilers assist
+// below `bivalent_temperature` outside; `capacity` stages sources in configured order, until
+// their capacity covers the load. With every strategy next source is staged, if flow temperature
+// stays `stage_gap` below Tset for `stage_delay`, and staged down, once Tset is reached for as long.
+type SequencingConfig struct {
+	Strategy            string        `yaml:"strategy"`
+	RotateAfter         time.Duration `yaml:"rotate_after"`
+	BivalentTemperature *float64      `yaml:"bivalent_temperature"`
+	StageGap            *float64      `yaml:"stage_gap"`
+	StageDelay          time.Duration `yaml:"stage_delay"`
+}
+
+func (s *SequencingConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain SequencingConfig
+	if err := value.Decode((*plain)(s)); err != nil {
+		return err
+	}
+	switch s.Strategy {
+	case "", SequencingLeadLag, SequencingHeatPumpFirst, SequencingCapacity:
+		return nil
+	}
+	return fmt.Errorf("line %d: unknown sequencing strategy `%s`", value.Line, s.Strategy)
+}
+
+func (c *HeatSourceConfig) FillDefaults() {
+	if c.Capacity == nil {
+		c.Capacity = GetPTR(defaultSourceCapacity)
+	}
+	c.BoilerConfig.FillDefaults()
+}
+
+func (s *SequencingConfig) FillDefaults() {
+	if s.Strategy == "" {
+		s.Strategy = SequencingLeadLag
+	}
+	if s.RotateAfter == 0 {
+		s.RotateAfter = defaultRotateAfter
+	}
+	if s.BivalentTemperature == nil {
+		s.BivalentTemperature = GetPTR(defaultBivalentTemperature)
+	}
+	if s.StageGap == nil {
+		s.StageGap = GetPTR(defaultStageGap)
+	}
+	if s.StageDelay == 0 {
+		s.StageDelay = defaultStageDelay
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ValveThreePoint = "3point"
+	ValveAnalog     = "analog"
+
+	defaultCircuitMargin   = 5.0
+	defaultCircuitMaxFlow  = 75.0
+	defaultValveRunTime    = 150 * time.Second
+	defaultValveMinPulse   = 2 * time.Second
+	defaultValveInterval   = 20 * time.Second
+	defaultValveKp         = 2.0
+	defaultValveKi         = 1.0
+	defaultValveOnPayload  = "ON"
+	defaultValveOffPayload = "OFF"
+)
+
+// CircuitConfig is a heating circuit with its own zones. Direct circuit gets boiler flow,
+// mixed circuit has a mixing valve, which keeps circuit flow temperature at its target.
+type CircuitConfig struct {
+	Zones []string `yaml:"zones"`
+	// MaxFlow caps flow temperature of the circuit
+	MaxFlow *float64 `yaml:"max_flow"`
+	// Flow is a flow temperature sensor of the circuit, after the mixing valve
+	Flow *SensorConfig `yaml:"flow,omitempty"`
+	// Valve is a mixing valve actuator, circuit is direct without it
+	Valve *MixingValveConfig `yaml:"valve,omitempty"`
+}
+
+// MixingValveConfig is a mixing valve actuator: `3point` (open/close motor, driven by pulses)
+// or `analog` (0–100% position output). Position is set by PI loop on the circuit flow temperature.
+type MixingValveConfig struct {
+	Type string `yaml:"type"`
+	// OpenTopic and CloseTopic drive 3-point actuator with OnPayload/OffPayload
+	OpenTopic  string `yaml:"open_topic,omitempty"`
+	CloseTopic string `yaml:"close_topic,omitempty"`
+	OnPayload  string `yaml:"on_payload,omitempty"`
+	OffPayload string `yaml:"off_payload,omitempty"`
+	// RunTime is a full stroke time of 3-point actuator
+	RunTime time.Duration `yaml:"run_time,omitempty"`
+	// MinPulse is the shortest pulse of 3-point actuator
+	MinPulse time.Duration `yaml:"min_pulse,omitempty"`
+	// OutputTopic receives position of analog actuator, 0–100
+	OutputTopic string `yaml:"output_topic,omitempty"`
+	// Interval is a period of the PI loop
+	Interval time.Duration `yaml:"interval"`
+	// Kp is a proportional gain, % per °C
+	Kp *float64 `yaml:"kp"`
+	// Ki is an integral gain, % per °C·min
+	Ki *float64 `yaml:"ki"`
+}
+
+func (v *MixingValveConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain MixingValveConfig
+	if err := value.Decode((*plain)(v)); err != nil {
+		return err
+	}
+	switch v.Type {
+	case ValveThreePoint:
+		if v.OpenTopic == "" || v.CloseTopic == "" {
+			return fmt.Errorf("line %d: 3-point valve needs `open_topic` and `close_topic`", value.Line)
+		}
+	case ValveAnalog:
+		if v.OutputTopic == "" {
+			return fmt.Errorf("line %d: analog valve needs `output_topic`", value.Line)
+		}
+	default:
+		return fmt.Errorf("line %d: unknown valve type `%s`", value.Line, v.Type)
+	}
+	return nil
+}
+
+func (c *CircuitConfig) FillDefaults() {
+	if c.MaxFlow == nil {
+		c.MaxFlow = GetPTR(defaultCircuitMaxFlow)
+	}
+	if c.Flow != nil {
+		c.Flow.FillDefaults()
+	}
+	if c.Valve != nil {
+		c.Valve.FillDefaults()
+	}
+}
+
+func (v *MixingValveConfig) FillDefaults() {
+	if v.OnPayload == "" {
+		v.OnPayload = defaultValveOnPayload
+	}
+	if v.OffPayload == "" {
+		v.OffPayload = defaultValveOffPayload
+	}
+	if v.RunTime == 0 {
+		v.RunTime = defaultValveRunTime
+	}
+	if v.MinPulse == 0 {
+		v.MinPulse = defaultValveMinPulse
+	}
+	if v.Interval == 0 {
+		v.Interval = defaultValveInterval
+	}
+	if v.Kp == nil {
+		v.Kp = GetPTR(defaultValveKp)
+	}
+	if v.Ki == nil {
+		v.Ki = GetPTR(defaultValveKi)
+	}
+}
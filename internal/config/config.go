@@ -23,6 +23,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"time"
 
 	"github.com/antst/mzotbc/internal/logger"
 
@@ -58,7 +59,32 @@ type Config struct {
 	DBFile                  string                 `yaml:"db_file"`
 	Boiler                  *BoilerConfig          `yaml:"boiler"`
 	Outside                 *OutsideConfig         `yaml:"outside"`
+	DHW                     *DHWConfig             `yaml:"dhw,omitempty"`
 	Zones                   map[string]*ZoneConfig `yaml:"zones"`
+	// Schedules are named weekly setpoint profiles, zones refer to them by name
+	Schedules map[string][]*ScheduleEntry `yaml:"schedules,omitempty"`
+	// Modes define setpoint adjustments of the operating modes, zones can override them
+	Modes map[string]*ModeConfig `yaml:"modes,omitempty"`
+	// Presence is a house level occupancy, it applies to all zones
+	Presence *PresenceConfig `yaml:"presence,omitempty"`
+	// HistoryInterval is how often zone history is recorded to DB, negative disables recording
+	HistoryInterval time.Duration `yaml:"history_interval"`
+	// OptimumStart enables early start of heating before scheduled setpoint changes
+	OptimumStart *OptimumStartConfig `yaml:"optimum_start,omitempty"`
+	// Autotune adjusts zone heating parameters from the recorded history
+	Autotune *AutotuneConfig `yaml:"autotune,omitempty"`
+	// Simulation configures building model of `simulate` mode
+	Simulation *SimulationConfig `yaml:"simulation,omitempty"`
+	// HeatingModel is a heating curve, fitted from recorded history
+	HeatingModel *HeatingModelConfig `yaml:"heating_model,omitempty"`
+	// MPC replaces heating curve with model predictive control, once zone models are trained
+	MPC *MPCConfig `yaml:"mpc,omitempty"`
+	// Circuits are heating circuits, zones outside of them are on the direct boiler flow
+	Circuits map[string]*CircuitConfig `yaml:"circuits,omitempty"`
+	// CircuitMargin is added to the target of mixed circuits for the boiler Tset
+	CircuitMargin *float64 `yaml:"circuit_margin,omitempty"`
+	// HTTP enables HTTP API, e.g. audit log of control changes
+	HTTP *HTTPConfig `yaml:"http,omitempty"`
 }
 
 func defConfig() *Config {
@@ -87,6 +113,41 @@ func (cfg *Config) FillDefaults() {
 		v.FillDefaults()
 	}
 	cfg.Outside.FillDefaults()
+	cfg.Boiler.FillDefaults()
+	if cfg.DHW != nil {
+		cfg.DHW.FillDefaults()
+	}
+	cfg.Modes = fillModesDefaults(cfg.Modes)
+	if cfg.Presence != nil {
+		cfg.Presence.FillDefaults()
+	}
+	if cfg.HistoryInterval == 0 {
+		cfg.HistoryInterval = defaultHistoryInterval
+	}
+	if cfg.OptimumStart != nil {
+		cfg.OptimumStart.FillDefaults()
+	}
+	if cfg.Autotune != nil {
+		cfg.Autotune.FillDefaults()
+	}
+	if cfg.Simulation != nil {
+		cfg.Simulation.FillDefaults(cfg.Zones)
+	}
+	if cfg.HeatingModel != nil {
+		cfg.HeatingModel.FillDefaults()
+	}
+	if cfg.MPC != nil {
+		cfg.MPC.FillDefaults()
+	}
+	for _, c := range cfg.Circuits {
+		c.FillDefaults()
+	}
+	if cfg.CircuitMargin == nil {
+		cfg.CircuitMargin = GetPTR(defaultCircuitMargin)
+	}
+	if cfg.HTTP != nil {
+		cfg.HTTP.FillDefaults()
+	}
 
 	if cfg.DefaultHeatingParameter == nil {
 		cfg.DefaultHeatingParameter = &defaultHeatingParam
@@ -96,15 +157,16 @@ func (cfg *Config) FillDefaults() {
 func Get() *Config {
 	cfg := defConfig()
 	logLevel := getopt.StringLong("log-level", 'l', "", "log levels: debug, info, warn, error, dpanic, panic, fatal")
-	configFile := getopt.StringLong("config", 'c', defaultConfigFile, "config file pathname")
+	configFlag := getopt.StringLong("config", 'c', defaultConfigFile, "config file pathname")
 
 	getopt.Parse()
+	configFile = *configFlag
 
-	if err := readFile(cfg, *configFile); err != nil {
+	if err := readFile(cfg, configFile); err != nil {
 		log.Panicf("GetConfig: %v", err)
 	}
 
-	logger.L().Infof("Using config file `%v`", *configFile)
+	logger.L().Infof("Using config file `%v`", configFile)
 	dbFile := getopt.StringLong("db", 'd', cfg.DBFile, "DB file pathname")
 	logger.L().Infof("Using DB file `%v`", cfg.DBFile)
 
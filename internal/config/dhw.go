@@ -0,0 +1,96 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultDHWSetpoint         = 50.0
+	defaultLegionellaSetpoint  = 65.0
+	defaultLegionellaDuration  = time.Hour
+	defaultLegionellaStartTime = 2 * 60 // 02:00
+)
+
+// DHWConfig configures domestic hot water control
+type DHWConfig struct {
+	Setpoint *float64 `yaml:"setpoint"`
+	// Schedule is a list of windows when DHW is enabled, empty schedule means always enabled
+	Schedule   []*TimeWindow     `yaml:"schedule,omitempty"`
+	Legionella *LegionellaConfig `yaml:"legionella,omitempty"`
+	// Priority pauses CH demand while boiler heats DHW
+	Priority bool `yaml:"priority"`
+	// ActiveTopic reports whether DHW is being heated, boiler state is used if it is not set
+	ActiveTopic     string  `yaml:"active_topic,omitempty"`
+	ActiveJSONEntry *string `yaml:"active_json_entry,omitempty"`
+}
+
+// LegionellaConfig configures weekly thermal disinfection cycle
+type LegionellaConfig struct {
+	Day      Weekdays      `yaml:"day"`
+	Start    *ClockTime    `yaml:"start"`
+	Setpoint *float64      `yaml:"setpoint"`
+	Duration time.Duration `yaml:"duration"`
+}
+
+// UnmarshalYAML rejects durations, which don't make a daily window: cycle must end
+// on another minute of the same or the next day.
+func (l *LegionellaConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain LegionellaConfig
+	if err := value.Decode((*plain)(l)); err != nil {
+		return err
+	}
+	if l.Duration != 0 && (l.Duration < time.Minute || l.Duration >= 24*time.Hour) {
+		return fmt.Errorf("line %d: legionella `duration` must be from 1m to under 24h, got %v", value.Line, l.Duration)
+	}
+	return nil
+}
+
+func (c *DHWConfig) FillDefaults() {
+	if c.Setpoint == nil {
+		c.Setpoint = GetPTR(defaultDHWSetpoint)
+	}
+	if l := c.Legionella; l != nil {
+		if len(l.Day) == 0 {
+			l.Day = Weekdays{time.Sunday}
+		}
+		if l.Start == nil {
+			l.Start = GetPTR(ClockTime(defaultLegionellaStartTime))
+		}
+		if l.Setpoint == nil {
+			l.Setpoint = GetPTR(defaultLegionellaSetpoint)
+		}
+		if l.Duration == 0 {
+			l.Duration = defaultLegionellaDuration
+		}
+	}
+}
+
+// Window returns legionella cycle window.
+func (l *LegionellaConfig) Window() *TimeWindow {
+	return &TimeWindow{
+		Days: l.Day,
+		From: *l.Start,
+		To:   ClockTime((int(*l.Start) + int(l.Duration/time.Minute)) % (24 * 60)),
+	}
+}
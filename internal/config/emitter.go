@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	EmitterRadiator   = "radiator"
+	EmitterUnderfloor = "underfloor"
+	EmitterConvector  = "convector"
+)
+
+// emitterDefaults are typical properties of the emitter types. Underfloor heating
+// needs lower flow temperature and reacts in hours, convectors react in minutes.
+var emitterDefaults = map[string]EmitterConfig{
+	EmitterRadiator: {
+		MaxFlow: GetPTR(75.0), TimeConstant: 0, Deadband: GetPTR(0.0),
+	},
+	EmitterUnderfloor: {
+		HeatingParameter: GetPTR(8.0), MaxFlow: GetPTR(45.0), TimeConstant: 3 * time.Hour, Deadband: GetPTR(0.3),
+	},
+	EmitterConvector: {
+		HeatingParameter: GetPTR(12.0), MaxFlow: GetPTR(65.0), TimeConstant: 10 * time.Minute, Deadband: GetPTR(0.2),
+	},
+}
+
+// EmitterConfig describes heat emitter of the zone. It is configured either by type only
+// (`emitter: underfloor`), or as a map, which overrides defaults of the type.
+type EmitterConfig struct {
+	Type string `yaml:"type"`
+	// HeatingParameter is a default heating curve of the emitter, zone `heating_parameter` overrides it
+	HeatingParameter *float64 `yaml:"heating_parameter,omitempty"`
+	// MaxFlow caps Tset, requested by the zone
+	MaxFlow *float64 `yaml:"max_flow"`
+	// TimeConstant is a response time of the emitter, zone Tset is smoothed with it,
+	// zones with slow emitters aren't dropped from the boiler Tset average by spikes of fast ones
+	TimeConstant time.Duration `yaml:"time_constant"`
+	// Deadband is a temperature error, which room compensation ignores
+	Deadband *float64 `yaml:"deadband"`
+}
+
+func (e *EmitterConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		e.Type = value.Value
+	} else {
+		type plain EmitterConfig
+		if err := value.Decode((*plain)(e)); err != nil {
+			return err
+		}
+	}
+	if _, ok := emitterDefaults[e.Type]; !ok && e.Type != "" {
+		return fmt.Errorf("line %d: unknown emitter type `%s`", value.Line, e.Type)
+	}
+	return nil
+}
+
+func (e *EmitterConfig) FillDefaults() {
+	if e.Type == "" {
+		e.Type = EmitterRadiator
+	}
+	def := emitterDefaults[e.Type]
+	if e.HeatingParameter == nil && def.HeatingParameter != nil {
+		e.HeatingParameter = GetPTR(*def.HeatingParameter)
+	}
+	if e.MaxFlow == nil {
+		e.MaxFlow = GetPTR(*def.MaxFlow)
+	}
+	if e.TimeConstant == 0 {
+		e.TimeConstant = def.TimeConstant
+	}
+	if e.Deadband == nil {
+		e.Deadband = GetPTR(*def.Deadband)
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import "time"
+
+const (
+	defaultHeatPumpMinFlow    = 25.0
+	defaultHeatPumpMaxFlow    = 55.0
+	defaultHeatPumpMinRun     = 20 * time.Minute
+	defaultHeatPumpMinOff     = 10 * time.Minute
+	defaultSGReadyBoostOffset = 5.0
+)
+
+// HeatPumpConfig switches heat source to a heat pump, which takes flow temperature setpoint
+// through the boiler driver (e.g. `template` type for MQTT, or MQTT-Modbus bridge).
+// Zones use low temperature heating curve family, boiler Tset is a weighted mean of
+// zone demands, so the compressor runs long at low flow temperature instead of
+// following the most demanding zone.
+type HeatPumpConfig struct {
+	// MinFlow is the lowest flow temperature, below which there is no demand
+	MinFlow *float64 `yaml:"min_flow"`
+	// MaxFlow caps flow temperature
+	MaxFlow *float64 `yaml:"max_flow"`
+	// MinRun and MinOff are minimal compressor run and off times
+	MinRun time.Duration `yaml:"min_run"`
+	MinOff time.Duration `yaml:"min_off"`
+	// Flow is a flow temperature feedback, if boiler driver doesn't provide it
+	Flow *SensorConfig `yaml:"flow,omitempty"`
+	// Defrost is a flag, which is set while heat pump defrosts, flow temperature dips
+	// are ignored and commands are held meanwhile
+	Defrost *ContactConfig `yaml:"defrost,omitempty"`
+	// SGReady is a Smart Grid Ready input
+	SGReady *SGReadyConfig `yaml:"sg_ready,omitempty"`
+}
+
+// SGReadyConfig is a Smart Grid Ready input: state 1 (or `block`) blocks the heat pump,
+// 2 (`normal`) is a normal operation, 3 (`boost`) raises flow temperature by BoostOffset,
+// 4 (`force`) raises it by twice the offset and runs the heat pump even without demand.
+// Boosted flow temperature is still capped by MaxFlow.
+type SGReadyConfig struct {
+	Topic       string   `yaml:"topic"`
+	JSONEntry   *string  `yaml:"json_entry,omitempty"`
+	BoostOffset *float64 `yaml:"boost_offset"`
+}
+
+func (c *HeatPumpConfig) FillDefaults() {
+	if c.MinFlow == nil {
+		c.MinFlow = GetPTR(defaultHeatPumpMinFlow)
+	}
+	if c.MaxFlow == nil {
+		c.MaxFlow = GetPTR(defaultHeatPumpMaxFlow)
+	}
+	if c.MinRun == 0 {
+		c.MinRun = defaultHeatPumpMinRun
+	}
+	if c.MinOff == 0 {
+		c.MinOff = defaultHeatPumpMinOff
+	}
+	if c.Flow != nil {
+		c.Flow.FillDefaults()
+	}
+	if c.SGReady != nil && c.SGReady.BoostOffset == nil {
+		c.SGReady.BoostOffset = GetPTR(defaultSGReadyBoostOffset)
+	}
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	HeatingModelBuiltin = "builtin"
+	HeatingModelFitted  = "fitted"
+
+	defaultHeatingModelType = "polynomial"
+	heatingModelKey         = "heating_model"
+)
+
+// HeatingModelConfig holds heating curve, fitted from recorded history by `mzotbc fit`.
+// Active model (builtin or fitted) can be switched at runtime over control topic.
+type HeatingModelConfig struct {
+	// Type is a term structure of the curve: `polynomial` (same as builtin) or `linear`
+	Type         string    `yaml:"type"`
+	Coefficients []float64 `yaml:"coefficients,flow"`
+	// Active is a model in use, until switched over control topic
+	Active string `yaml:"active"`
+}
+
+func (c *HeatingModelConfig) FillDefaults() {
+	if c.Type == "" {
+		c.Type = defaultHeatingModelType
+	}
+	if c.Active == "" {
+		c.Active = HeatingModelFitted
+	}
+}
+
+var configFile = defaultConfigFile
+
+// File returns pathname of the config file in use.
+func File() string {
+	return configFile
+}
+
+// WriteHeatingModel stores heating model to the config file, the rest of the file is kept.
+func WriteHeatingModel(fileName string, m *HeatingModelConfig) error {
+	var doc yaml.Node
+	data, err := os.ReadFile(fileName)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("config file `%s` is not a mapping", fileName)
+	}
+
+	var value yaml.Node
+	if err := value.Encode(m); err != nil {
+		return err
+	}
+	replaced := false
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == heatingModelKey {
+			root.Content[i+1] = &value
+			replaced = true
+		}
+	}
+	if !replaced {
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: heatingModelKey}, &value)
+	}
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, out.Bytes(), 0o644)
+}
@@ -0,0 +1,32 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+const defaultHTTPListen = ":8080"
+
+// HTTPConfig enables read-only HTTP API of the controller on `listen` address.
+type HTTPConfig struct {
+	Listen string `yaml:"listen"`
+}
+
+func (c *HTTPConfig) FillDefaults() {
+	if c.Listen == "" {
+		c.Listen = defaultHTTPListen
+	}
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import "time"
+
+// Operating modes of the controller
+const (
+	ModeComfort = "comfort"
+	ModeEco     = "eco"
+	ModeAway    = "away"
+	ModeHoliday = "holiday"
+	ModeFrost   = "frost"
+)
+
+const defaultHolidayPreheat = 3 * time.Hour
+
+// ModeConfig defines how operating mode changes zone setpoints: either with
+// offset to the zone setpoint, or with absolute setpoint, which wins if both are set.
+type ModeConfig struct {
+	Offset   *float64 `yaml:"offset,omitempty"`
+	Setpoint *float64 `yaml:"setpoint,omitempty"`
+	// Preheat is used by holiday mode: comfort mode is restored this long before the return
+	Preheat time.Duration `yaml:"preheat,omitempty"`
+}
+
+func defaultModes() map[string]*ModeConfig {
+	return map[string]*ModeConfig{
+		ModeComfort: {},
+		ModeEco:     {Offset: GetPTR(-1.5)},
+		ModeAway:    {Offset: GetPTR(-3.0)},
+		ModeHoliday: {Setpoint: GetPTR(15.0), Preheat: defaultHolidayPreheat},
+		ModeFrost:   {Setpoint: GetPTR(7.0)},
+	}
+}
+
+func fillModesDefaults(modes map[string]*ModeConfig) map[string]*ModeConfig {
+	if modes == nil {
+		modes = make(map[string]*ModeConfig)
+	}
+	for name, m := range defaultModes() {
+		if _, ok := modes[name]; !ok {
+			modes[name] = m
+		}
+	}
+	if h := modes[ModeHoliday]; h.Preheat == 0 {
+		h.Preheat = defaultHolidayPreheat
+	}
+	return modes
+}
+
+// Apply returns setpoint, adjusted according to the mode.
+func (m *ModeConfig) Apply(sp float64) float64 {
+	switch {
+	case m.Setpoint != nil:
+		return *m.Setpoint
+	case m.Offset != nil:
+		return sp + *m.Offset
+	default:
+		return sp
+	}
+}
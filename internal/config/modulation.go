@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import "sort"
+
+const (
+	defaultMinModulation = 0.0
+	defaultMaxModulation = 100.0
+)
+
+// MaxModulationConfig is a policy of the max relative modulation level.
+// Every configured limit caps the level, the lowest one wins.
+type MaxModulationConfig struct {
+	Min *float64 `yaml:"min"`
+	Max *float64 `yaml:"max"`
+	// ZoneLevels caps level by number of zones with demand: first value is used for
+	// a single zone, second for two zones, etc. Last value is used for more zones.
+	ZoneLevels []float64 `yaml:"zone_levels,omitempty"`
+	// Outside caps level by outside temperature, linear interpolation between points
+	Outside []*ModulationPoint `yaml:"outside,omitempty"`
+	// Gap caps level when boiler water temperature is close to Tset
+	Gap *ModulationGap `yaml:"gap,omitempty"`
+	// QuietHours caps level during given time windows, e.g. at night
+	QuietHours []*ModulationWindow `yaml:"quiet_hours,omitempty"`
+}
+
+type ModulationPoint struct {
+	Temperature float64 `yaml:"temperature"`
+	Level       float64 `yaml:"level"`
+}
+
+type ModulationGap struct {
+	// Below is a difference between Tset and boiler water temperature
+	Below float64 `yaml:"below"`
+	Level float64 `yaml:"level"`
+}
+
+type ModulationWindow struct {
+	TimeWindow `yaml:",inline"`
+	Level      float64 `yaml:"level"`
+}
+
+func (c *MaxModulationConfig) FillDefaults() {
+	if c.Min == nil {
+		c.Min = GetPTR(defaultMinModulation)
+	}
+	if c.Max == nil {
+		c.Max = GetPTR(defaultMaxModulation)
+	}
+	sort.Slice(c.Outside, func(i, j int) bool { return c.Outside[i].Temperature < c.Outside[j].Temperature })
+}
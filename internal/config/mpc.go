@@ -0,0 +1,73 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import "time"
+
+const (
+	defaultMPCHorizon       = 6 * time.Hour
+	defaultMPCStep          = time.Hour
+	defaultMPCEnergyWeight  = 0.01
+	defaultMPCHistory       = 14 * 24 * time.Hour
+	defaultMPCMinSamples    = 200
+	defaultMPCLearnInterval = time.Hour
+)
+
+// MPCConfig enables model predictive control of boiler Tset. Thermal model of every zone
+// is identified from the recorded history, until all zones have trained model,
+// heating curve is used.
+type MPCConfig struct {
+	// Horizon is how far ahead zone temperatures are predicted
+	Horizon time.Duration `yaml:"horizon"`
+	// Step is a period, during which planned Tset stays constant
+	Step time.Duration `yaml:"step"`
+	// EnergyWeight is a penalty of heating (°C of flow above room temperature, per hour)
+	// relative to comfort error (°C², per hour)
+	EnergyWeight *float64 `yaml:"energy_weight"`
+	// History is how far back recorded history is used for identification
+	History time.Duration `yaml:"history"`
+	// MinSamples is a number of history samples needed, before zone model is used
+	MinSamples int `yaml:"min_samples"`
+	// LearnInterval is how often zone models are re-identified
+	LearnInterval time.Duration `yaml:"learn_interval"`
+	// ForecastTopic provides outside temperature forecast, as JSON list of
+	// `{"time": ..., "temperature": ...}`, current outside temperature is used without it
+	ForecastTopic string `yaml:"forecast_topic,omitempty"`
+}
+
+func (c *MPCConfig) FillDefaults() {
+	if c.Horizon == 0 {
+		c.Horizon = defaultMPCHorizon
+	}
+	if c.Step == 0 {
+		c.Step = defaultMPCStep
+	}
+	if c.EnergyWeight == nil {
+		c.EnergyWeight = GetPTR(defaultMPCEnergyWeight)
+	}
+	if c.History == 0 {
+		c.History = defaultMPCHistory
+	}
+	if c.MinSamples == 0 {
+		c.MinSamples = defaultMPCMinSamples
+	}
+	if c.LearnInterval == 0 {
+		c.LearnInterval = defaultMPCLearnInterval
+	}
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import "time"
+
+const (
+	defaultHistoryInterval      = 5 * time.Minute
+	defaultOptimumMaxPreheat    = 3 * time.Hour
+	defaultOptimumHistory       = 14 * 24 * time.Hour
+	defaultOptimumMinSamples    = 30
+	defaultOptimumLearnInterval = time.Hour
+)
+
+// OptimumStartConfig enables early start of heating, so zones reach the next
+// scheduled setpoint on time. Heat-up rate of each zone is learned from the
+// recorded zone history.
+type OptimumStartConfig struct {
+	// MaxPreheat limits how early heating may start
+	MaxPreheat time.Duration `yaml:"max_preheat"`
+	// History is how far back recorded history is used for learning
+	History time.Duration `yaml:"history"`
+	// MinSamples is a number of heat-up samples needed, before the learned rate is used
+	MinSamples int `yaml:"min_samples"`
+	// LearnInterval is how often heat-up rates are re-learned
+	LearnInterval time.Duration `yaml:"learn_interval"`
+}
+
+func (c *OptimumStartConfig) FillDefaults() {
+	if c.MaxPreheat == 0 {
+		c.MaxPreheat = defaultOptimumMaxPreheat
+	}
+	if c.History == 0 {
+		c.History = defaultOptimumHistory
+	}
+	if c.MinSamples == 0 {
+		c.MinSamples = defaultOptimumMinSamples
+	}
+	if c.LearnInterval == 0 {
+		c.LearnInterval = defaultOptimumLearnInterval
+	}
+}
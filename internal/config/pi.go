@@ -0,0 +1,55 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+const (
+	defaultPIKp        = 0.0
+	defaultPIKi        = 1.0
+	defaultPIMaxOutput = 5.0
+	defaultPIBand      = 1.0
+)
+
+// PIConfig configures PI trim of the zone heating parameter, it removes
+// persistent offset, which proportional room compensation leaves.
+// Output is added to the heating parameter and is bounded by ±MaxOutput.
+type PIConfig struct {
+	// Kp is a proportional gain, heating parameter units per °C, on top of room compensation
+	Kp *float64 `yaml:"kp"`
+	// Ki is an integral gain, heating parameter units per °C·h
+	Ki *float64 `yaml:"ki"`
+	// MaxOutput bounds output of the controller
+	MaxOutput *float64 `yaml:"max_output"`
+	// Band is an error, beyond which integral is frozen, e.g. after setpoint change
+	Band *float64 `yaml:"band"`
+}
+
+func (c *PIConfig) FillDefaults() {
+	if c.Kp == nil {
+		c.Kp = GetPTR(defaultPIKp)
+	}
+	if c.Ki == nil {
+		c.Ki = GetPTR(defaultPIKi)
+	}
+	if c.MaxOutput == nil {
+		c.MaxOutput = GetPTR(defaultPIMaxOutput)
+	}
+	if c.Band == nil {
+		c.Band = GetPTR(defaultPIBand)
+	}
+}
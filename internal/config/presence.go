@@ -0,0 +1,50 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import "time"
+
+const (
+	defaultPresenceSetback = 2.0
+	defaultPresenceGrace   = 30 * time.Minute
+)
+
+// PresenceConfig configures occupancy inputs: when nobody is present for longer
+// than Grace, setpoint is lowered by Setback. Comfort returns immediately.
+type PresenceConfig struct {
+	Sensors []*PresenceSensorConfig `yaml:"sensors"`
+	Setback *float64                `yaml:"setback"`
+	Grace   time.Duration           `yaml:"grace"`
+}
+
+// PresenceSensorConfig is an occupancy input: binary (`ON`/`OFF`, `true`/`false`, `1`/`0`),
+// JSON with `json_entry`, or Home Assistant `home`/`not_home` state.
+type PresenceSensorConfig struct {
+	Topic     string  `yaml:"topic"`
+	JSONEntry *string `yaml:"json_entry,omitempty"`
+}
+
+func (c *PresenceConfig) FillDefaults() {
+	if c.Setback == nil {
+		c.Setback = GetPTR(defaultPresenceSetback)
+	}
+	if c.Grace == 0 {
+		c.Grace = defaultPresenceGrace
+	}
+}
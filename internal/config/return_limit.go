@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import "time"
+
+const (
+	defaultReturnLimit        = 55.0
+	defaultReturnStep         = 1.0
+	defaultReturnInterval     = 5 * time.Minute
+	defaultReturnMaxReduction = 15.0
+	defaultReturnTolerance    = 0.5
+	defaultReturnHysteresis   = 2.0
+)
+
+// ReturnLimitConfig keeps return temperature of a condensing boiler below the limit:
+// Tset is reduced by `step` every `interval`, while return temperature is above `limit`
+// and comfort error of the worst zone stays within `tolerance`. Reduction is recovered
+// in the same steps, once return is `hysteresis` below the limit or comfort suffers.
+type ReturnLimitConfig struct {
+	Limit        *float64      `yaml:"limit"`
+	Step         *float64      `yaml:"step"`
+	Interval     time.Duration `yaml:"interval"`
+	MaxReduction *float64      `yaml:"max_reduction"`
+	Tolerance    *float64      `yaml:"tolerance"`
+	Hysteresis   *float64      `yaml:"hysteresis"`
+}
+
+func (c *ReturnLimitConfig) FillDefaults() {
+	if c.Limit == nil {
+		c.Limit = GetPTR(defaultReturnLimit)
+	}
+	if c.Step == nil {
+		c.Step = GetPTR(defaultReturnStep)
+	}
+	if c.Interval == 0 {
+		c.Interval = defaultReturnInterval
+	}
+	if c.MaxReduction == nil {
+		c.MaxReduction = GetPTR(defaultReturnMaxReduction)
+	}
+	if c.Tolerance == nil {
+		c.Tolerance = GetPTR(defaultReturnTolerance)
+	}
+	if c.Hysteresis == nil {
+		c.Hysteresis = GetPTR(defaultReturnHysteresis)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import "time"
+
+const (
+	defaultSimDuration     = 72 * time.Hour
+	defaultSimSpeed        = 600.0
+	defaultSimStep         = time.Minute
+	defaultSimOutput       = "simulation.csv"
+	defaultSimFlowLag      = 5 * time.Minute
+	defaultSimOutsideMean  = 3.0
+	defaultSimOutsideAmpl  = 4.0
+	defaultSimColdest      = ClockTime(5 * 60)
+	defaultSimHeatLoss     = 100.0
+	defaultSimThermalMass  = 5000.0
+	defaultSimRadiator     = 2500.0
+	defaultSimInitial      = 18.0
+	defaultSimZoneSetpoint = 20.0
+)
+
+// SimulationConfig configures `simulate` mode: controller runs in accelerated
+// time against a simulated building instead of MQTT broker and boiler.
+type SimulationConfig struct {
+	// Start is a simulated start moment, e.g. `2024-01-08T00:00`, by default today midnight
+	Start    string        `yaml:"start,omitempty"`
+	Duration time.Duration `yaml:"duration"`
+	// Speed is how many times simulated time runs faster than the wall clock
+	Speed float64 `yaml:"speed"`
+	// Step is a simulated interval of the building model and sensor reports
+	Step time.Duration `yaml:"step"`
+	// Output is a CSV file with traces, comfort metrics are printed as CSV to stdout
+	Output string `yaml:"output"`
+	// FlowLag is a time constant of the flow temperature following boiler Tset
+	FlowLag time.Duration             `yaml:"flow_lag"`
+	Outside *SimOutsideConfig         `yaml:"outside"`
+	Zones   map[string]*SimZoneConfig `yaml:"zones"`
+}
+
+// SimOutsideConfig is a daily outside temperature profile: sinusoid with given mean
+// and amplitude, coldest at `coldest`, or, if set, linear interpolation of `points`.
+type SimOutsideConfig struct {
+	Mean      *float64           `yaml:"mean"`
+	Amplitude *float64           `yaml:"amplitude"`
+	Coldest   *ClockTime         `yaml:"coldest"`
+	Points    []*SimOutsidePoint `yaml:"points,omitempty"`
+}
+
+type SimOutsidePoint struct {
+	Time        ClockTime `yaml:"time"`
+	Temperature float64   `yaml:"temperature"`
+}
+
+// SimZoneConfig is RC thermal model of a zone.
+type SimZoneConfig struct {
+	// HeatLoss is a heat loss coefficient, W/K
+	HeatLoss *float64 `yaml:"heat_loss"`
+	// ThermalMass is a heat capacity of the zone, kJ/K
+	ThermalMass *float64 `yaml:"thermal_mass"`
+	// Radiator is a radiator output at 50K over room temperature, W
+	Radiator *float64 `yaml:"radiator"`
+	// Initial is a zone temperature at start
+	Initial *float64 `yaml:"initial"`
+	// Setpoint is published to zone setpoint topic at start
+	Setpoint *float64 `yaml:"setpoint"`
+}
+
+// FillDefaults fills defaults, zones without model get the default one.
+func (c *SimulationConfig) FillDefaults(zones map[string]*ZoneConfig) {
+	if c.Duration == 0 {
+		c.Duration = defaultSimDuration
+	}
+	if c.Speed == 0 {
+		c.Speed = defaultSimSpeed
+	}
+	if c.Step == 0 {
+		c.Step = defaultSimStep
+	}
+	if c.Output == "" {
+		c.Output = defaultSimOutput
+	}
+	if c.FlowLag == 0 {
+		c.FlowLag = defaultSimFlowLag
+	}
+	if c.Outside == nil {
+		c.Outside = &SimOutsideConfig{}
+	}
+	if c.Outside.Mean == nil {
+		c.Outside.Mean = GetPTR(defaultSimOutsideMean)
+	}
+	if c.Outside.Amplitude == nil {
+		c.Outside.Amplitude = GetPTR(defaultSimOutsideAmpl)
+	}
+	if c.Outside.Coldest == nil {
+		c.Outside.Coldest = GetPTR(defaultSimColdest)
+	}
+	if c.Zones == nil {
+		c.Zones = make(map[string]*SimZoneConfig)
+	}
+	for name := range zones {
+		z, ok := c.Zones[name]
+		if !ok {
+			z = &SimZoneConfig{}
+			c.Zones[name] = z
+		}
+		z.FillDefaults()
+	}
+}
+
+func (z *SimZoneConfig) FillDefaults() {
+	if z.HeatLoss == nil {
+		z.HeatLoss = GetPTR(defaultSimHeatLoss)
+	}
+	if z.ThermalMass == nil {
+		z.ThermalMass = GetPTR(defaultSimThermalMass)
+	}
+	if z.Radiator == nil {
+		z.Radiator = GetPTR(defaultSimRadiator)
+	}
+	if z.Initial == nil {
+		z.Initial = GetPTR(defaultSimInitial)
+	}
+	if z.Setpoint == nil {
+		z.Setpoint = GetPTR(defaultSimZoneSetpoint)
+	}
+}
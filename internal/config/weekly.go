@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var weekdayNames = map[string][]time.Weekday{
+	"mon":      {time.Monday},
+	"tue":      {time.Tuesday},
+	"wed":      {time.Wednesday},
+	"thu":      {time.Thursday},
+	"fri":      {time.Friday},
+	"sat":      {time.Saturday},
+	"sun":      {time.Sunday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekend":  {time.Saturday, time.Sunday},
+}
+
+// Weekdays is a set of days of the week, configured as list of names:
+// `mon`...`sun` (or full names), `weekdays` and `weekend`.
+// Empty set means every day.
+type Weekdays []time.Weekday
+
+func (w *Weekdays) UnmarshalYAML(value *yaml.Node) error {
+	var names []string
+	if value.Kind == yaml.ScalarNode {
+		names = []string{value.Value}
+	} else if err := value.Decode(&names); err != nil {
+		return err
+	}
+
+	*w = (*w)[:0]
+	for _, n := range names {
+		n = strings.ToLower(strings.TrimSpace(n))
+		if len(n) > 3 && n != "weekdays" && n != "weekend" {
+			n = n[:3]
+		}
+		days, ok := weekdayNames[n]
+		if !ok {
+			return fmt.Errorf("line %d: unknown day of week `%s`", value.Line, n)
+		}
+		*w = append(*w, days...)
+	}
+	return nil
+}
+
+func (w Weekdays) MarshalYAML() (interface{}, error) {
+	names := make([]string, len(w))
+	for i, d := range w {
+		names[i] = strings.ToLower(d.String()[:3])
+	}
+	return names, nil
+}
+
+// Contains reports whether day is in the set.
+func (w Weekdays) Contains(day time.Weekday) bool {
+	if len(w) == 0 {
+		return true
+	}
+	for _, d := range w {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// ClockTime is a time of the day, configured as `HH:MM`, stored as minutes since midnight.
+type ClockTime int
+
+func ParseClockTime(s string) (ClockTime, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("time of the day must be HH:MM: %w", err)
+	}
+	return ClockTime(t.Hour()*60 + t.Minute()), nil
+}
+
+func (c *ClockTime) UnmarshalYAML(value *yaml.Node) error {
+	t, err := ParseClockTime(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", value.Line, err)
+	}
+	*c = t
+	return nil
+}
+
+func (c ClockTime) MarshalYAML() (interface{}, error) {
+	return c.String(), nil
+}
+
+func (c ClockTime) String() string {
+	return fmt.Sprintf("%02d:%02d", int(c)/60, int(c)%60)
+}
+
+// ClockOf returns time of the day of `t`.
+func ClockOf(t time.Time) ClockTime {
+	return ClockTime(t.Hour()*60 + t.Minute())
+}
+
+// On returns moment of the clock time on the day of `t`.
+func (c ClockTime) On(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, int(c)/60, int(c)%60, 0, 0, t.Location())
+}
+
+// TimeWindow is a daily time window, on given days.
+// Window with `to` before `from` ends on the next day.
+type TimeWindow struct {
+	Days Weekdays  `yaml:"days,omitempty"`
+	From ClockTime `yaml:"from"`
+	To   ClockTime `yaml:"to"`
+}
+
+// Contains reports whether `t` falls into the window.
+func (w *TimeWindow) Contains(t time.Time) bool {
+	c := ClockOf(t)
+	if w.From <= w.To {
+		return w.Days.Contains(t.Weekday()) && c >= w.From && c < w.To
+	}
+	// window over midnight, started today or yesterday
+	if c >= w.From {
+		return w.Days.Contains(t.Weekday())
+	}
+	return c < w.To && w.Days.Contains(t.AddDate(0, 0, -1).Weekday())
+}
+
+// ScheduleEntry is a point of weekly schedule: from `time` on given days setpoint is `setpoint`,
+// until the next entry.
+type ScheduleEntry struct {
+	Days     Weekdays  `yaml:"days,omitempty"`
+	Time     ClockTime `yaml:"time"`
+	Setpoint float64   `yaml:"setpoint"`
+}
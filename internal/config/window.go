@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import "time"
+
+const (
+	defaultWindowDrop       = 0.6
+	defaultWindowPeriod     = 5 * time.Minute
+	defaultWindowSuspendFor = 30 * time.Minute
+)
+
+// WindowConfig configures open window detection of the zone: by fast drop of the
+// zone temperature and/or by contact sensors. Zone demand is suspended while window is open.
+type WindowConfig struct {
+	// DropDetection enables detection by temperature drop, enabled by default
+	DropDetection *bool `yaml:"drop_detection"`
+	// Drop of the zone temperature within Period, which means open window
+	Drop   *float64      `yaml:"drop"`
+	Period time.Duration `yaml:"period"`
+	// SuspendFor is how long zone demand is suspended after the drop is detected
+	SuspendFor time.Duration    `yaml:"suspend_for"`
+	Contacts   []*ContactConfig `yaml:"contacts,omitempty"`
+}
+
+// ContactConfig is a window/door contact sensor, payload is on/off style value,
+// e.g. `ON`/`OFF`, `open`/`closed`, `true`/`false`.
+type ContactConfig struct {
+	Topic     string  `yaml:"topic"`
+	JSONEntry *string `yaml:"json_entry,omitempty"`
+	// Invert is for sensors, which report `contact: true` when window is closed (e.g. zigbee2mqtt)
+	Invert bool `yaml:"invert,omitempty"`
+}
+
+func (c *WindowConfig) FillDefaults() {
+	if c.DropDetection == nil {
+		c.DropDetection = GetPTR(true)
+	}
+	if c.Drop == nil {
+		c.Drop = GetPTR(defaultWindowDrop)
+	}
+	if c.Period == 0 {
+		c.Period = defaultWindowPeriod
+	}
+	if c.SuspendFor == 0 {
+		c.SuspendFor = defaultWindowSuspendFor
+	}
+}
@@ -27,6 +27,21 @@ type ZoneConfig struct {
 	Sensors            []*SensorConfig     `yaml:"sensors"`
 	HeatDemand         []*HeatDemandConfig `yaml:"heat_demand,omitempty"`
 	Valves             []*ValveConfig      `yaml:"valves"`
+	// Schedule is a name of the weekly profile, which defines zone setpoint.
+	// Setpoints from `setpoint.topic` act then as temporary overrides.
+	Schedule string `yaml:"schedule,omitempty"`
+	// Modes override global operating modes for this zone
+	Modes map[string]*ModeConfig `yaml:"modes,omitempty"`
+	// Window enables open window detection
+	Window *WindowConfig `yaml:"window,omitempty"`
+	// Presence enables setback of the setpoint, when zone is not occupied
+	Presence *PresenceConfig `yaml:"presence,omitempty"`
+	// PI enables PI trim of the heating parameter
+	PI *PIConfig `yaml:"pi,omitempty"`
+	// Autotune enables or disables tuning of the heating parameter for this zone
+	Autotune *bool `yaml:"autotune,omitempty"`
+	// Emitter is a type of heat emitter: radiator, underfloor or convector
+	Emitter *EmitterConfig `yaml:"emitter,omitempty"`
 }
 
 func (z *ZoneConfig) FillDefaults() {
@@ -40,12 +55,39 @@ func (z *ZoneConfig) FillDefaults() {
 		z.RoomCompensation = GetPTR(zoneDefaultCompensation)
 	}
 
+	if z.Setpoint == nil {
+		z.Setpoint = NewSetpointConfig()
+	}
 	z.Setpoint.FillDefaults()
+	if z.Window != nil {
+		z.Window.FillDefaults()
+	}
+	if z.Presence != nil {
+		z.Presence.FillDefaults()
+	}
+	if z.PI != nil {
+		z.PI.FillDefaults()
+	}
+	if z.Emitter != nil {
+		z.Emitter.FillDefaults()
+	}
 	for _, s := range z.Sensors {
 		s.FillDefaults()
 	}
 }
 
+// EffectiveHeatingParameter returns heating parameter of the zone: its own,
+// default of the emitter or the global default `def`.
+func (z *ZoneConfig) EffectiveHeatingParameter(def float64) float64 {
+	if z.HeatingParameter != nil {
+		return *z.HeatingParameter
+	}
+	if z.Emitter != nil && z.Emitter.HeatingParameter != nil {
+		return *z.Emitter.HeatingParameter
+	}
+	return def
+}
+
 func NewZoneConfig() *ZoneConfig {
 	return &ZoneConfig{
 		Sensors:  make([]*SensorConfig, 0),
@@ -6,22 +6,81 @@ package db
 
 import (
 	"database/sql"
+	"time"
 )
 
+type Alarm struct {
+	ID        int64
+	Name      string
+	Severity  string
+	Message   string
+	RaisedAt  time.Time
+	ClearedAt sql.NullTime
+}
+
+type BoilerHistory struct {
+	Tset            float64
+	ChEnable        bool
+	FlowTemperature sql.NullFloat64
+	RecordedAt      time.Time
+}
+
+type ControlAudit struct {
+	ID        int64
+	Topic     string
+	OldValue  string
+	NewValue  string
+	Accepted  bool
+	ChangedAt time.Time
+}
+
 type Controller struct {
 	Name      string
 	Value     string
 	UpdatedAt sql.NullTime
 }
 
+type HeatingParameterChange struct {
+	ZoneName  string
+	OldValue  float64
+	NewValue  float64
+	MeanError float64
+	Samples   int64
+	ChangedAt time.Time
+}
+
 type Sensor struct {
 	SensorName string
 	Value      float64
 	UpdatedAt  sql.NullTime
 }
 
+type SensorHistory struct {
+	SensorName string
+	Value      float64
+	RecordedAt time.Time
+}
+
 type Zone struct {
 	ZoneName  string
 	Setpoint  float64
 	UpdatedAt sql.NullTime
 }
+
+type ZoneHistory struct {
+	ZoneName           string
+	Temperature        float64
+	Setpoint           float64
+	EffectiveSetpoint  float64
+	Tset               float64
+	OutsideTemperature float64
+	FlowTemperature    sql.NullFloat64
+	RecordedAt         time.Time
+}
+
+type ZoneValue struct {
+	ZoneName  string
+	Name      string
+	Value     float64
+	UpdatedAt sql.NullTime
+}
@@ -51,6 +51,20 @@ func OpenDatabase(dbFile string) *Queries {
 	return queries
 }
 
+// OpenReadOnly opens existing DB for reading, e.g. recorded history, schema is not touched.
+func OpenReadOnly(dbFile string) *Queries {
+	sqlDB, err := sql.Open("sqlite3", "file:"+dbFile+"?mode=ro")
+	if err != nil {
+		logger.L().Panic(err)
+	}
+
+	if err := sqlDB.Ping(); err != nil {
+		logger.L().Panicf("%s, %w", dbFile, err)
+	}
+
+	return New(sqlDB)
+}
+
 // Example functions using the generated code:
 
 // Add similar functions for sensors and controllers
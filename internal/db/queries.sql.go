@@ -7,8 +7,26 @@ package db
 
 import (
 	"context"
+	"database/sql"
+	"time"
 )
 
+const clearAlarm = `-- name: ClearAlarm :exec
+UPDATE alarm
+SET cleared_at = ?
+WHERE id = ?
+`
+
+type ClearAlarmParams struct {
+	ClearedAt sql.NullTime
+	ID        int64
+}
+
+func (q *Queries) ClearAlarm(ctx context.Context, arg ClearAlarmParams) error {
+	_, err := q.db.ExecContext(ctx, clearAlarm, arg.ClearedAt, arg.ID)
+	return err
+}
+
 const getControllerValue = `-- name: GetControllerValue :one
 SELECT value from controller where name=?
 `
@@ -46,6 +64,488 @@ func (q *Queries) GetZoneSetpoint(ctx context.Context, zoneName string) (float64
 	return setpoint, err
 }
 
+const getZoneValue = `-- name: GetZoneValue :one
+SELECT value
+FROM zone_value
+WHERE zone_name = ? AND name = ?
+`
+
+type GetZoneValueParams struct {
+	ZoneName string
+	Name     string
+}
+
+func (q *Queries) GetZoneValue(ctx context.Context, arg GetZoneValueParams) (float64, error) {
+	row := q.db.QueryRowContext(ctx, getZoneValue, arg.ZoneName, arg.Name)
+	var value float64
+	err := row.Scan(&value)
+	return value, err
+}
+
+const insertAlarm = `-- name: InsertAlarm :execlastid
+INSERT INTO alarm (name, severity, message, raised_at)
+VALUES (?, ?, ?, ?)
+`
+
+type InsertAlarmParams struct {
+	Name     string
+	Severity string
+	Message  string
+	RaisedAt time.Time
+}
+
+func (q *Queries) InsertAlarm(ctx context.Context, arg InsertAlarmParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, insertAlarm,
+		arg.Name,
+		arg.Severity,
+		arg.Message,
+		arg.RaisedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+const insertBoilerHistory = `-- name: InsertBoilerHistory :exec
+INSERT INTO boiler_history (tset, ch_enable, flow_temperature, recorded_at)
+VALUES (?, ?, ?, ?)
+`
+
+type InsertBoilerHistoryParams struct {
+	Tset            float64
+	ChEnable        bool
+	FlowTemperature sql.NullFloat64
+	RecordedAt      time.Time
+}
+
+func (q *Queries) InsertBoilerHistory(ctx context.Context, arg InsertBoilerHistoryParams) error {
+	_, err := q.db.ExecContext(ctx, insertBoilerHistory,
+		arg.Tset,
+		arg.ChEnable,
+		arg.FlowTemperature,
+		arg.RecordedAt,
+	)
+	return err
+}
+
+const insertControlAudit = `-- name: InsertControlAudit :exec
+INSERT INTO control_audit (topic, old_value, new_value, accepted, changed_at)
+VALUES (?, ?, ?, ?, ?)
+`
+
+type InsertControlAuditParams struct {
+	Topic     string
+	OldValue  string
+	NewValue  string
+	Accepted  bool
+	ChangedAt time.Time
+}
+
+func (q *Queries) InsertControlAudit(ctx context.Context, arg InsertControlAuditParams) error {
+	_, err := q.db.ExecContext(ctx, insertControlAudit,
+		arg.Topic,
+		arg.OldValue,
+		arg.NewValue,
+		arg.Accepted,
+		arg.ChangedAt,
+	)
+	return err
+}
+
+const insertHeatingParameterChange = `-- name: InsertHeatingParameterChange :exec
+INSERT INTO heating_parameter_change (zone_name, old_value, new_value, mean_error, samples, changed_at)
+VALUES (?, ?, ?, ?, ?, ?)
+`
+
+type InsertHeatingParameterChangeParams struct {
+	ZoneName  string
+	OldValue  float64
+	NewValue  float64
+	MeanError float64
+	Samples   int64
+	ChangedAt time.Time
+}
+
+func (q *Queries) InsertHeatingParameterChange(ctx context.Context, arg InsertHeatingParameterChangeParams) error {
+	_, err := q.db.ExecContext(ctx, insertHeatingParameterChange,
+		arg.ZoneName,
+		arg.OldValue,
+		arg.NewValue,
+		arg.MeanError,
+		arg.Samples,
+		arg.ChangedAt,
+	)
+	return err
+}
+
+const insertSensorHistory = `-- name: InsertSensorHistory :exec
+INSERT INTO sensor_history (sensor_name, value, recorded_at)
+VALUES (?, ?, ?)
+`
+
+type InsertSensorHistoryParams struct {
+	SensorName string
+	Value      float64
+	RecordedAt time.Time
+}
+
+func (q *Queries) InsertSensorHistory(ctx context.Context, arg InsertSensorHistoryParams) error {
+	_, err := q.db.ExecContext(ctx, insertSensorHistory, arg.SensorName, arg.Value, arg.RecordedAt)
+	return err
+}
+
+const insertZoneHistory = `-- name: InsertZoneHistory :exec
+INSERT INTO zone_history (zone_name, temperature, setpoint, effective_setpoint, tset,
+                          outside_temperature, flow_temperature, recorded_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+type InsertZoneHistoryParams struct {
+	ZoneName           string
+	Temperature        float64
+	Setpoint           float64
+	EffectiveSetpoint  float64
+	Tset               float64
+	OutsideTemperature float64
+	FlowTemperature    sql.NullFloat64
+	RecordedAt         time.Time
+}
+
+func (q *Queries) InsertZoneHistory(ctx context.Context, arg InsertZoneHistoryParams) error {
+	_, err := q.db.ExecContext(ctx, insertZoneHistory,
+		arg.ZoneName,
+		arg.Temperature,
+		arg.Setpoint,
+		arg.EffectiveSetpoint,
+		arg.Tset,
+		arg.OutsideTemperature,
+		arg.FlowTemperature,
+		arg.RecordedAt,
+	)
+	return err
+}
+
+const listActiveAlarms = `-- name: ListActiveAlarms :many
+SELECT id, name, severity, message, raised_at, cleared_at
+FROM alarm
+WHERE cleared_at IS NULL
+ORDER BY raised_at
+`
+
+func (q *Queries) ListActiveAlarms(ctx context.Context) ([]Alarm, error) {
+	rows, err := q.db.QueryContext(ctx, listActiveAlarms)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Alarm
+	for rows.Next() {
+		var i Alarm
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Severity,
+			&i.Message,
+			&i.RaisedAt,
+			&i.ClearedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAlarms = `-- name: ListAlarms :many
+SELECT id, name, severity, message, raised_at, cleared_at
+FROM alarm
+WHERE raised_at >= ? AND raised_at < ?
+ORDER BY raised_at
+`
+
+type ListAlarmsParams struct {
+	Since time.Time
+	Until time.Time
+}
+
+func (q *Queries) ListAlarms(ctx context.Context, arg ListAlarmsParams) ([]Alarm, error) {
+	rows, err := q.db.QueryContext(ctx, listAlarms, arg.Since, arg.Until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Alarm
+	for rows.Next() {
+		var i Alarm
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Severity,
+			&i.Message,
+			&i.RaisedAt,
+			&i.ClearedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listBoilerHistory = `-- name: ListBoilerHistory :many
+SELECT tset, ch_enable, flow_temperature, recorded_at
+FROM boiler_history
+WHERE recorded_at >= ? AND recorded_at < ?
+ORDER BY recorded_at
+`
+
+type ListBoilerHistoryParams struct {
+	Since time.Time
+	Until time.Time
+}
+
+func (q *Queries) ListBoilerHistory(ctx context.Context, arg ListBoilerHistoryParams) ([]BoilerHistory, error) {
+	rows, err := q.db.QueryContext(ctx, listBoilerHistory, arg.Since, arg.Until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []BoilerHistory
+	for rows.Next() {
+		var i BoilerHistory
+		if err := rows.Scan(
+			&i.Tset,
+			&i.ChEnable,
+			&i.FlowTemperature,
+			&i.RecordedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listControlAudit = `-- name: ListControlAudit :many
+SELECT id, topic, old_value, new_value, accepted, changed_at
+FROM control_audit
+WHERE changed_at >= ? AND changed_at < ?
+ORDER BY changed_at, id
+`
+
+type ListControlAuditParams struct {
+	Since time.Time
+	Until time.Time
+}
+
+func (q *Queries) ListControlAudit(ctx context.Context, arg ListControlAuditParams) ([]ControlAudit, error) {
+	rows, err := q.db.QueryContext(ctx, listControlAudit, arg.Since, arg.Until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ControlAudit
+	for rows.Next() {
+		var i ControlAudit
+		if err := rows.Scan(
+			&i.ID,
+			&i.Topic,
+			&i.OldValue,
+			&i.NewValue,
+			&i.Accepted,
+			&i.ChangedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listHeatingParameterChanges = `-- name: ListHeatingParameterChanges :many
+SELECT zone_name, old_value, new_value, mean_error, samples, changed_at
+FROM heating_parameter_change
+ORDER BY zone_name, changed_at
+`
+
+func (q *Queries) ListHeatingParameterChanges(ctx context.Context) ([]HeatingParameterChange, error) {
+	rows, err := q.db.QueryContext(ctx, listHeatingParameterChanges)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []HeatingParameterChange
+	for rows.Next() {
+		var i HeatingParameterChange
+		if err := rows.Scan(
+			&i.ZoneName,
+			&i.OldValue,
+			&i.NewValue,
+			&i.MeanError,
+			&i.Samples,
+			&i.ChangedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listHistory = `-- name: ListHistory :many
+SELECT zone_name, temperature, setpoint, effective_setpoint, tset, outside_temperature, flow_temperature, recorded_at
+FROM zone_history
+WHERE recorded_at >= ? AND recorded_at < ?
+ORDER BY recorded_at, zone_name
+`
+
+type ListHistoryParams struct {
+	Since time.Time
+	Until time.Time
+}
+
+func (q *Queries) ListHistory(ctx context.Context, arg ListHistoryParams) ([]ZoneHistory, error) {
+	rows, err := q.db.QueryContext(ctx, listHistory, arg.Since, arg.Until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ZoneHistory
+	for rows.Next() {
+		var i ZoneHistory
+		if err := rows.Scan(
+			&i.ZoneName,
+			&i.Temperature,
+			&i.Setpoint,
+			&i.EffectiveSetpoint,
+			&i.Tset,
+			&i.OutsideTemperature,
+			&i.FlowTemperature,
+			&i.RecordedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSensorHistory = `-- name: ListSensorHistory :many
+SELECT sensor_name, value, recorded_at
+FROM sensor_history
+WHERE recorded_at >= ? AND recorded_at < ?
+ORDER BY recorded_at, sensor_name
+`
+
+type ListSensorHistoryParams struct {
+	Since time.Time
+	Until time.Time
+}
+
+func (q *Queries) ListSensorHistory(ctx context.Context, arg ListSensorHistoryParams) ([]SensorHistory, error) {
+	rows, err := q.db.QueryContext(ctx, listSensorHistory, arg.Since, arg.Until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SensorHistory
+	for rows.Next() {
+		var i SensorHistory
+		if err := rows.Scan(&i.SensorName, &i.Value, &i.RecordedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listZoneHistory = `-- name: ListZoneHistory :many
+SELECT zone_name, temperature, setpoint, effective_setpoint, tset, outside_temperature, flow_temperature, recorded_at
+FROM zone_history
+WHERE zone_name = ? AND recorded_at >= ?
+ORDER BY recorded_at
+`
+
+type ListZoneHistoryParams struct {
+	ZoneName   string
+	RecordedAt time.Time
+}
+
+func (q *Queries) ListZoneHistory(ctx context.Context, arg ListZoneHistoryParams) ([]ZoneHistory, error) {
+	rows, err := q.db.QueryContext(ctx, listZoneHistory, arg.ZoneName, arg.RecordedAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ZoneHistory
+	for rows.Next() {
+		var i ZoneHistory
+		if err := rows.Scan(
+			&i.ZoneName,
+			&i.Temperature,
+			&i.Setpoint,
+			&i.EffectiveSetpoint,
+			&i.Tset,
+			&i.OutsideTemperature,
+			&i.FlowTemperature,
+			&i.RecordedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const upsertControllerValue = `-- name: UpsertControllerValue :exec
 INSERT INTO controller(name, value, updated_at)
 VALUES (?, ?, CURRENT_TIMESTAMP)
@@ -97,3 +597,21 @@ func (q *Queries) UpsertZoneSetpoint(ctx context.Context, arg UpsertZoneSetpoint
 	_, err := q.db.ExecContext(ctx, upsertZoneSetpoint, arg.ZoneName, arg.Setpoint)
 	return err
 }
+
+const upsertZoneValue = `-- name: UpsertZoneValue :exec
+INSERT INTO zone_value(zone_name, name, value, updated_at)
+VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+ON CONFLICT(zone_name, name) DO UPDATE SET value=excluded.value,
+                                           updated_at=CURRENT_TIMESTAMP
+`
+
+type UpsertZoneValueParams struct {
+	ZoneName string
+	Name     string
+	Value    float64
+}
+
+func (q *Queries) UpsertZoneValue(ctx context.Context, arg UpsertZoneValueParams) error {
+	_, err := q.db.ExecContext(ctx, upsertZoneValue, arg.ZoneName, arg.Name, arg.Value)
+	return err
+}
@@ -0,0 +1,288 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antst/mzotbc/internal/config"
+	"github.com/antst/mzotbc/internal/logger"
+	"github.com/antst/mzotbc/internal/safe_mqtt"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+
+	"github.com/antst/mzotbc/internal/db"
+)
+
+const (
+	dhwTickerDuration = 10 * time.Second
+	dhwModeAuto       = "auto"
+	dhwModeOn         = "on"
+	dhwModeOff        = "off"
+	dhwMinSetpoint    = 30.0
+	dhwMaxSetpoint    = 80.0
+)
+
+// DHWController controls domestic hot water: setpoint, enable schedule and
+// weekly legionella cycle. Commands go to the boiler via BoilerController.
+type DHWController struct {
+	mu           sync.RWMutex
+	cfg          *config.DHWConfig
+	mqtt         safe_mqtt.MqttClient
+	queries      *db.Queries
+	boiler       *BoilerController
+	controlTopic string
+	force        func()
+	mode         string
+	setpoint     float64
+	enabled      bool
+	effectiveSP  float64
+	legionella   bool
+	heating      bool
+	sentAt       time.Time
+}
+
+type dhwState struct {
+	Mode       string  `json:"mode"`
+	Enabled    bool    `json:"enabled"`
+	Setpoint   float64 `json:"setpoint"`
+	Legionella bool    `json:"legionella"`
+	Heating    bool    `json:"heating"`
+}
+
+func NewDHWController(
+	_cfg *config.DHWConfig, _mqttCfg *config.MQTTConfig, _q *db.Queries, _boiler *BoilerController,
+	_force func(),
+) *DHWController {
+	d := &DHWController{
+		cfg:          _cfg,
+		queries:      _q,
+		boiler:       _boiler,
+		controlTopic: _mqttCfg.ControlTopic + "/dhw/",
+		force:        _force,
+		mode:         dhwModeAuto,
+		setpoint:     *_cfg.Setpoint,
+	}
+	d.readState()
+
+	d.mqtt = safe_mqtt.InitMQTTClient(_mqttCfg.URL, "otbs-dhw-"+uuid.New().String())
+	d.mqtt.SafeSubscribe(d.controlTopic+"mode", mqttQoS, d.controlUpdateHandler)
+	d.mqtt.SafeSubscribe(d.controlTopic+"setpoint", mqttQoS, d.controlUpdateHandler)
+	if _cfg.ActiveTopic != "" {
+		d.mqtt.SafeSubscribe(_cfg.ActiveTopic, mqttQoS, d.activeUpdateHandler)
+	}
+
+	d.evaluate(clk.Now())
+	go d.run()
+	return d
+}
+
+func (d *DHWController) run() {
+	ticker := newTicker(dhwTickerDuration)
+	defer ticker.Stop()
+	for range ticker.C {
+		if d.cfg.ActiveTopic == "" {
+			st := d.boiler.State()
+			d.setHeating(st.DHWActive())
+		}
+		d.evaluate(clk.Now())
+	}
+}
+
+// PauseCH reports whether CH demand must be paused, as DHW has priority and is being heated.
+func (d *DHWController) PauseCH() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.cfg.Priority && d.heating
+}
+
+func (d *DHWController) evaluate(now time.Time) {
+	d.mu.Lock()
+	enabled, sp := d.mode == dhwModeOn, d.setpoint
+	if d.mode == dhwModeAuto {
+		enabled = len(d.cfg.Schedule) == 0
+		for _, w := range d.cfg.Schedule {
+			if w.Contains(now) {
+				enabled = true
+				break
+			}
+		}
+	}
+
+	legionella := d.mode != dhwModeOff && d.cfg.Legionella != nil && d.cfg.Legionella.Window().Contains(now)
+	if legionella {
+		enabled = true
+		if *d.cfg.Legionella.Setpoint > sp {
+			sp = *d.cfg.Legionella.Setpoint
+		}
+	}
+
+	changed := enabled != d.enabled || sp != d.effectiveSP || legionella != d.legionella
+	if changed && legionella != d.legionella {
+		logger.L().Infof("DHW legionella cycle active: %v", legionella)
+	}
+	d.enabled, d.effectiveSP, d.legionella = enabled, sp, legionella
+	resend := changed || now.Sub(d.sentAt) >= d.boiler.cfg.KeepaliveInterval()
+	if resend {
+		d.sentAt = now
+	}
+	d.mu.Unlock()
+
+	if resend {
+		d.boiler.UpdateDHW(sp, enabled)
+	}
+	if changed {
+		d.publishState()
+	}
+}
+
+func (d *DHWController) setHeating(heating bool) {
+	d.mu.Lock()
+	changed := heating != d.heating
+	d.heating = heating
+	d.mu.Unlock()
+
+	if changed {
+		logger.L().Infof("DHW heating: %v", heating)
+		d.publishState()
+		if d.cfg.Priority {
+			d.force()
+		}
+	}
+}
+
+func (d *DHWController) publishState() {
+	d.mu.RLock()
+	st := dhwState{
+		Mode:       d.mode,
+		Enabled:    d.enabled,
+		Setpoint:   d.effectiveSP,
+		Legionella: d.legionella,
+		Heating:    d.heating,
+	}
+	d.mu.RUnlock()
+
+	payload, err := json.Marshal(st)
+	if err != nil {
+		logger.L().Error(err)
+		return
+	}
+	d.mqtt.SafePublish(d.controlTopic+"state", mqttQoS, true, payload)
+}
+
+func (d *DHWController) activeUpdateHandler(client mqtt.Client, message mqtt.Message) {
+	heating, err := extractBoolPlainOrJson(message, d.cfg.ActiveJSONEntry)
+	if err != nil {
+		logger.L().Error(err)
+		return
+	}
+	d.setHeating(heating)
+}
+
+func (d *DHWController) controlUpdateHandler(client mqtt.Client, message mqtt.Message) {
+	topic := message.Topic()[strings.LastIndex(message.Topic(), "/")+1:]
+	payload := strings.ToLower(strings.TrimSpace(string(message.Payload())))
+	logger.L().Infof("DHW got MQTT control request: %v : %v", topic, payload)
+	old, accepted := d.controlValue(topic), false
+	defer func() {
+		auditControl(d.queries, message.Topic(), old, d.controlValue(topic), string(message.Payload()), accepted)
+	}()
+
+	switch topic {
+	case "mode":
+		switch payload {
+		case dhwModeAuto, dhwModeOn, dhwModeOff:
+		default:
+			logger.L().Errorf("Invalid DHW mode: %v", payload)
+			return
+		}
+		d.mu.Lock()
+		d.mode = payload
+		d.mu.Unlock()
+	case "setpoint":
+		sp, err := strconv.ParseFloat(payload, 64)
+		if err != nil {
+			logger.L().Error(err)
+			return
+		}
+		if sp < dhwMinSetpoint || sp > dhwMaxSetpoint {
+			logger.L().Errorf("DHW setpoint %v is out of range [%v, %v]", sp, dhwMinSetpoint, dhwMaxSetpoint)
+			return
+		}
+		d.mu.Lock()
+		d.setpoint = sp
+		d.mu.Unlock()
+	default:
+		logger.L().Errorf("Unknown control topic: %s", topic)
+		return
+	}
+	accepted = true
+
+	if err := d.writeState(); err != nil {
+		logger.L().Error(err)
+	}
+	d.evaluate(clk.Now())
+}
+
+// controlValue returns current value of the control topic for the audit log.
+func (d *DHWController) controlValue(topic string) string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	switch topic {
+	case "mode":
+		return d.mode
+	case "setpoint":
+		return strconv.FormatFloat(d.setpoint, 'f', -1, 64)
+	}
+	return ""
+}
+
+func (d *DHWController) writeState() error {
+	d.mu.RLock()
+	mode, sp := d.mode, d.setpoint
+	d.mu.RUnlock()
+
+	if err := d.queries.UpsertControllerValue(
+		context.Background(), db.UpsertControllerValueParams{Name: "dhw_mode", Value: mode},
+	); err != nil {
+		return err
+	}
+	return d.queries.UpsertControllerValue(
+		context.Background(),
+		db.UpsertControllerValueParams{Name: "dhw_setpoint", Value: strconv.FormatFloat(sp, 'f', -1, 64)},
+	)
+}
+
+func (d *DHWController) readState() {
+	if mode, err := d.queries.GetControllerValue(context.Background(), "dhw_mode"); err == nil {
+		d.mode = mode
+	}
+	if val, err := d.queries.GetControllerValue(context.Background(), "dhw_setpoint"); err == nil {
+		if sp, err := strconv.ParseFloat(val, 64); err == nil {
+			d.setpoint = sp
+		}
+	}
+	logger.L().Debugf("Loaded DHW state: mode=%v, setpoint=%v", d.mode, d.setpoint)
+}
@@ -0,0 +1,299 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pborman/getopt/v2"
+
+	"github.com/antst/mzotbc/internal/config"
+	"github.com/antst/mzotbc/internal/db"
+	"github.com/antst/mzotbc/internal/logger"
+)
+
+const (
+	exportZones   = "zones"
+	exportSensors = "sensors"
+	exportBoiler  = "boiler"
+
+	exportCSV   = "csv"
+	exportJSONL = "jsonl"
+)
+
+// exportFilter selects recorded history by time range, zone and sensor names.
+// Empty zone and sensor lists select everything.
+type exportFilter struct {
+	since, until time.Time
+	zones        []string
+	sensors      []string
+	// zoneSensors are names of the sensors, configured in the selected zones
+	zoneSensors []string
+}
+
+// exportTable is a recorded history, ready to be written. Values are float64, *float64,
+// bool, string or time.Time.
+type exportTable struct {
+	header []string
+	rows   [][]any
+}
+
+// Export dumps recorded zone, sensor or boiler history as CSV or JSON lines.
+func Export() {
+	dbFile := getopt.StringLong("export-db", 0, "", "DB file with recorded history, `db_file` by default")
+	from := getopt.StringLong("export-from", 0, "", "start of the exported period")
+	to := getopt.StringLong("export-to", 0, "", "end of the exported period")
+	data := getopt.StringLong("export-data", 0, exportZones, "exported history: zones, sensors or boiler")
+	zones := getopt.StringLong("export-zone", 0, "", "comma separated zones, all by default")
+	sensors := getopt.StringLong("export-sensor", 0, "", "comma separated sensors, all by default")
+	format := getopt.StringLong("export-format", 0, "", "csv or jsonl, by extension of the output by default")
+	output := getopt.StringLong("export-output", 0, "", "output file, export.csv or export.jsonl by default")
+
+	cfg := config.Get()
+	since, until, err := parsePeriod(*from, *to)
+	if err != nil {
+		logger.L().Panic(err)
+	}
+	filter := exportFilter{since: since, until: until, zones: splitList(*zones), sensors: splitList(*sensors)}
+	filter.zoneSensors = zoneSensorNames(cfg, filter.zones)
+	if *format == "" {
+		*format = exportCSV
+		if strings.HasSuffix(*output, ".jsonl") || strings.HasSuffix(*output, ".json") {
+			*format = exportJSONL
+		}
+	}
+	if *format != exportCSV && *format != exportJSONL {
+		logger.L().Panicf("Invalid --export-format `%v`, expected csv or jsonl", *format)
+	}
+	if *output == "" {
+		*output = "export." + *format
+	}
+
+	q := openHistoryDB(cfg, *dbFile)
+	var t *exportTable
+	switch *data {
+	case exportZones:
+		t, err = exportZoneHistory(q, filter)
+	case exportSensors:
+		t, err = exportSensorHistory(q, filter)
+	case exportBoiler:
+		t, err = exportBoilerHistory(q, filter)
+	default:
+		logger.L().Panicf("Invalid --export-data `%v`, expected zones, sensors or boiler", *data)
+	}
+	if err != nil {
+		logger.L().Panic(err)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		logger.L().Panic(err)
+	}
+	defer f.Close()
+	if *format == exportJSONL {
+		err = t.writeJSONL(f)
+	} else {
+		err = t.writeCSV(f)
+	}
+	if err != nil {
+		logger.L().Panic(err)
+	}
+	logger.L().Infof("Exported %d records of %s history to `%s`", len(t.rows), *data, *output)
+}
+
+func splitList(val string) []string {
+	var items []string
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (f exportFilter) zone(name string) bool {
+	return len(f.zones) == 0 || slices.Contains(f.zones, name)
+}
+
+// sensor selects listed sensors and sensors of listed zones.
+func (f exportFilter) sensor(name string) bool {
+	if len(f.zones) == 0 && len(f.sensors) == 0 {
+		return true
+	}
+	return slices.Contains(f.zoneSensors, name) || slices.Contains(f.sensors, name)
+}
+
+// zoneSensorNames returns names of the sensors, as they are recorded, configured in the zones.
+func zoneSensorNames(cfg *config.Config, zones []string) []string {
+	var names []string
+	for _, zone := range zones {
+		zc, ok := cfg.Zones[zone]
+		if !ok {
+			logger.L().Warnf("Zone `%s` isn't configured, its sensors aren't known", zone)
+			continue
+		}
+		for i, sensor := range zc.Sensors {
+			names = append(names, zoneSensorName(zone, i, sensor))
+		}
+	}
+	return names
+}
+
+// exportZoneHistory returns zone history with the boiler Tset, commanded at that moment.
+// Its columns are accepted by `mzotbc replay --replay-input`.
+func exportZoneHistory(q *db.Queries, f exportFilter) (*exportTable, error) {
+	ctx := context.Background()
+	history, err := q.ListHistory(ctx, db.ListHistoryParams{Since: f.since.UTC(), Until: f.until.UTC()})
+	if err != nil {
+		return nil, fmt.Errorf("zone history: %w", err)
+	}
+	boiler, err := q.ListBoilerHistory(ctx, db.ListBoilerHistoryParams{Since: f.since.UTC(), Until: f.until.UTC()})
+	if err != nil {
+		return nil, fmt.Errorf("boiler history: %w", err)
+	}
+
+	t := &exportTable{header: []string{
+		"time", "zone", "temperature", "setpoint", "effective_setpoint", "tset", "outside", "flow", "boiler_tset",
+	}}
+	cursor := newBoilerCursor(boiler)
+	for _, h := range history {
+		b := cursor.at(h.RecordedAt)
+		if !f.zone(h.ZoneName) {
+			continue
+		}
+		var boilerTSet *float64
+		if b != nil {
+			boilerTSet = &b.Tset
+		}
+		t.rows = append(t.rows, []any{
+			h.RecordedAt, h.ZoneName, h.Temperature, h.Setpoint, h.EffectiveSetpoint, h.Tset,
+			h.OutsideTemperature, nullFloat(h.FlowTemperature), boilerTSet,
+		})
+	}
+	return t, nil
+}
+
+func exportSensorHistory(q *db.Queries, f exportFilter) (*exportTable, error) {
+	history, err := q.ListSensorHistory(context.Background(), db.ListSensorHistoryParams{
+		Since: f.since.UTC(), Until: f.until.UTC(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sensor history: %w", err)
+	}
+	t := &exportTable{header: []string{"time", "sensor", "value"}}
+	for _, h := range history {
+		if f.sensor(h.SensorName) {
+			t.rows = append(t.rows, []any{h.RecordedAt, h.SensorName, h.Value})
+		}
+	}
+	return t, nil
+}
+
+func exportBoilerHistory(q *db.Queries, f exportFilter) (*exportTable, error) {
+	history, err := q.ListBoilerHistory(context.Background(), db.ListBoilerHistoryParams{
+		Since: f.since.UTC(), Until: f.until.UTC(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("boiler history: %w", err)
+	}
+	t := &exportTable{header: []string{"time", "tset", "ch_enable", "flow"}}
+	for _, h := range history {
+		t.rows = append(t.rows, []any{h.RecordedAt, h.Tset, h.ChEnable, nullFloat(h.FlowTemperature)})
+	}
+	return t, nil
+}
+
+func nullFloat(v sql.NullFloat64) *float64 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Float64
+}
+
+// exportValue formats value as a CSV field or a JSON value. Time is written in local
+// time zone as RFC3339, missing values are empty or null.
+func exportValue(v any, jsonValue bool) string {
+	switch v := v.(type) {
+	case time.Time:
+		s := v.Local().Format(time.RFC3339)
+		if jsonValue {
+			return strconv.Quote(s)
+		}
+		return s
+	case float64:
+		return fmtFloat(v)
+	case *float64:
+		if v != nil {
+			return fmtFloat(*v)
+		}
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		if jsonValue {
+			data, _ := json.Marshal(v)
+			return string(data)
+		}
+		return v
+	}
+	if jsonValue {
+		return "null"
+	}
+	return ""
+}
+
+func (t *exportTable) writeCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	_ = out.Write(t.header)
+	row := make([]string, len(t.header))
+	for _, r := range t.rows {
+		for i, v := range r {
+			row[i] = exportValue(v, false)
+		}
+		_ = out.Write(row)
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// writeJSONL writes a JSON object per row, fields are in order of the header.
+func (t *exportTable) writeJSONL(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	for _, r := range t.rows {
+		_ = out.WriteByte('{')
+		for i, v := range r {
+			if i > 0 {
+				_ = out.WriteByte(',')
+			}
+			_, _ = fmt.Fprintf(out, "%q:%s", t.header[i], exportValue(v, true))
+		}
+		_, _ = out.WriteString("}\n")
+	}
+	return out.Flush()
+}
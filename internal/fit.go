@@ -0,0 +1,219 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pborman/getopt/v2"
+	"gopkg.in/yaml.v3"
+
+	"github.com/antst/mzotbc/internal/config"
+	"github.com/antst/mzotbc/internal/db"
+	"github.com/antst/mzotbc/internal/logger"
+	"github.com/antst/mzotbc/internal/thermo_model"
+)
+
+// fitFilter selects steady-state records of zone history: setpoint unchanged for `settle`,
+// room within `deadband` of it, heating on and flow temperature known.
+type fitFilter struct {
+	settle   time.Duration
+	deadband float64
+}
+
+// Fit fits heating curve coefficients to steady-state periods of recorded history
+// and optionally writes them to config.
+func Fit() {
+	dbFile := getopt.StringLong("fit-db", 0, "", "DB file with recorded history, `db_file` by default")
+	from := getopt.StringLong("fit-from", 0, "", "start of the fitted period")
+	to := getopt.StringLong("fit-to", 0, "", "end of the fitted period")
+	curve := getopt.StringLong("fit-model", 0, "", "curve type: polynomial or linear, type of configured model by default")
+	ridge := 10.0
+	getopt.FlagLong(&ridge, "fit-ridge", 0, "weight of the prior curve, in samples")
+	filter := fitFilter{settle: 2 * time.Hour, deadband: 0.3}
+	getopt.FlagLong(&filter.settle, "fit-settle", 0, "time after setpoint change, before zone is steady")
+	getopt.FlagLong(&filter.deadband, "fit-deadband", 0, "max distance of room temperature from setpoint")
+	write := getopt.BoolLong("fit-write", 0, "write fitted coefficients to the config file")
+
+	cfg := config.Get()
+	since, until, err := parsePeriod(*from, *to)
+	if err != nil {
+		logger.L().Panic(err)
+	}
+
+	// configured fitted model is a prior of a new fit of the same type,
+	// otherwise builtin curve is used, approximated by the curve type if needed
+	builtin := builtinHeatingModel(cfg)
+	prior := builtin
+	if mc := cfg.HeatingModel; mc != nil && len(mc.Coefficients) > 0 {
+		if *curve == "" {
+			*curve = mc.Type
+		}
+		if mc.Type == *curve {
+			if prior, err = thermo_model.NewModel(mc.Type, mc.Coefficients); err != nil {
+				logger.L().Panic(err)
+			}
+		}
+	}
+	if *curve == "" {
+		*curve = thermo_model.TypePolynomial
+	}
+	if prior.Type != *curve {
+		if prior, err = prior.Approximate(*curve); err != nil {
+			logger.L().Panic(err)
+		}
+	}
+
+	samples, err := loadFitSamples(cfg, openHistoryDB(cfg, *dbFile), since, until, filter)
+	if err != nil {
+		logger.L().Panic(err)
+	}
+	logger.L().Infof("Fitting %s heating curve to %d steady-state samples", *curve, len(samples))
+	m, err := thermo_model.Fit(*curve, samples, prior, ridge)
+	if err != nil {
+		logger.L().Panic(err)
+	}
+	for i, v := range m.Coeff {
+		m.Coeff[i], _ = strconv.ParseFloat(strconv.FormatFloat(v, 'g', 7, 64), 64)
+	}
+
+	out := csv.NewWriter(os.Stdout)
+	_ = out.Write([]string{"scope", "metric", "value"})
+	_ = out.Write([]string{"fit", "samples", strconv.Itoa(len(samples))})
+	_ = out.Write([]string{"fit", "builtin_rmse", fmtFloat(builtin.RMSE(samples))})
+	_ = out.Write([]string{"fit", "prior_rmse", fmtFloat(prior.RMSE(samples))})
+	_ = out.Write([]string{"fit", "fitted_rmse", fmtFloat(m.RMSE(samples))})
+	out.Flush()
+
+	mc := &config.HeatingModelConfig{Type: m.Type, Coefficients: m.Coeff, Active: config.HeatingModelFitted}
+	if cfg.HeatingModel != nil {
+		mc.Active = cfg.HeatingModel.Active
+	}
+	if !*write {
+		data, err := yaml.Marshal(map[string]*config.HeatingModelConfig{"heating_model": mc})
+		if err != nil {
+			logger.L().Panic(err)
+		}
+		fmt.Printf("\n%s", data)
+		return
+	}
+	if err := config.WriteHeatingModel(config.File(), mc); err != nil {
+		logger.L().Panic(err)
+	}
+	logger.L().Infof("Fitted heating model is written to `%s`", config.File())
+}
+
+func loadFitSamples(
+	cfg *config.Config, q *db.Queries, since, until time.Time, filter fitFilter,
+) ([]thermo_model.Sample, error) {
+	ctx := context.Background()
+	history, err := q.ListHistory(ctx, db.ListHistoryParams{Since: since.UTC(), Until: until.UTC()})
+	if err != nil {
+		return nil, fmt.Errorf("zone history: %w", err)
+	}
+	changes, err := q.ListHeatingParameterChanges(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("heating parameter changes: %w", err)
+	}
+
+	byZone := make(map[string][]db.ZoneHistory)
+	for _, h := range history {
+		byZone[h.ZoneName] = append(byZone[h.ZoneName], h)
+	}
+	names := make([]string, 0, len(byZone))
+	for name := range byZone {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var samples []thermo_model.Sample
+	for _, name := range names {
+		zc, ok := cfg.Zones[name]
+		if !ok {
+			logger.L().Warnf("Zone `%s` of recorded history is not configured, skipped", name)
+			continue
+		}
+		if zc.PI != nil {
+			// PI trim of the heating parameter isn't recorded, so the curve input is unknown
+			logger.L().Warnf("Zone `%s` has PI trim of the heating parameter, skipped", name)
+			continue
+		}
+		hp := zoneHeatingParameters(cfg, q, name, changes)
+		zs := filter.samples(byZone[name], hp, zc)
+		logger.L().Infof("Zone %s: %d steady-state samples", name, len(zs))
+		samples = append(samples, zs...)
+	}
+	return samples, nil
+}
+
+// zoneHeatingParameters returns heating parameter of the zone in effect at given time,
+// restored from autotune audit trail.
+func zoneHeatingParameters(
+	cfg *config.Config, q *db.Queries, zone string, changes []db.HeatingParameterChange,
+) func(time.Time) float64 {
+	var zc []db.HeatingParameterChange
+	for _, ch := range changes {
+		if ch.ZoneName == zone {
+			zc = append(zc, ch)
+		}
+	}
+	current := cfg.Zones[zone].EffectiveHeatingParameter(*cfg.DefaultHeatingParameter)
+	if v, err := q.GetZoneValue(context.Background(), db.GetZoneValueParams{
+		ZoneName: zone, Name: autotuneHPValue,
+	}); err == nil {
+		current = v
+	}
+	return func(t time.Time) float64 {
+		i := sort.Search(len(zc), func(i int) bool { return zc[i].ChangedAt.After(t) })
+		if i < len(zc) {
+			return zc[i].OldValue
+		}
+		return current
+	}
+}
+
+func (f fitFilter) samples(
+	history []db.ZoneHistory, hp func(time.Time) float64, zc *config.ZoneConfig,
+) []thermo_model.Sample {
+	var samples []thermo_model.Sample
+	var steadySince time.Time
+	for i, h := range history {
+		if i == 0 || h.EffectiveSetpoint != history[i-1].EffectiveSetpoint ||
+			h.RecordedAt.Sub(history[i-1].RecordedAt) > heatupMaxGap {
+			steadySince = h.RecordedAt
+		}
+		if h.RecordedAt.Sub(steadySince) < f.settle || h.Tset < minEnableTemp || !h.FlowTemperature.Valid ||
+			h.OutsideTemperature <= minValidTemp || math.Abs(h.Temperature-h.EffectiveSetpoint) > f.deadband {
+			continue
+		}
+		// same heating parameter, as calculateSetpoint uses without PI trim
+		p := hp(h.RecordedAt) - deadbandError(zc.Emitter, h.Temperature-h.EffectiveSetpoint)**zc.RoomCompensation
+		samples = append(samples, thermo_model.Sample{
+			HP: p, SP: h.EffectiveSetpoint, OT: h.OutsideTemperature, Flow: h.FlowTemperature.Float64,
+		})
+	}
+	return samples
+}
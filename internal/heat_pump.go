@@ -0,0 +1,268 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+
+	"github.com/antst/mzotbc/internal/config"
+	"github.com/antst/mzotbc/internal/logger"
+	"github.com/antst/mzotbc/internal/safe_mqtt"
+)
+
+// SG Ready states
+const (
+	sgBlock  = 1
+	sgNormal = 2
+	sgBoost  = 3
+	sgForce  = 4
+)
+
+var sgReadyNames = map[int]string{sgBlock: "block", sgNormal: "normal", sgBoost: "boost", sgForce: "force"}
+
+// heatPumpReport is published to `<control_topic>/heat_pump`.
+type heatPumpReport struct {
+	On      bool      `json:"on"`
+	Since   time.Time `json:"since"`
+	Defrost bool      `json:"defrost"`
+	SGReady string    `json:"sg_ready"`
+	TSet    float64   `json:"tset"`
+}
+
+// heatPump keeps compressor run and off times, holds commands during defrost
+// and applies SG Ready inputs to the commands, sent to the heat source.
+type heatPump struct {
+	cfg    *config.HeatPumpConfig
+	mqtt   safe_mqtt.MqttClient
+	topic  string
+	boiler *BoilerController
+	force  func()
+
+	mu        sync.Mutex
+	on        bool
+	changedAt time.Time
+	defrost   bool
+	sgReady   int
+	tSet      float64
+	last      heatPumpReport
+}
+
+func newHeatPump(
+	_cfg *config.HeatPumpConfig, _mqttCfg *config.MQTTConfig, _boiler *BoilerController, _force func(),
+) *heatPump {
+	h := &heatPump{
+		cfg:     _cfg,
+		mqtt:    safe_mqtt.InitMQTTClient(_mqttCfg.URL, "otbs-heatpump-"+uuid.New().String()),
+		topic:   _mqttCfg.ControlTopic + "/heat_pump",
+		boiler:  _boiler,
+		force:   _force,
+		sgReady: sgNormal,
+		tSet:    fallbackTSet,
+	}
+	if _cfg.Defrost != nil {
+		h.mqtt.SafeSubscribe(_cfg.Defrost.Topic, mqttQoS, h.defrostHandler)
+	}
+	if _cfg.SGReady != nil {
+		h.mqtt.SafeSubscribe(_cfg.SGReady.Topic, mqttQoS, h.sgReadyHandler)
+	}
+	if _cfg.Flow != nil {
+		h.mqtt.SafeSubscribe(_cfg.Flow.Topic, mqttQoS, h.flowHandler)
+	}
+	return h
+}
+
+func (h *heatPump) defrostHandler(client mqtt.Client, message mqtt.Message) {
+	on, err := extractBoolPlainOrJson(message, h.cfg.Defrost.JSONEntry)
+	if err != nil {
+		logger.L().Error(err)
+		return
+	}
+	if h.cfg.Defrost.Invert {
+		on = !on
+	}
+
+	h.mu.Lock()
+	changed := on != h.defrost
+	h.defrost = on
+	h.mu.Unlock()
+	if !changed {
+		return
+	}
+	logger.L().Infof("Heat pump defrost: %v", on)
+	h.publish()
+	if !on {
+		h.force()
+	}
+}
+
+func (h *heatPump) sgReadyHandler(client mqtt.Client, message mqtt.Message) {
+	st, err := parseSGReady(message, h.cfg.SGReady.JSONEntry)
+	if err != nil {
+		logger.L().Warn(err)
+		return
+	}
+
+	h.mu.Lock()
+	changed := st != h.sgReady
+	h.sgReady = st
+	h.mu.Unlock()
+	if changed {
+		logger.L().Infof("SG Ready state: %v", sgReadyNames[st])
+		h.force()
+	}
+}
+
+func parseSGReady(message mqtt.Message, jsonEntry *string) (int, error) {
+	if v, err := extractF64PlainOrJson(message, jsonEntry); err == nil {
+		if st := int(v); float64(st) == v && st >= sgBlock && st <= sgForce {
+			return st, nil
+		}
+	}
+	var name string
+	if jsonEntry == nil {
+		name = string(message.Payload())
+	} else {
+		var valMap map[string]interface{}
+		if err := json.Unmarshal(message.Payload(), &valMap); err == nil {
+			name, _ = valMap[*jsonEntry].(string)
+		}
+	}
+	name = strings.ToLower(strings.TrimSpace(name))
+	for st, n := range sgReadyNames {
+		if n == name {
+			return st, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid SG Ready state in %v: %v", message.Topic(), string(message.Payload()))
+}
+
+// flowHandler passes flow temperature feedback to the boiler state, except during defrost.
+func (h *heatPump) flowHandler(client mqtt.Client, message mqtt.Message) {
+	v, err := extractF64PlainOrJson(message, h.cfg.Flow.JSONEntry)
+	if err != nil {
+		logger.L().Error(err)
+		return
+	}
+	if h.defrosting() {
+		logger.L().Debugf("Ignore heat pump flow temperature %.1f during defrost", v)
+		return
+	}
+	h.boiler.SetFlowTemperature(v**h.cfg.Flow.Scale + *h.cfg.Flow.Offset)
+}
+
+func (h *heatPump) defrosting() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.defrost
+}
+
+// command applies compressor timing, defrost and SG Ready to the requested Tset and CH enable.
+func (h *heatPump) command(tSet float64, chEnable bool, now time.Time) (float64, bool) {
+	h.mu.Lock()
+	if h.defrost && !h.changedAt.IsZero() {
+		// defrost dips flow temperature, nothing should react on it
+		tSet, on := h.tSet, h.on
+		h.mu.Unlock()
+		return tSet, on
+	}
+
+	demand := chEnable && tSet >= *h.cfg.MinFlow
+	switch h.sgReady {
+	case sgBlock:
+		demand = false
+	case sgBoost:
+		tSet += *h.cfg.SGReady.BoostOffset
+	case sgForce:
+		tSet += 2 * *h.cfg.SGReady.BoostOffset
+		demand = true
+	}
+	// boost never takes flow above max_flow
+	tSet = min(max(tSet, *h.cfg.MinFlow), *h.cfg.MaxFlow)
+
+	on := h.on
+	switch {
+	case h.sgReady == sgBlock:
+		// utility block doesn't wait for the minimal run time
+		on = false
+	case demand && !on:
+		on = h.changedAt.IsZero() || now.Sub(h.changedAt) >= h.cfg.MinOff
+	case !demand && on:
+		on = now.Sub(h.changedAt) < h.cfg.MinRun
+	}
+	if on != h.on {
+		h.on, h.changedAt = on, now
+		logger.L().Infof("Heat pump compressor: %v", on)
+	}
+	if !on {
+		tSet = fallbackTSet
+	} else if !demand {
+		// minimal run time, lowest flow temperature
+		tSet = *h.cfg.MinFlow
+	}
+	h.tSet = math.Round(tSet*2) / 2
+	tSet = h.tSet
+	h.mu.Unlock()
+
+	h.publish()
+	return tSet, on
+}
+
+// publish publishes heat pump state, if it has changed.
+func (h *heatPump) publish() {
+	h.mu.Lock()
+	r := heatPumpReport{On: h.on, Since: h.changedAt, Defrost: h.defrost, SGReady: sgReadyNames[h.sgReady], TSet: h.tSet}
+	if r == h.last {
+		h.mu.Unlock()
+		return
+	}
+	h.last = r
+	h.mu.Unlock()
+
+	payload, err := json.Marshal(r)
+	if err != nil {
+		logger.L().Error(err)
+		return
+	}
+	h.mqtt.SafePublish(h.topic, mqttQoS, true, payload)
+}
+
+// heatPumpSetpoint is a weighted mean of Tsets of zones with demand: heat pump runs
+// long at low flow temperature, instead of following the most demanding zone.
+func (c *ThermoController) heatPumpSetpoint() (float64, bool) {
+	tAvg, weight := 0.0, 0.0
+	for zone, f := range c.zoneTRs {
+		if f <= fallbackTSet || f < *c.cfg.Boiler.HeatPump.MinFlow {
+			continue
+		}
+		tAvg += *zone.cfg.Weight * f
+		weight += *zone.cfg.Weight
+	}
+	if weight == 0 {
+		return defaultTSet, false
+	}
+	return math.Round(tAvg/weight*2) / 2, true
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/antst/mzotbc/internal/config"
+	"github.com/antst/mzotbc/internal/logger"
+	"github.com/antst/mzotbc/internal/thermo_model"
+)
+
+// initHeatingModel loads fitted heating curve from config and restores the active one.
+func (c *ThermoController) initHeatingModel() {
+	c.builtinModel = builtinHeatingModel(c.cfg)
+	c.heatingModel = c.builtinModel
+	active := config.HeatingModelBuiltin
+	if mc := c.cfg.HeatingModel; mc != nil && len(mc.Coefficients) > 0 {
+		m, err := thermo_model.NewModel(mc.Type, mc.Coefficients)
+		if err != nil {
+			logger.L().Panic(err)
+		}
+		c.fittedModel = m
+		active = mc.Active
+	}
+	c.applyHeatingModel(c.readValueWithDefault("heating_model", active))
+}
+
+// setHeatingModel switches heating curve: `builtin` or `fitted`.
+func (c *ThermoController) setHeatingModel(val string) bool {
+	if !c.applyHeatingModel(strings.ToLower(strings.TrimSpace(val))) {
+		return false
+	}
+	if err := c.writeValue("heating_model", c.heatingModelName()); err != nil {
+		logger.L().Error(err)
+	}
+	c.requestUpdate()
+	return true
+}
+
+func (c *ThermoController) applyHeatingModel(name string) bool {
+	var m *thermo_model.Model
+	switch name {
+	case config.HeatingModelBuiltin:
+		m = c.builtinModel
+	case config.HeatingModelFitted:
+		if c.fittedModel == nil {
+			logger.L().Warn("No fitted heating model in config, builtin one is used")
+			m = c.builtinModel
+		} else {
+			m = c.fittedModel
+		}
+	default:
+		logger.L().Warnf("Invalid heating model: %v", name)
+		return false
+	}
+
+	c.modelMu.Lock()
+	c.heatingModel = m
+	c.modelMu.Unlock()
+
+	logger.L().Infof("Heating model: %v (%s)", c.heatingModelName(), m.Type)
+	c.mqtt.SafePublish(c.cfg.MQTTConfig.ControlTopic+"/active_heating_model", mqttQoS, true, c.heatingModelName())
+	return true
+}
+
+func (c *ThermoController) currentHeatingModel() *thermo_model.Model {
+	c.modelMu.RLock()
+	defer c.modelMu.RUnlock()
+	return c.heatingModel
+}
+
+func (c *ThermoController) heatingModelName() string {
+	if c.currentHeatingModel() == c.builtinModel {
+		return config.HeatingModelBuiltin
+	}
+	return config.HeatingModelFitted
+}
+
+// builtinHeatingModel returns builtin heating curve of the heat source: low temperature
+// curve family for heat pumps, reverse engineered boiler curve otherwise.
+func builtinHeatingModel(cfg *config.Config) *thermo_model.Model {
+	if cfg.Boiler.HeatPump != nil || heatPumpLead(cfg.Boiler) {
+		return thermo_model.LowTemperature()
+	}
+	return thermo_model.Builtin()
+}
+
+// heatPumpLead reports whether the cascade load is always served by a heat pump first.
+// Heat pumps lead with `heat_pump_first` and the first source leads with `capacity`,
+// while `lead_lag` rotates the lead, so every source has to be a heat pump.
+func heatPumpLead(cfg *config.BoilerConfig) bool {
+	if len(cfg.Sources) == 0 {
+		return false
+	}
+	switch cfg.Sequencing.Strategy {
+	case config.SequencingHeatPumpFirst:
+		return slices.ContainsFunc(cfg.Sources, func(s *config.HeatSourceConfig) bool { return s.HeatPump != nil })
+	case config.SequencingCapacity:
+		return cfg.Sources[0].HeatPump != nil
+	}
+	return !slices.ContainsFunc(cfg.Sources, func(s *config.HeatSourceConfig) bool { return s.HeatPump == nil })
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/antst/mzotbc/internal/config"
+	"github.com/antst/mzotbc/internal/db"
+	"github.com/antst/mzotbc/internal/logger"
+)
+
+// flowValidity is how long reported boiler water temperature is considered current.
+const flowValidity = 5 * time.Minute
+
+// recordHistory stores new sensor readings, current state of every zone and commanded boiler
+// values, it is a base for learning, replay and export. Timestamps are stored in UTC,
+// so they compare as text.
+func (c *ThermoController) recordHistory(state *thermoState, now time.Time) {
+	c.recordSensorHistory()
+	if state.OT <= minValidTemp {
+		return
+	}
+	flow := sql.NullFloat64{}
+	bs := c.boilerState()
+	defrost := c.defrosting()
+	if now.Sub(bs.Updated) < flowValidity && bs.BoilerWaterTemperature > 0 && !defrost {
+		flow = sql.NullFloat64{Float64: bs.BoilerWaterTemperature, Valid: true}
+	}
+
+	tSet, chEnable := state.sentTSet, state.sentCHEnable
+	err := c.queries.InsertBoilerHistory(context.Background(), db.InsertBoilerHistoryParams{
+		Tset:            tSet,
+		ChEnable:        chEnable,
+		FlowTemperature: flow,
+		RecordedAt:      now.UTC(),
+	})
+	if err != nil {
+		logger.L().Errorf("Failed to record boiler history: %v", err)
+	}
+
+	for _, zone := range c.zones {
+		sp, rt, ok := zone.getPair()
+		if !ok {
+			continue
+		}
+		zone.mu.RLock()
+		esp := zone.effectiveSetpoint
+		zone.mu.RUnlock()
+
+		err := c.queries.InsertZoneHistory(context.Background(), db.InsertZoneHistoryParams{
+			ZoneName:           zone.name,
+			Temperature:        rt,
+			Setpoint:           sp,
+			EffectiveSetpoint:  esp,
+			Tset:               c.zoneTRs[zone],
+			OutsideTemperature: state.OT,
+			FlowTemperature:    flow,
+			RecordedAt:         now.UTC(),
+		})
+		if err != nil {
+			logger.L().Errorf("Failed to record history of zone %s: %v", zone.name, err)
+		}
+	}
+}
+
+// recordSensorHistory stores values of zone and outside sensors, received since the last record.
+func (c *ThermoController) recordSensorHistory() {
+	sensors := slices.Clone(c.outside.temperatureSensors)
+	for _, zone := range c.zones {
+		sensors = append(sensors, zone.sensors...)
+	}
+	for _, s := range sensors {
+		s.lock.RLock()
+		v, at := s.value, s.timestamp
+		s.lock.RUnlock()
+		if !at.After(zeroTS) || !at.After(s.recordedAt) {
+			continue
+		}
+		err := c.queries.InsertSensorHistory(context.Background(), db.InsertSensorHistoryParams{
+			SensorName: s.name,
+			Value:      v,
+			RecordedAt: at.UTC(),
+		})
+		if err != nil {
+			logger.L().Errorf("Failed to record history of sensor %s: %v", s.name, err)
+			continue
+		}
+		s.recordedAt = at
+	}
+}
+
+// parsePeriod parses optional start and end of the period of recorded history. Without start
+// period begins with the first record, without end it lasts past the last one.
+func parsePeriod(from, to string) (since, until time.Time, err error) {
+	since, until = time.Unix(0, 0), time.Now().AddDate(100, 0, 0)
+	if from != "" {
+		if since, err = parseControlTime(from); err != nil {
+			return since, until, fmt.Errorf("invalid start of the period `%v`: %w", from, err)
+		}
+	}
+	if to != "" {
+		if until, err = parseControlTime(to); err != nil {
+			return since, until, fmt.Errorf("invalid end of the period `%v`: %w", to, err)
+		}
+	}
+	return since, until, nil
+}
+
+// openHistoryDB opens recorded history read-only, from `db_file` of the config by default.
+func openHistoryDB(cfg *config.Config, dbFile string) *db.Queries {
+	if dbFile == "" {
+		dbFile = cfg.DBFile
+	}
+	return db.OpenReadOnly(dbFile)
+}
+
+// boilerCursor joins boiler history to zone history, both ordered by time.
+type boilerCursor struct {
+	boiler []db.BoilerHistory
+	i      int
+}
+
+func newBoilerCursor(boiler []db.BoilerHistory) *boilerCursor {
+	return &boilerCursor{boiler: boiler, i: -1}
+}
+
+// at returns the latest boiler record, not after `t`, or nil, if there is none.
+// Cursor only moves forward, so `t` must not decrease between calls.
+func (c *boilerCursor) at(t time.Time) *db.BoilerHistory {
+	for c.i+1 < len(c.boiler) && !c.boiler[c.i+1].RecordedAt.After(t) {
+		c.i++
+	}
+	if c.i < 0 {
+		return nil
+	}
+	return &c.boiler[c.i]
+}
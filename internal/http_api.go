@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/antst/mzotbc/internal/config"
+	"github.com/antst/mzotbc/internal/db"
+	"github.com/antst/mzotbc/internal/logger"
+)
+
+// httpAPI serves read-only state of the controller.
+type httpAPI struct {
+	queries *db.Queries
+}
+
+func startHTTPAPI(_cfg *config.HTTPConfig, _q *db.Queries) {
+	api := &httpAPI{queries: _q}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/audit", api.audit)
+	mux.HandleFunc("GET /api/alarms", api.alarmHistory)
+
+	srv := &http.Server{Addr: _cfg.Listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		logger.L().Infof("HTTP API listens on `%s`", _cfg.Listen)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.L().Errorf("HTTP API: %v", err)
+		}
+	}()
+}
+
+// audit handles `/api/audit?from=<time>&to=<time>&topic=<text>`, all parameters are optional.
+func (a *httpAPI) audit(w http.ResponseWriter, r *http.Request) {
+	since, until, err := parsePeriod(r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	records, err := listAudit(a.queries, since, until, r.URL.Query().Get("topic"))
+	if err != nil {
+		logger.L().Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(records); err != nil {
+		logger.L().Error(err)
+	}
+}
+
+// alarmHistory handles `/api/alarms?from=<time>&to=<time>&name=<text>`, all parameters are optional.
+func (a *httpAPI) alarmHistory(w http.ResponseWriter, r *http.Request) {
+	since, until, err := parsePeriod(r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := listAlarms(a.queries, since, until, r.URL.Query().Get("name"))
+	if err != nil {
+		logger.L().Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(events); err != nil {
+		logger.L().Error(err)
+	}
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"strings"
+	"time"
+
+	"github.com/antst/mzotbc/internal/config"
+	"github.com/antst/mzotbc/internal/logger"
+)
+
+var controlTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04", time.DateOnly}
+
+// parseControlTime parses time, given in control topics or command line, in local time zone.
+func parseControlTime(val string) (time.Time, error) {
+	var t time.Time
+	var err error
+	for _, layout := range controlTimeLayouts {
+		if t, err = time.ParseInLocation(layout, val, time.Local); err == nil {
+			break
+		}
+	}
+	return t, err
+}
+
+func (c *ThermoController) restoreMode() {
+	c.mode = c.readValueWithDefault("mode", config.ModeComfort)
+	if _, ok := c.cfg.Modes[c.mode]; !ok {
+		c.mode = config.ModeComfort
+	}
+	if val, err := c.readValue("holiday_until"); err == nil && val != "" {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			c.holidayUntil = t
+		}
+	}
+	c.publishMode()
+}
+
+// setMode switches operating mode of the controller.
+func (c *ThermoController) setMode(val string) bool {
+	mode := strings.ToLower(strings.TrimSpace(val))
+	if _, ok := c.cfg.Modes[mode]; !ok {
+		logger.L().Warnf("Invalid mode: %v", val)
+		return false
+	}
+
+	c.modeMu.Lock()
+	c.mode = mode
+	if mode != config.ModeHoliday {
+		c.holidayUntil = time.Time{}
+	}
+	c.modeMu.Unlock()
+
+	logger.L().Infof("Operating mode: %v", mode)
+	c.persistMode()
+	c.requestUpdate()
+	return true
+}
+
+// setHolidayUntil switches controller to holiday mode until given return time.
+// Empty value (or `off`) cancels holiday.
+func (c *ThermoController) setHolidayUntil(val string) bool {
+	val = strings.TrimSpace(val)
+	if val == "" || strings.EqualFold(val, "off") {
+		return c.setMode(config.ModeComfort)
+	}
+
+	until, err := parseControlTime(val)
+	if err != nil {
+		logger.L().Warnf("Invalid holiday return time `%v`: %v", val, err)
+		return false
+	}
+
+	c.modeMu.Lock()
+	c.mode = config.ModeHoliday
+	c.holidayUntil = until
+	c.modeMu.Unlock()
+
+	logger.L().Infof("Holiday mode until %v", until.Format(time.DateTime))
+	c.persistMode()
+	c.requestUpdate()
+	return true
+}
+
+// checkHoliday ends holiday in time to preheat the house before the return.
+func (c *ThermoController) checkHoliday(now time.Time) {
+	c.modeMu.RLock()
+	mode, until := c.mode, c.holidayUntil
+	c.modeMu.RUnlock()
+
+	if mode != config.ModeHoliday || until.IsZero() {
+		return
+	}
+	if now.Before(until.Add(-c.holidayPreheat())) {
+		return
+	}
+	logger.L().Infof("Holiday is over at %v, preheating", until.Format(time.DateTime))
+	c.setMode(config.ModeComfort)
+}
+
+func (c *ThermoController) holidayPreheat() time.Duration {
+	return c.cfg.Modes[config.ModeHoliday].Preheat
+}
+
+func (c *ThermoController) currentMode() string {
+	c.modeMu.RLock()
+	defer c.modeMu.RUnlock()
+	return c.mode
+}
+
+// applyMode adjusts zone setpoint according to the operating mode, zone specific
+// mode config takes precedence over the global one. It also reports whether
+// mode sets absolute setpoint.
+func (c *ThermoController) applyMode(zone *ZoneController, sp float64) (float64, string, bool) {
+	mode := c.currentMode()
+	m, ok := zone.cfg.Modes[mode]
+	if !ok {
+		m = c.cfg.Modes[mode]
+	}
+	return m.Apply(sp), mode, m.Setpoint != nil
+}
+
+func (c *ThermoController) persistMode() {
+	c.modeMu.RLock()
+	mode, until := c.mode, c.holidayUntil
+	c.modeMu.RUnlock()
+
+	if err := c.writeValue("mode", mode); err != nil {
+		logger.L().Error(err)
+	}
+	val := ""
+	if !until.IsZero() {
+		val = until.Format(time.RFC3339)
+	}
+	if err := c.writeValue("holiday_until", val); err != nil {
+		logger.L().Error(err)
+	}
+	c.publishMode()
+}
+
+func (c *ThermoController) publishMode() {
+	c.modeMu.RLock()
+	mode, until := c.mode, c.holidayUntil
+	c.modeMu.RUnlock()
+
+	c.mqtt.SafePublish(c.cfg.MQTTConfig.ControlTopic+"/active_mode", mqttQoS, true, mode)
+	val := ""
+	if !until.IsZero() {
+		val = until.Format(time.RFC3339)
+	}
+	c.mqtt.SafePublish(c.cfg.MQTTConfig.ControlTopic+"/active_holiday_until", mqttQoS, true, val)
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"math"
+	"time"
+
+	"github.com/antst/mzotbc/internal/config"
+	"github.com/antst/mzotbc/internal/logger"
+)
+
+// maxModulation calculates max relative modulation level according to the policy
+// in boiler config. Every configured limit caps the level, the lowest one wins.
+func (c *ThermoController) maxModulation(state *thermoState, now time.Time) float64 {
+	p := c.cfg.Boiler.MaxModulation
+	if p == nil {
+		return 0
+	}
+	level := *p.Max
+
+	if n := c.zonesWithDemand(); n > 0 && len(p.ZoneLevels) > 0 {
+		level = math.Min(level, p.ZoneLevels[min(n, len(p.ZoneLevels))-1])
+	}
+
+	if len(p.Outside) > 0 && state.OT > minValidTemp {
+		level = math.Min(level, interpolateModulation(p.Outside, state.OT))
+	}
+
+	if p.Gap != nil {
+		if st := c.boilerState(); st.Updated.After(zeroTS) && state.tSet-st.BoilerWaterTemperature < p.Gap.Below {
+			level = math.Min(level, p.Gap.Level)
+		}
+	}
+
+	for _, w := range p.QuietHours {
+		if w.Contains(now) {
+			level = math.Min(level, w.Level)
+		}
+	}
+
+	level = math.Max(level, *p.Min)
+	logger.L().Debugf("Max modulation level: %.0f", level)
+	return level
+}
+
+// zonesWithDemand returns number of zones, which require heating.
+func (c *ThermoController) zonesWithDemand() int {
+	n := 0
+	for _, f := range c.zoneTRs {
+		if f >= minEnableTemp {
+			n++
+		}
+	}
+	return n
+}
+
+func interpolateModulation(points []*config.ModulationPoint, t float64) float64 {
+	if t <= points[0].Temperature {
+		return points[0].Level
+	}
+	for i := 1; i < len(points); i++ {
+		if t <= points[i].Temperature {
+			p0, p1 := points[i-1], points[i]
+			return p0.Level + (p1.Level-p0.Level)*(t-p0.Temperature)/(p1.Temperature-p0.Temperature)
+		}
+	}
+	return points[len(points)-1].Level
+}
@@ -0,0 +1,357 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/antst/mzotbc/internal/db"
+	"github.com/antst/mzotbc/internal/logger"
+)
+
+const (
+	// mpcSimStep is a step of zone temperature prediction
+	mpcSimStep = 5 * time.Minute
+	// mpcOvershootWeight is a weight of overheating relative to underheating
+	mpcOvershootWeight = 0.3
+	// mpcSweeps is a number of coordinate descent passes over the plan
+	mpcSweeps = 3
+)
+
+// mpcZone is a zone as seen by the optimiser.
+type mpcZone struct {
+	name        string
+	model       heatupModel
+	weight      float64
+	temperature float64
+	setpoint    float64
+	next        nextChange
+	hasNext     bool
+	// maxFlow is the highest flow temperature, the zone may get
+	maxFlow float64
+}
+
+func (z *mpcZone) setpointAt(t time.Time) float64 {
+	if z.hasNext && !t.Before(z.next.at) {
+		return z.next.setpoint
+	}
+	return z.setpoint
+}
+
+// mpcReport is published to `<control_topic>/mpc`.
+type mpcReport struct {
+	Active    bool                   `json:"active"`
+	Reason    string                 `json:"reason,omitempty"`
+	Plan      []float64              `json:"plan,omitempty"`
+	Cost      float64                `json:"cost,omitempty"`
+	CurveTSet float64                `json:"curve_tset"`
+	Zones     map[string]heatupModel `json:"zones,omitempty"`
+}
+
+type forecastPoint struct {
+	at          time.Time
+	temperature float64
+}
+
+// outsideForecast holds forecast of outside temperature, received over MQTT.
+type outsideForecast struct {
+	mu     sync.RWMutex
+	points []forecastPoint
+}
+
+func (f *outsideForecast) updateHandler(client mqtt.Client, message mqtt.Message) {
+	var entries []struct {
+		Time        string  `json:"time"`
+		Datetime    string  `json:"datetime"`
+		Temperature float64 `json:"temperature"`
+	}
+	if err := json.Unmarshal(message.Payload(), &entries); err != nil {
+		logger.L().Warnf("Invalid outside forecast: %v", err)
+		return
+	}
+	points := make([]forecastPoint, 0, len(entries))
+	for _, e := range entries {
+		ts := e.Time
+		if ts == "" {
+			ts = e.Datetime
+		}
+		at, err := parseControlTime(ts)
+		if err != nil {
+			logger.L().Warnf("Invalid time of outside forecast `%v`: %v", ts, err)
+			return
+		}
+		points = append(points, forecastPoint{at: at, temperature: e.Temperature})
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].at.Before(points[j].at) })
+
+	f.mu.Lock()
+	f.points = points
+	f.mu.Unlock()
+	logger.L().Debugf("Outside forecast updated, %d points", len(points))
+}
+
+// at returns forecasted outside temperature, interpolated between forecast points.
+// Out of forecast range current temperature `ot` is used.
+func (f *outsideForecast) at(t time.Time, ot float64) float64 {
+	if f == nil {
+		return ot
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	p := f.points
+	i := sort.Search(len(p), func(i int) bool { return !p[i].at.Before(t) })
+	if i == len(p) || (i == 0 && p[0].at.After(t)) {
+		return ot
+	}
+	if p[i].at.Equal(t) {
+		return p[i].temperature
+	}
+	a, b := p[i-1], p[i]
+	k := t.Sub(a.at).Hours() / b.at.Sub(a.at).Hours()
+	return a.temperature + k*(b.temperature-a.temperature)
+}
+
+// mpcLearner periodically identifies thermal models of all zones from the recorded history.
+func (c *ThermoController) mpcLearner() {
+	ticker := newTicker(c.cfg.MPC.LearnInterval)
+	defer ticker.Stop()
+	for {
+		c.learnThermalModels(clk.Now())
+		<-ticker.C
+	}
+}
+
+func (c *ThermoController) learnThermalModels(now time.Time) {
+	since := now.Add(-c.cfg.MPC.History).UTC()
+	boiler, err := c.queries.ListBoilerHistory(
+		context.Background(), db.ListBoilerHistoryParams{Since: since, Until: now.UTC().Add(time.Second)},
+	)
+	if err != nil {
+		logger.L().Errorf("Failed to read boiler history: %v", err)
+		return
+	}
+	for _, zone := range c.zones {
+		history, err := c.queries.ListZoneHistory(
+			context.Background(), db.ListZoneHistoryParams{ZoneName: zone.name, RecordedAt: since},
+		)
+		if err != nil {
+			logger.L().Errorf("Failed to read history of zone %s: %v", zone.name, err)
+			continue
+		}
+		m := fitThermalModel(history, boiler).heatupModel
+		logger.L().Infof("Zone %s thermal model: %.4f*(Tflow-T) - %.4f*(T-OT) °C/h, %d samples",
+			zone.name, m.Flow, m.Loss, m.Samples)
+
+		zone.mu.Lock()
+		zone.thermal = m
+		zone.mu.Unlock()
+	}
+}
+
+// fitThermalModel fits zone model to all of the history: zone gets heat only while
+// boiler heats, otherwise it just loses heat. Zone history has Tset, requested by
+// the zone, so commanded Tset is taken from the boiler history.
+func fitThermalModel(history []db.ZoneHistory, boiler []db.BoilerHistory) rateFit {
+	cursor := newBoilerCursor(boiler)
+	return fitRateModel(history, func(h db.ZoneHistory) (float64, bool) {
+		b := cursor.at(h.RecordedAt)
+		if b == nil || h.RecordedAt.Sub(b.RecordedAt) > heatupMaxGap {
+			return 0, false
+		}
+		tset := b.Tset
+		if !b.ChEnable {
+			tset = fallbackTSet
+		}
+		flow := tset
+		if b.FlowTemperature.Valid {
+			flow = b.FlowTemperature.Float64
+		}
+		return zoneHeatInput(tset, h.Temperature, flow), true
+	})
+}
+
+// zoneHeatInput returns flow term of the zone model.
+func zoneHeatInput(tset, room, flow float64) float64 {
+	if tset < minEnableTemp || flow <= room {
+		return 0
+	}
+	return flow - room
+}
+
+func (c *ThermoController) trained(m heatupModel) bool {
+	return m.Samples >= c.cfg.MPC.MinSamples && m.Flow > 0 && m.Loss > 0
+}
+
+// mpcSetpoint plans boiler Tset over the horizon and returns Tset of the first step.
+// It fails, when some of the zones with demand has no trained model yet.
+func (c *ThermoController) mpcSetpoint(OT, curveTSet float64, now time.Time) (float64, bool) {
+	report := mpcReport{CurveTSet: curveTSet, Zones: make(map[string]heatupModel)}
+	defer c.publishMPC(&report)
+
+	if OT <= minValidTemp {
+		report.Reason = "no outside temperature"
+		return 0, false
+	}
+	var zones []*mpcZone
+	for zone := range c.zoneTRs {
+		_, rt, ok := zone.getPair()
+		if !ok || zone.windowOpen(now) {
+			continue
+		}
+		zone.mu.RLock()
+		z := &mpcZone{
+			name:        zone.name,
+			model:       zone.thermal,
+			weight:      *zone.cfg.Weight,
+			temperature: rt,
+			setpoint:    zone.effectiveSetpoint,
+			maxFlow:     c.zoneMaxFlow(zone),
+		}
+		zone.mu.RUnlock()
+		z.next, z.hasNext = zone.nextChange(now)
+		report.Zones[z.name] = z.model
+		if !c.trained(z.model) {
+			report.Reason = "zone " + z.name + " is not trained"
+			return 0, false
+		}
+		zones = append(zones, z)
+	}
+	if len(zones) == 0 {
+		report.Reason = "no zones"
+		return 0, false
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].name < zones[j].name })
+
+	steps := max(int(math.Ceil(float64(c.cfg.MPC.Horizon)/float64(c.cfg.MPC.Step))), 1)
+	plan := make([]float64, steps)
+	for i := range plan {
+		plan[i] = curveTSet
+	}
+	// boiler flow is never above what the zones of the plan may get
+	maxFlow := 0.0
+	for _, z := range zones {
+		maxFlow = max(maxFlow, z.maxFlow)
+	}
+	for i := range plan {
+		plan[i] = min(plan[i], maxFlow)
+	}
+	candidates := []float64{fallbackTSet}
+	for t := minTSet; t <= maxFlow; t++ {
+		candidates = append(candidates, t)
+	}
+
+	cost := c.mpcCost(zones, plan, OT, now)
+	for sweep := 0; sweep < mpcSweeps; sweep++ {
+		improved := false
+		for i := range plan {
+			best := plan[i]
+			for _, t := range candidates {
+				plan[i] = t
+				if v := c.mpcCost(zones, plan, OT, now); v < cost-1e-9 {
+					cost, best, improved = v, t, true
+				}
+			}
+			plan[i] = best
+		}
+		if !improved {
+			break
+		}
+	}
+
+	report.Active, report.Plan, report.Cost = true, plan, math.Round(cost*1000)/1000
+	logger.L().Debugf("MPC plan %v, cost %.3f", plan, cost)
+	return plan[0], true
+}
+
+// zoneMaxFlow returns the highest boiler Tset for the zone: the lower of emitter max flow
+// and max flow of its circuit, plus the margin, if the circuit is mixed.
+func (c *ThermoController) zoneMaxFlow(zone *ZoneController) float64 {
+	limit := maxTSet
+	if e := zone.cfg.Emitter; e != nil {
+		limit = *e.MaxFlow
+	}
+	for _, h := range c.circuits {
+		if !slices.Contains(h.zones, zone) {
+			continue
+		}
+		limit = min(limit, *h.cfg.MaxFlow)
+		if h.valve != nil {
+			limit += *c.cfg.CircuitMargin
+		}
+	}
+	return limit
+}
+
+// mpcCost predicts zone temperatures under Tset plan and returns comfort error plus energy penalty.
+func (c *ThermoController) mpcCost(zones []*mpcZone, plan []float64, OT float64, now time.Time) float64 {
+	step := c.cfg.MPC.Step
+	dt := mpcSimStep.Hours()
+	n := int(c.cfg.MPC.Horizon / mpcSimStep)
+	cost := 0.0
+	for _, z := range zones {
+		rt := z.temperature
+		for k := 0; k < n; k++ {
+			t := now.Add(time.Duration(k) * mpcSimStep)
+			tset := plan[min(int(t.Sub(now)/step), len(plan)-1)]
+			u := zoneHeatInput(tset, rt, tset)
+			rt += dt * (z.model.Flow*u - z.model.Loss*(rt-c.forecast.at(t, OT)))
+
+			e := z.setpointAt(t.Add(mpcSimStep)) - rt
+			if e < 0 {
+				e *= math.Sqrt(mpcOvershootWeight)
+			}
+			cost += z.weight * dt * (e*e + *c.cfg.MPC.EnergyWeight*u)
+		}
+	}
+	return cost
+}
+
+func (c *ThermoController) publishMPC(report *mpcReport) {
+	payload, err := json.Marshal(report)
+	if err != nil {
+		logger.L().Error(err)
+		return
+	}
+	c.mqtt.SafePublish(c.cfg.MQTTConfig.ControlTopic+"/mpc", mqttQoS, false, payload)
+}
+
+// boilerSetpoint aggregates zone Tsets to the boiler Tset, MPC takes over, once it is trained.
+// Heat pump has its own aggregation.
+func (c *ThermoController) boilerSetpoint(OT float64) (float64, bool) {
+	tSet, chEnable := c.averageSetpoints()
+	if c.heatPump != nil {
+		tSet, chEnable = c.heatPumpSetpoint()
+	}
+	if c.cfg.MPC == nil {
+		return tSet, chEnable
+	}
+	if t, ok := c.mpcSetpoint(OT, tSet, clk.Now()); ok {
+		tSet = boundTset(t)
+		return tSet, tSet >= minEnableTemp
+	}
+	return tSet, chEnable
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package opentherm encodes and decodes 32-bit OpenTherm frames.
+//
+// Frame layout (OpenTherm protocol 2.2):
+//
+//	bit  31     parity (even)
+//	bits 28..30 message type
+//	bits 24..27 spare
+//	bits 16..23 data id
+//	bits  0..15 data value
+package opentherm
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+type MsgType uint8
+
+const (
+	ReadData      MsgType = 0
+	WriteData     MsgType = 1
+	InvalidData   MsgType = 2
+	Reserved      MsgType = 3
+	ReadAck       MsgType = 4
+	WriteAck      MsgType = 5
+	DataInvalid   MsgType = 6
+	UnknownDataID MsgType = 7
+)
+
+const (
+	parityBit         = 1 << 31
+	msgTypeShift      = 28
+	dataIDShift       = 16
+	msgTypeMask       = 0x7
+	dataIDMask        = 0xff
+	dataValueMask     = 0xffff
+	frameHexDigits    = 8
+	masterToSlaveMask = 0x4
+	f88Scale          = 256.0
+	maxF88            = 127.99609375
+	minF88            = -128.0
+)
+
+var msgTypeNames = [...]string{
+	"Read-Data", "Write-Data", "Invalid-Data", "Reserved",
+	"Read-Ack", "Write-Ack", "Data-Invalid", "Unknown-DataId",
+}
+
+func (t MsgType) String() string {
+	if int(t) < len(msgTypeNames) {
+		return msgTypeNames[t]
+	}
+	return "MsgType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// FromMaster reports whether message type is sent by master (thermostat).
+func (t MsgType) FromMaster() bool {
+	return t&masterToSlaveMask == 0
+}
+
+// Frame is a raw 32-bit OpenTherm frame.
+type Frame uint32
+
+// NewFrame builds frame with correct parity bit.
+func NewFrame(t MsgType, id DataID, value uint16) Frame {
+	f := Frame(uint32(t&msgTypeMask)<<msgTypeShift | uint32(id)<<dataIDShift | uint32(value))
+	if bits.OnesCount32(uint32(f))%2 != 0 {
+		f |= parityBit
+	}
+	return f
+}
+
+// NewF88Frame builds frame carrying f8.8 value.
+func NewF88Frame(t MsgType, id DataID, value float64) Frame {
+	return NewFrame(t, id, EncodeF88(value))
+}
+
+// NewFlag8Frame builds frame carrying two flag8/u8 bytes.
+func NewFlag8Frame(t MsgType, id DataID, hb, lb uint8) Frame {
+	return NewFrame(t, id, uint16(hb)<<8|uint16(lb))
+}
+
+func (f Frame) Type() MsgType  { return MsgType(uint32(f) >> msgTypeShift & msgTypeMask) }
+func (f Frame) ID() DataID     { return DataID(uint32(f) >> dataIDShift & dataIDMask) }
+func (f Frame) Value() uint16  { return uint16(uint32(f) & dataValueMask) }
+func (f Frame) HB() uint8      { return uint8(f.Value() >> 8) }
+func (f Frame) LB() uint8      { return uint8(f.Value()) }
+func (f Frame) U16() uint16    { return f.Value() }
+func (f Frame) S16() int16     { return int16(f.Value()) }
+func (f Frame) F88() float64   { return DecodeF88(f.Value()) }
+func (f Frame) ParityOK() bool { return bits.OnesCount32(uint32(f))%2 == 0 }
+
+// Hex returns frame as 8 uppercase hex digits, as gateways log it.
+func (f Frame) Hex() string {
+	return fmt.Sprintf("%08X", uint32(f))
+}
+
+func (f Frame) String() string {
+	return fmt.Sprintf("%s %s(%d) %s", f.Type(), f.ID(), uint8(f.ID()), f.ValueString())
+}
+
+// ValueString formats frame value according to the data type of its id.
+func (f Frame) ValueString() string {
+	switch f.ID().Type() {
+	case F88:
+		return strconv.FormatFloat(f.F88(), 'f', 2, 64)
+	case U16:
+		return strconv.Itoa(int(f.U16()))
+	case S16:
+		return strconv.Itoa(int(f.S16()))
+	case Flag8:
+		return fmt.Sprintf("%08b/%08b", f.HB(), f.LB())
+	default:
+		return fmt.Sprintf("%d/%d", f.HB(), f.LB())
+	}
+}
+
+// ParseFrame parses frame from 8 hex digits, optionally prefixed with `0x`.
+func ParseFrame(s string) (Frame, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(s), "0x"), "0X")
+	if len(s) != frameHexDigits {
+		return 0, fmt.Errorf("opentherm: frame must have %d hex digits: %q", frameHexDigits, s)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("opentherm: %w", err)
+	}
+	return Frame(v), nil
+}
+
+// DecodeF88 decodes signed fixed point 8.8 value.
+func DecodeF88(v uint16) float64 {
+	return float64(int16(v)) / f88Scale
+}
+
+// EncodeF88 encodes value as signed fixed point 8.8, clamping it to the
+// representable range and rounding to the nearest 1/256.
+func EncodeF88(v float64) uint16 {
+	if v > maxF88 {
+		v = maxF88
+	}
+	if v < minF88 {
+		v = minF88
+	}
+	scaled := v * f88Scale
+	if scaled >= 0 {
+		scaled += 0.5
+	} else {
+		scaled -= 0.5
+	}
+	return uint16(int16(scaled))
+}
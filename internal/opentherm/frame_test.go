@@ -0,0 +1,176 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package opentherm
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParity(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame Frame
+		ok    bool
+	}{
+		{"zero", 0x00000000, true},
+		{"even ones", 0x40190A00, true},
+		{"odd ones", 0x40190A01, false},
+		{"parity bit set", 0x80000001, true},
+		{"parity bit wrong", 0x80000000, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.frame.ParityOK(); got != tt.ok {
+				t.Errorf("ParityOK(%s) = %v, want %v", tt.frame.Hex(), got, tt.ok)
+			}
+		})
+	}
+
+	for v := range uint16(64) {
+		if f := NewFrame(WriteData, IDControlSetpoint, v); !f.ParityOK() {
+			t.Errorf("NewFrame(%d) has wrong parity: %s", v, f.Hex())
+		}
+	}
+}
+
+func TestF88(t *testing.T) {
+	tests := []struct {
+		name  string
+		value float64
+		raw   uint16
+		back  float64
+	}{
+		{"zero", 0, 0x0000, 0},
+		{"integer", 10, 0x0A00, 10},
+		{"fraction", 45.5, 0x2D80, 45.5},
+		{"smallest step", 1.0 / 256, 0x0001, 1.0 / 256},
+		{"rounding", 20.001, 0x1400, 20},
+		{"negative", -1, 0xFF00, -1},
+		{"negative fraction", -5.25, 0xFAC0, -5.25},
+		{"negative rounding", -0.001, 0x0000, 0},
+		{"max", maxF88, 0x7FFF, maxF88},
+		{"above max", 200, 0x7FFF, maxF88},
+		{"min", -128, 0x8000, -128},
+		{"below min", -300, 0x8000, -128},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := EncodeF88(tt.value)
+			if raw != tt.raw {
+				t.Errorf("EncodeF88(%v) = %#04x, want %#04x", tt.value, raw, tt.raw)
+			}
+			if back := DecodeF88(raw); math.Abs(back-tt.back) > 1e-9 {
+				t.Errorf("DecodeF88(%#04x) = %v, want %v", raw, back, tt.back)
+			}
+		})
+	}
+}
+
+func TestFrameValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame Frame
+		str   string
+		check func(f Frame) bool
+	}{
+		{
+			"f8.8", NewF88Frame(ReadAck, IDBoilerWaterTemp, 44.25), "44.25",
+			func(f Frame) bool { return f.F88() == 44.25 },
+		},
+		{
+			"negative f8.8", NewF88Frame(ReadAck, IDOutsideTemperature, -7.5), "-7.50",
+			func(f Frame) bool { return f.F88() == -7.5 },
+		},
+		{
+			"u16", NewFrame(ReadAck, IDBurnerStarts, 54321), "54321",
+			func(f Frame) bool { return f.U16() == 54321 },
+		},
+		{
+			"s16", NewFrame(ReadAck, IDExhaustTemperature, 0xFFF6), "-10",
+			func(f Frame) bool { return f.S16() == -10 },
+		},
+		{
+			"flag8", NewFlag8Frame(ReadAck, IDStatus, MasterCHEnable|MasterDHWEnable, SlaveCHMode|SlaveFlame),
+			"00000011/00001010",
+			func(f Frame) bool { return f.HB() == 0b11 && f.LB() == 0b1010 },
+		},
+		{
+			"u8 pair", NewFlag8Frame(ReadAck, IDMaxCHBounds, 80, 20), "80/20",
+			func(f Frame) bool { return f.HB() == 80 && f.LB() == 20 },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.check(tt.frame) {
+				t.Errorf("unexpected value of %s: %#04x", tt.frame.Hex(), tt.frame.Value())
+			}
+			if got := tt.frame.ValueString(); got != tt.str {
+				t.Errorf("ValueString() = %q, want %q", got, tt.str)
+			}
+		})
+	}
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	for typ := ReadData; typ <= UnknownDataID; typ++ {
+		t.Run(typ.String(), func(t *testing.T) {
+			f := NewF88Frame(typ, IDControlSetpoint, 55.5)
+			parsed, err := ParseFrame(f.Hex())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed != f || !parsed.ParityOK() {
+				t.Fatalf("ParseFrame(%s) = %s", f.Hex(), parsed.Hex())
+			}
+			if parsed.Type() != typ || parsed.ID() != IDControlSetpoint || parsed.F88() != 55.5 {
+				t.Errorf("decoded %v", parsed)
+			}
+			if got, want := typ.FromMaster(), typ < ReadAck; got != want {
+				t.Errorf("FromMaster() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseFrame(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Frame
+		err  bool
+	}{
+		{"40190A00", 0x40190A00, false},
+		{"0x40190a00", 0x40190A00, false},
+		{" 0X40190A00 ", 0x40190A00, false},
+		{"40190A0", 0, true},
+		{"40190A000", 0, true},
+		{"4019ZA00", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			f, err := ParseFrame(tt.in)
+			if (err != nil) != tt.err {
+				t.Fatalf("error = %v, want error %v", err, tt.err)
+			}
+			if f != tt.want {
+				t.Errorf("ParseFrame(%q) = %s, want %s", tt.in, f.Hex(), tt.want.Hex())
+			}
+		})
+	}
+}
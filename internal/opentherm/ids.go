@@ -0,0 +1,133 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package opentherm
+
+import "strconv"
+
+type DataID uint8
+
+// Data ids, used by the controller
+const (
+	IDStatus             DataID = 0
+	IDControlSetpoint    DataID = 1
+	IDMasterConfig       DataID = 2
+	IDSlaveConfig        DataID = 3
+	IDCommand            DataID = 4
+	IDFaultFlags         DataID = 5
+	IDRemoteParams       DataID = 6
+	IDMaxRelModulation   DataID = 14
+	IDMaxCapacity        DataID = 15
+	IDRoomSetpoint       DataID = 16
+	IDRelModulation      DataID = 17
+	IDCHPressure         DataID = 18
+	IDDHWFlowRate        DataID = 19
+	IDRoomTemperature    DataID = 24
+	IDBoilerWaterTemp    DataID = 25
+	IDDHWTemperature     DataID = 26
+	IDOutsideTemperature DataID = 27
+	IDReturnWaterTemp    DataID = 28
+	IDExhaustTemperature DataID = 33
+	IDDHWBounds          DataID = 48
+	IDMaxCHBounds        DataID = 49
+	IDDHWSetpoint        DataID = 56
+	IDMaxCHSetpoint      DataID = 57
+	IDOEMDiagnosticCode  DataID = 115
+	IDBurnerStarts       DataID = 116
+	IDCHPumpStarts       DataID = 117
+	IDDHWPumpStarts      DataID = 118
+	IDDHWBurnerStarts    DataID = 119
+	IDBurnerHours        DataID = 120
+	IDCHPumpHours        DataID = 121
+	IDDHWPumpHours       DataID = 122
+	IDDHWBurnerHours     DataID = 123
+)
+
+// DataType is a type of the data value carried by a data id.
+type DataType uint8
+
+const (
+	// U8 is a pair of unsigned bytes
+	U8 DataType = iota
+	// Flag8 is a pair of bytes with bit flags
+	Flag8
+	// F88 is a signed fixed point value, 8 bits fraction
+	F88
+	// U16 is unsigned 16-bit integer
+	U16
+	// S16 is signed 16-bit integer
+	S16
+)
+
+type dataIDInfo struct {
+	name string
+	typ  DataType
+}
+
+var dataIDs = map[DataID]dataIDInfo{
+	IDStatus:             {"Status", Flag8},
+	IDControlSetpoint:    {"TSet", F88},
+	IDMasterConfig:       {"MConfigMMemberIDcode", Flag8},
+	IDSlaveConfig:        {"SConfigSMemberIDcode", Flag8},
+	IDCommand:            {"Command", U8},
+	IDFaultFlags:         {"ASFflags", Flag8},
+	IDRemoteParams:       {"RBPflags", Flag8},
+	IDMaxRelModulation:   {"MaxRelModLevelSetting", F88},
+	IDMaxCapacity:        {"MaxCapacityMinModLevel", U8},
+	IDRoomSetpoint:       {"TrSet", F88},
+	IDRelModulation:      {"RelModLevel", F88},
+	IDCHPressure:         {"CHPressure", F88},
+	IDDHWFlowRate:        {"DHWFlowRate", F88},
+	IDRoomTemperature:    {"Tr", F88},
+	IDBoilerWaterTemp:    {"Tboiler", F88},
+	IDDHWTemperature:     {"Tdhw", F88},
+	IDOutsideTemperature: {"Toutside", F88},
+	IDReturnWaterTemp:    {"Tret", F88},
+	IDExhaustTemperature: {"Texhaust", S16},
+	IDDHWBounds:          {"TdhwSetUBTdhwSetLB", U8},
+	IDMaxCHBounds:        {"MaxTSetUBMaxTSetLB", U8},
+	IDDHWSetpoint:        {"TdhwSet", F88},
+	IDMaxCHSetpoint:      {"MaxTSet", F88},
+	IDOEMDiagnosticCode:  {"OEMDiagnosticCode", U16},
+	IDBurnerStarts:       {"BurnerStarts", U16},
+	IDCHPumpStarts:       {"CHPumpStarts", U16},
+	IDDHWPumpStarts:      {"DHWPumpValveStarts", U16},
+	IDDHWBurnerStarts:    {"DHWBurnerStarts", U16},
+	IDBurnerHours:        {"BurnerOperationHours", U16},
+	IDCHPumpHours:        {"CHPumpOperationHours", U16},
+	IDDHWPumpHours:       {"DHWPumpValveOperationHours", U16},
+	IDDHWBurnerHours:     {"DHWBurnerOperationHours", U16},
+}
+
+func (id DataID) String() string {
+	if info, ok := dataIDs[id]; ok {
+		return info.name
+	}
+	return "DataID(" + strconv.Itoa(int(id)) + ")"
+}
+
+// Type returns data type of the value, unknown ids are treated as U8 pair.
+func (id DataID) Type() DataType {
+	return dataIDs[id].typ
+}
+
+// Known reports whether data id is known to the codec.
+func (id DataID) Known() bool {
+	_, ok := dataIDs[id]
+	return ok
+}
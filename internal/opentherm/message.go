@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package opentherm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Source is an originator of the message in gateway logs.
+type Source byte
+
+const (
+	SourceUnknown    Source = 0
+	SourceThermostat Source = 'T'
+	SourceBoiler     Source = 'B'
+	SourceRequest    Source = 'R' // gateway to boiler, on behalf of the thermostat
+	SourceAnswer     Source = 'A' // gateway to thermostat, on behalf of the boiler
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceThermostat:
+		return "thermostat"
+	case SourceBoiler:
+		return "boiler"
+	case SourceRequest:
+		return "gateway-request"
+	case SourceAnswer:
+		return "gateway-answer"
+	default:
+		return "unknown"
+	}
+}
+
+// Message is a frame together with its source, as found in gateway logs.
+type Message struct {
+	Source Source
+	Frame  Frame
+}
+
+func (m Message) String() string {
+	if m.Source == SourceUnknown {
+		return m.Frame.Hex()
+	}
+	return string(m.Source) + m.Frame.Hex()
+}
+
+// ParseMessage parses message log line of OTGW or ESP based gateways.
+// Line can be just a frame (`0x40190A00`, `40190A00`), frame with source
+// letter (`B40190A00`), or a log line where such token is surrounded by
+// timestamps and decoded text, e.g. `12:00:01.123456  B40190A00  Read-Ack ...`.
+func ParseMessage(line string) (Message, error) {
+	for _, tok := range strings.Fields(line) {
+		if m, ok := parseToken(tok); ok {
+			if !m.Frame.ParityOK() {
+				return m, fmt.Errorf("opentherm: parity error in %q", tok)
+			}
+			return m, nil
+		}
+	}
+	return Message{}, fmt.Errorf("opentherm: no frame in %q", line)
+}
+
+func parseToken(tok string) (Message, bool) {
+	var m Message
+	switch len(tok) {
+	case frameHexDigits + 1:
+		switch s := Source(tok[0]); s {
+		case SourceThermostat, SourceBoiler, SourceRequest, SourceAnswer:
+			m.Source = s
+			tok = tok[1:]
+		default:
+			return m, false
+		}
+	case frameHexDigits, frameHexDigits + 2:
+	default:
+		return m, false
+	}
+
+	f, err := ParseFrame(tok)
+	if err != nil {
+		return m, false
+	}
+	m.Frame = f
+	return m, true
+}
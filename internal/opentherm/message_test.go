@@ -0,0 +1,63 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package opentherm
+
+import "testing"
+
+func TestParseMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		source Source
+		frame  Frame
+		err    bool
+	}{
+		{"bare frame", "40190A00", SourceUnknown, 0x40190A00, false},
+		{"hex prefix", "0x40190A00", SourceUnknown, 0x40190A00, false},
+		{"boiler", "B40190A00", SourceBoiler, 0x40190A00, false},
+		{"thermostat", "T80190000", SourceThermostat, 0x80190000, false},
+		{"gateway request", "R10012D00", SourceRequest, 0x10012D00, false},
+		{"gateway answer", "AD0012D00", SourceAnswer, 0xD0012D00, false},
+		{"log line", "12:00:01.123456  B40190A00  Read-Ack Tboiler 10.00", SourceBoiler, 0x40190A00, false},
+		{"unknown source", "X40190A00", SourceUnknown, 0, true},
+		{"parity error", "B40190A01", SourceBoiler, 0x40190A01, true},
+		{"no frame", "Error 01", SourceUnknown, 0, true},
+		{"empty", "", SourceUnknown, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseMessage(tt.line)
+			if (err != nil) != tt.err {
+				t.Fatalf("error = %v, want error %v", err, tt.err)
+			}
+			if m.Source != tt.source || m.Frame != tt.frame {
+				t.Errorf("ParseMessage(%q) = %v %s, want %v %s", tt.line, m.Source, m.Frame.Hex(), tt.source, tt.frame.Hex())
+			}
+		})
+	}
+}
+
+func TestMessageString(t *testing.T) {
+	if got := (Message{Source: SourceBoiler, Frame: 0x40190A00}).String(); got != "B40190A00" {
+		t.Errorf("String() = %q", got)
+	}
+	if got := (Message{Frame: 0x40190A00}).String(); got != "40190A00" {
+		t.Errorf("String() = %q", got)
+	}
+}
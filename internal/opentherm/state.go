@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package opentherm
+
+import "time"
+
+// Master (thermostat) status flags, high byte of id 0
+const (
+	MasterCHEnable  = 1 << 0
+	MasterDHWEnable = 1 << 1
+	MasterCooling   = 1 << 2
+	MasterOTC       = 1 << 3
+	MasterCH2       = 1 << 4
+)
+
+// Slave (boiler) status flags, low byte of id 0
+const (
+	SlaveFault      = 1 << 0
+	SlaveCHMode     = 1 << 1
+	SlaveDHWMode    = 1 << 2
+	SlaveFlame      = 1 << 3
+	SlaveCooling    = 1 << 4
+	SlaveCH2        = 1 << 5
+	SlaveDiagnostic = 1 << 6
+)
+
+// Application specific fault flags, high byte of id 5
+const (
+	FaultServiceRequest   = 1 << 0
+	FaultLockoutReset     = 1 << 1
+	FaultLowWaterPressure = 1 << 2
+	FaultGasFlame         = 1 << 3
+	FaultAirPressure      = 1 << 4
+	FaultWaterOverTemp    = 1 << 5
+)
+
+var faultNames = []struct {
+	flag uint8
+	name string
+}{
+	{FaultServiceRequest, "service_request"},
+	{FaultLockoutReset, "lockout_reset"},
+	{FaultLowWaterPressure, "low_water_pressure"},
+	{FaultGasFlame, "gas_flame_fault"},
+	{FaultAirPressure, "air_pressure_fault"},
+	{FaultWaterOverTemp, "water_over_temperature"},
+}
+
+// BoilerState is a boiler state, derived from the stream of OpenTherm messages.
+type BoilerState struct {
+	MasterStatus           uint8     `json:"master_status"`
+	SlaveStatus            uint8     `json:"slave_status"`
+	ControlSetpoint        float64   `json:"control_setpoint"`
+	MaxRelModulation       float64   `json:"max_rel_modulation"`
+	RelModulation          float64   `json:"rel_modulation"`
+	CHPressure             float64   `json:"ch_pressure"`
+	RoomSetpoint           float64   `json:"room_setpoint"`
+	RoomTemperature        float64   `json:"room_temperature"`
+	BoilerWaterTemperature float64   `json:"boiler_water_temperature"`
+	DHWTemperature         float64   `json:"dhw_temperature"`
+	OutsideTemperature     float64   `json:"outside_temperature"`
+	ReturnWaterTemperature float64   `json:"return_water_temperature"`
+	DHWSetpoint            float64   `json:"dhw_setpoint"`
+	MaxCHSetpoint          float64   `json:"max_ch_setpoint"`
+	FaultFlags             uint8     `json:"fault_flags"`
+	OEMFaultCode           uint8     `json:"oem_fault_code"`
+	OEMDiagnosticCode      uint16    `json:"oem_diagnostic_code"`
+	BurnerStarts           uint16    `json:"burner_starts"`
+	BurnerHours            uint16    `json:"burner_hours"`
+	Updated                time.Time `json:"updated"`
+}
+
+func (s *BoilerState) CHEnabled() bool        { return s.MasterStatus&MasterCHEnable != 0 }
+func (s *BoilerState) DHWEnabled() bool       { return s.MasterStatus&MasterDHWEnable != 0 }
+func (s *BoilerState) Fault() bool            { return s.SlaveStatus&SlaveFault != 0 }
+func (s *BoilerState) CHActive() bool         { return s.SlaveStatus&SlaveCHMode != 0 }
+func (s *BoilerState) DHWActive() bool        { return s.SlaveStatus&SlaveDHWMode != 0 }
+func (s *BoilerState) Flame() bool            { return s.SlaveStatus&SlaveFlame != 0 }
+func (s *BoilerState) Diagnostic() bool       { return s.SlaveStatus&SlaveDiagnostic != 0 }
+func (s *BoilerState) LowWaterPressure() bool { return s.FaultFlags&FaultLowWaterPressure != 0 }
+
+// Faults returns names of the active application specific fault flags.
+func (s *BoilerState) Faults() []string {
+	var ret []string
+	for _, f := range faultNames {
+		if s.FaultFlags&f.flag != 0 {
+			ret = append(ret, f.name)
+		}
+	}
+	return ret
+}
+
+// Apply updates state with the message. Only messages which carry values
+// confirmed by the boiler are taken into account, i.e. Read-Ack and
+// Write-Ack frames coming from the boiler, or of unknown source. Gateway
+// answers to the thermostat are skipped: for overridden IDs they carry the
+// thermostat's value, not the one the boiler got.
+// It reports whether message was applied.
+func (s *BoilerState) Apply(m Message) bool {
+	if m.Source != SourceBoiler && m.Source != SourceUnknown {
+		return false
+	}
+	f := m.Frame
+	if t := f.Type(); t != ReadAck && t != WriteAck {
+		return false
+	}
+
+	switch f.ID() {
+	case IDStatus:
+		s.MasterStatus, s.SlaveStatus = f.HB(), f.LB()
+	case IDControlSetpoint:
+		s.ControlSetpoint = f.F88()
+	case IDFaultFlags:
+		s.FaultFlags, s.OEMFaultCode = f.HB(), f.LB()
+	case IDMaxRelModulation:
+		s.MaxRelModulation = f.F88()
+	case IDRoomSetpoint:
+		s.RoomSetpoint = f.F88()
+	case IDRelModulation:
+		s.RelModulation = f.F88()
+	case IDCHPressure:
+		s.CHPressure = f.F88()
+	case IDRoomTemperature:
+		s.RoomTemperature = f.F88()
+	case IDBoilerWaterTemp:
+		s.BoilerWaterTemperature = f.F88()
+	case IDDHWTemperature:
+		s.DHWTemperature = f.F88()
+	case IDOutsideTemperature:
+		s.OutsideTemperature = f.F88()
+	case IDReturnWaterTemp:
+		s.ReturnWaterTemperature = f.F88()
+	case IDDHWSetpoint:
+		s.DHWSetpoint = f.F88()
+	case IDMaxCHSetpoint:
+		s.MaxCHSetpoint = f.F88()
+	case IDOEMDiagnosticCode:
+		s.OEMDiagnosticCode = f.U16()
+	case IDBurnerStarts:
+		s.BurnerStarts = f.U16()
+	case IDBurnerHours:
+		s.BurnerHours = f.U16()
+	default:
+		return false
+	}
+	s.Updated = time.Now()
+	return true
+}
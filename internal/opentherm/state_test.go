@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package opentherm
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBoilerStateApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     Message
+		applied bool
+		check   func(s *BoilerState) bool
+	}{
+		{
+			"status", Message{SourceBoiler, NewFlag8Frame(ReadAck, IDStatus, MasterCHEnable, SlaveCHMode|SlaveFlame)}, true,
+			func(s *BoilerState) bool { return s.CHEnabled() && s.CHActive() && s.Flame() && !s.Fault() },
+		},
+		{
+			"control setpoint", Message{SourceBoiler, NewF88Frame(WriteAck, IDControlSetpoint, 55.5)}, true,
+			func(s *BoilerState) bool { return s.ControlSetpoint == 55.5 },
+		},
+		{
+			"unknown source", Message{SourceUnknown, NewF88Frame(WriteAck, IDControlSetpoint, 55.5)}, true,
+			func(s *BoilerState) bool { return s.ControlSetpoint == 55.5 },
+		},
+		{
+			"gateway answer", Message{SourceAnswer, NewF88Frame(WriteAck, IDControlSetpoint, 30)}, false,
+			func(s *BoilerState) bool { return s.ControlSetpoint == 0 },
+		},
+		{
+			"fault flags", Message{SourceBoiler, NewFlag8Frame(ReadAck, IDFaultFlags, FaultLowWaterPressure|FaultGasFlame, 42)},
+			true,
+			func(s *BoilerState) bool {
+				return s.LowWaterPressure() && s.OEMFaultCode == 42 &&
+					slices.Equal(s.Faults(), []string{"low_water_pressure", "gas_flame_fault"})
+			},
+		},
+		{
+			"max modulation", Message{SourceBoiler, NewF88Frame(WriteAck, IDMaxRelModulation, 70)}, true,
+			func(s *BoilerState) bool { return s.MaxRelModulation == 70 },
+		},
+		{
+			"boiler water", Message{SourceBoiler, NewF88Frame(ReadAck, IDBoilerWaterTemp, 44.25)}, true,
+			func(s *BoilerState) bool { return s.BoilerWaterTemperature == 44.25 },
+		},
+		{
+			"return water", Message{SourceBoiler, NewF88Frame(ReadAck, IDReturnWaterTemp, 35.5)}, true,
+			func(s *BoilerState) bool { return s.ReturnWaterTemperature == 35.5 },
+		},
+		{
+			"thermostat request", Message{SourceThermostat, NewF88Frame(ReadAck, IDBoilerWaterTemp, 90)}, false,
+			func(s *BoilerState) bool { return s.BoilerWaterTemperature == 0 },
+		},
+		{
+			"gateway request", Message{SourceRequest, NewF88Frame(WriteAck, IDControlSetpoint, 90)}, false,
+			func(s *BoilerState) bool { return s.ControlSetpoint == 0 },
+		},
+		{
+			"read data", Message{SourceBoiler, NewF88Frame(ReadData, IDBoilerWaterTemp, 90)}, false,
+			func(s *BoilerState) bool { return s.BoilerWaterTemperature == 0 },
+		},
+		{
+			"data invalid", Message{SourceBoiler, NewF88Frame(DataInvalid, IDReturnWaterTemp, 90)}, false,
+			func(s *BoilerState) bool { return s.ReturnWaterTemperature == 0 },
+		},
+		{
+			"unknown id", Message{SourceBoiler, NewFrame(ReadAck, 200, 1)}, false,
+			func(s *BoilerState) bool { return s.Updated.IsZero() },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s BoilerState
+			if got := s.Apply(tt.msg); got != tt.applied {
+				t.Fatalf("Apply() = %v, want %v", got, tt.applied)
+			}
+			if !tt.check(&s) {
+				t.Errorf("unexpected state %+v", s)
+			}
+			if tt.applied == s.Updated.IsZero() {
+				t.Errorf("Updated = %v", s.Updated)
+			}
+		})
+	}
+}
+
+// TestBoilerStateOverride checks, that with control setpoint overridden by the gateway
+// state has the value, which the boiler got, not the one the thermostat asked for.
+func TestBoilerStateOverride(t *testing.T) {
+	var s BoilerState
+	for _, line := range []string{
+		"T10011E00", // thermostat asks for 30
+		"R90013700", // gateway sends override 55 to the boiler
+		"B50013700", // boiler acknowledges 55
+		"AD0011E00", // gateway answers thermostat with its 30
+	} {
+		m, err := ParseMessage(line)
+		if err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		s.Apply(m)
+	}
+	if s.ControlSetpoint != 55 {
+		t.Errorf("ControlSetpoint = %v, want 55", s.ControlSetpoint)
+	}
+}
@@ -0,0 +1,274 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/antst/mzotbc/internal/db"
+	"github.com/antst/mzotbc/internal/logger"
+)
+
+const (
+	// heatupMinDemand is how far below the setpoint zone must be, so its history counts as heat-up
+	heatupMinDemand = 0.3
+	// heatupMaxGap is the longest gap between history records, used as a heat-up sample
+	heatupMaxGap = 15 * time.Minute
+	// heatupMinRate is a rate (°C/h), below which zone is considered unable to heat up
+	heatupMinRate = 0.05
+)
+
+// heatupModel predicts heat-up rate of a zone in °C/h:
+//
+//	rate = flow*(Tflow - Troom) - loss*(Troom - Toutside)
+type heatupModel struct {
+	Flow    float64 `json:"flow_coefficient"`
+	Loss    float64 `json:"loss_coefficient"`
+	Samples int     `json:"samples"`
+}
+
+func (m heatupModel) rate(room, outside, flow float64) float64 {
+	return m.Flow*(flow-room) - m.Loss*(room-outside)
+}
+
+// nextChange is a setpoint change, announced by an external schedule.
+type nextChange struct {
+	at       time.Time
+	setpoint float64
+}
+
+// optimumStartReport is published to `<control_topic>/zone/<name>/optimum_start`.
+type optimumStartReport struct {
+	heatupModel
+	Learned       bool       `json:"learned"`
+	PredictedRate *float64   `json:"predicted_rate,omitempty"`
+	NextChange    *time.Time `json:"next_change,omitempty"`
+	NextSetpoint  *float64   `json:"next_setpoint,omitempty"`
+	PreheatStart  *time.Time `json:"preheat_start,omitempty"`
+	Preheating    bool       `json:"preheating"`
+}
+
+// fitHeatupModel fits heat-up model to the zone history with least squares.
+// Only intervals, when zone was below its setpoint and heat was requested, are used.
+// If boiler didn't report flow temperature, requested Tset is used instead.
+func fitHeatupModel(history []db.ZoneHistory) heatupModel {
+	m := fitRateModel(history, func(h db.ZoneHistory) (float64, bool) {
+		if h.EffectiveSetpoint-h.Temperature < heatupMinDemand || h.Tset < minEnableTemp {
+			return 0, false
+		}
+		xf := historyFlow(h) - h.Temperature
+		return xf, xf > 0
+	})
+	if m.Loss < 0 || m.Flow <= 0 {
+		// not enough spread in outside temperature, ignore losses
+		m.Flow, m.Loss = 0, 0
+		if m.sff > 0 {
+			m.Flow = m.sfy / m.sff
+		}
+	}
+	return m.heatupModel
+}
+
+type rateFit struct {
+	heatupModel
+	sff, sfy float64
+}
+
+// fitRateModel fits `rate` of heat-up model to the history. `input` returns flow term
+// (Tflow - Troom) of a record, or false, if record is not used.
+func fitRateModel(history []db.ZoneHistory, input func(db.ZoneHistory) (float64, bool)) rateFit {
+	var sff, sfl, sll, sfy, sly float64
+	n := 0
+	for i := 1; i < len(history); i++ {
+		prev, cur := history[i-1], history[i]
+		dt := cur.RecordedAt.Sub(prev.RecordedAt)
+		if dt <= 0 || dt > heatupMaxGap {
+			continue
+		}
+		xf, ok := input(prev)
+		if !ok {
+			continue
+		}
+		xl := prev.Temperature - prev.OutsideTemperature
+		y := (cur.Temperature - prev.Temperature) / dt.Hours()
+
+		sff += xf * xf
+		sfl += xf * xl
+		sll += xl * xl
+		sfy += xf * y
+		sly += xl * y
+		n++
+	}
+
+	m := rateFit{heatupModel: heatupModel{Samples: n}, sff: sff, sfy: sfy}
+	if n == 0 {
+		return m
+	}
+	if det := sff*sll - sfl*sfl; math.Abs(det) > 1e-9 {
+		m.Flow = (sfy*sll - sly*sfl) / det
+		m.Loss = (sfl*sfy - sff*sly) / det
+	}
+	return m
+}
+
+func historyFlow(h db.ZoneHistory) float64 {
+	if h.FlowTemperature.Valid {
+		return h.FlowTemperature.Float64
+	}
+	return h.Tset
+}
+
+// optimumLearner periodically re-learns heat-up rates of all zones from the recorded history.
+func (c *ThermoController) optimumLearner() {
+	ticker := newTicker(c.cfg.OptimumStart.LearnInterval)
+	defer ticker.Stop()
+	for {
+		c.learnHeatup(clk.Now())
+		<-ticker.C
+	}
+}
+
+func (c *ThermoController) learnHeatup(now time.Time) {
+	since := now.Add(-c.cfg.OptimumStart.History).UTC()
+	for _, zone := range c.zones {
+		history, err := c.queries.ListZoneHistory(
+			context.Background(), db.ListZoneHistoryParams{ZoneName: zone.name, RecordedAt: since},
+		)
+		if err != nil {
+			logger.L().Errorf("Failed to read history of zone %s: %v", zone.name, err)
+			continue
+		}
+		m := fitHeatupModel(history)
+		logger.L().Infof("Zone %s heat-up rate: %.4f*(Tflow-T) - %.4f*(T-OT) °C/h, %d samples",
+			zone.name, m.Flow, m.Loss, m.Samples)
+
+		zone.mu.Lock()
+		zone.heatup = m
+		zone.mu.Unlock()
+	}
+	c.requestUpdate()
+}
+
+// preheatSetpoint returns setpoint of the next schedule change, once it is time to start
+// heating, so the zone reaches it on time. Predicted start is published per zone.
+func (c *ThermoController) preheatSetpoint(zone *ZoneController, sp, OT float64, now time.Time) (float64, bool) {
+	cfg := c.cfg.OptimumStart
+	if cfg == nil {
+		return sp, false
+	}
+	_, rt, ok := zone.getPair()
+	next, hasNext := zone.nextChange(now)
+
+	zone.mu.RLock()
+	m := zone.heatup
+	zone.mu.RUnlock()
+
+	report := optimumStartReport{heatupModel: m, Learned: m.Samples >= cfg.MinSamples}
+	if hasNext {
+		report.NextChange, report.NextSetpoint = &next.at, &next.setpoint
+	}
+
+	var lead time.Duration
+	if ok && hasNext && next.setpoint > sp && next.setpoint > rt && report.Learned && OT > minValidTemp {
+		flow := boundTset(c.currentHeatingModel().CalculateSetpoint(c.getHeatingParameter(zone), next.setpoint, OT, rt))
+		rate := math.Round(m.rate(rt, OT, flow)*100) / 100
+		report.PredictedRate = &rate
+		lead = cfg.MaxPreheat
+		if rate >= heatupMinRate {
+			lead = min(time.Duration((next.setpoint-rt)/rate*float64(time.Hour)), cfg.MaxPreheat)
+		}
+		start := next.at.Add(-lead).Truncate(time.Minute)
+		report.PreheatStart = &start
+		report.Preheating = !now.Before(start)
+	}
+	zone.publishOptimumStart(report)
+
+	if report.Preheating {
+		return next.setpoint, true
+	}
+	return sp, false
+}
+
+// nextChange returns next setpoint change of the zone from its built-in schedule,
+// or as announced by an external one.
+func (z *ZoneController) nextChange(now time.Time) (nextChange, bool) {
+	if z.schedule != nil {
+		slot, ok := z.schedule.next(now)
+		if !ok {
+			return nextChange{}, false
+		}
+		return nextChange{at: slot.start, setpoint: slot.entry.Setpoint}, true
+	}
+
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	return z.next, z.next.at.After(now)
+}
+
+// setNextChange handles `next_setpoint` control topic of zones with external schedule:
+// JSON `{"time": "2024-01-01T07:00", "setpoint": 21}`, empty payload clears it.
+func (z *ZoneController) setNextChange(payload string) bool {
+	var next nextChange
+	if payload = strings.TrimSpace(payload); payload != "" {
+		var msg struct {
+			Time     string  `json:"time"`
+			Setpoint float64 `json:"setpoint"`
+		}
+		if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+			logger.L().Warnf("Invalid next setpoint for zone %s: %v", z.name, err)
+			return false
+		}
+		var err error
+		if next.at, err = parseControlTime(msg.Time); err != nil {
+			logger.L().Warnf("Invalid next setpoint time for zone %s: %v", z.name, err)
+			return false
+		}
+		next.setpoint = msg.Setpoint
+	}
+
+	z.mu.Lock()
+	z.next = next
+	z.mu.Unlock()
+	logger.L().Infof("Zone %s: next setpoint %.1f at %v", z.name, next.setpoint, next.at.Format(time.DateTime))
+	z.childChan <- true
+	return true
+}
+
+func (z *ZoneController) publishOptimumStart(report optimumStartReport) {
+	payload, err := json.Marshal(report)
+	if err != nil {
+		logger.L().Error(err)
+		return
+	}
+
+	z.mu.Lock()
+	if bytes.Equal(payload, z.lastOptimum) {
+		z.mu.Unlock()
+		return
+	}
+	z.lastOptimum = payload
+	z.mu.Unlock()
+
+	z.mqtt.SafePublish(z.controlTopic+"optimum_start", mqttQoS, true, payload)
+}
@@ -0,0 +1,324 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package otgw talks to the OpenTherm Gateway directly, over its
+// serial-over-TCP interface or a local serial device, using the gateway
+// command protocol (CS=, CH=, MM=, PS=1, ...).
+package otgw
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antst/mzotbc/internal/logger"
+)
+
+const (
+	reconnectInterval      = 2 * time.Second
+	defaultCommandTimeout  = 3 * time.Second
+	defaultRefreshInterval = 30 * time.Second
+	dialTimeout            = 5 * time.Second
+)
+
+// Error responses of the gateway, see OTGW firmware documentation.
+var gatewayErrors = map[string]string{
+	"NG": "no good: unknown command",
+	"SE": "syntax error",
+	"BV": "bad value",
+	"OR": "out of range",
+	"NS": "no space",
+	"NF": "not found",
+	"OE": "overrun error",
+}
+
+// Client is a connection to the OpenTherm Gateway.
+// It keeps the connection alive, periodically requests status report and
+// repeats overrides, so the gateway doesn't drop them.
+type Client struct {
+	address         string
+	device          string
+	commandTimeout  time.Duration
+	refreshInterval time.Duration
+
+	connMu sync.Mutex
+	conn   io.ReadWriteCloser
+
+	cmdMu   sync.Mutex
+	pending string
+	resp    chan string
+
+	mu        sync.RWMutex
+	status    Status
+	overrides map[string]string
+	onStatus  func(Status)
+}
+
+// NewClient connects to the gateway over TCP (`address` as host:port) or,
+// if `address` is empty, via serial `device`. Connection is established in
+// background, commands fail with "not connected" until it succeeds.
+func NewClient(address, device string, refreshInterval, commandTimeout time.Duration) *Client {
+	if refreshInterval <= 0 {
+		refreshInterval = defaultRefreshInterval
+	}
+	if commandTimeout <= 0 {
+		commandTimeout = defaultCommandTimeout
+	}
+	c := &Client{
+		address:         address,
+		device:          device,
+		refreshInterval: refreshInterval,
+		commandTimeout:  commandTimeout,
+		resp:            make(chan string, 1),
+		overrides:       make(map[string]string),
+	}
+
+	go c.connect()
+	go c.refresher()
+	return c
+}
+
+// OnStatus registers callback, called for every status report of the gateway.
+func (c *Client) OnStatus(f func(Status)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onStatus = f
+}
+
+// Status returns the latest status report.
+func (c *Client) Status() Status {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.status
+}
+
+// SetControlSetpoint overrides control setpoint (CS=).
+func (c *Client) SetControlSetpoint(tSet float64) error {
+	return c.override("CS", fmt.Sprintf("%.1f", tSet))
+}
+
+// SetCHEnable overrides central heating enable bit (CH=).
+func (c *Client) SetCHEnable(enable bool) error {
+	return c.override("CH", boolArg(enable))
+}
+
+// SetMaxModulation sets maximum relative modulation level (MM=).
+func (c *Client) SetMaxModulation(level float64) error {
+	return c.override("MM", fmt.Sprintf("%.0f", level))
+}
+
+// SetDHWSetpoint sets DHW setpoint (SW=). Boiler keeps it, so it is not refreshed.
+func (c *Client) SetDHWSetpoint(sp float64) error {
+	_, err := c.Command("SW", fmt.Sprintf("%.1f", sp))
+	return err
+}
+
+// SetDHWEnable enables or disables DHW (HW=).
+func (c *Client) SetDHWEnable(enable bool) error {
+	return c.override("HW", boolArg(enable))
+}
+
+// override sends command and remembers it, so refresher repeats it
+// before the gateway times the override out.
+func (c *Client) override(cmd, arg string) error {
+	c.mu.Lock()
+	c.overrides[cmd] = arg
+	c.mu.Unlock()
+	_, err := c.Command(cmd, arg)
+	return err
+}
+
+// Command sends `cmd=arg` to the gateway and waits for its response.
+// It returns value part of the response, e.g. "45.00" for "CS: 45.00".
+func (c *Client) Command(cmd, arg string) (string, error) {
+	c.cmdMu.Lock()
+	defer c.cmdMu.Unlock()
+
+	c.mu.Lock()
+	c.pending = cmd
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.pending = ""
+		c.mu.Unlock()
+	}()
+
+	// drop stale response, if any
+	select {
+	case <-c.resp:
+	default:
+	}
+
+	if err := c.write(cmd + "=" + arg + "\r\n"); err != nil {
+		return "", err
+	}
+
+	select {
+	case r := <-c.resp:
+		if msg, ok := gatewayErrors[r]; ok {
+			return "", fmt.Errorf("otgw: %s=%s: %s (%s)", cmd, arg, r, msg)
+		}
+		return strings.TrimSpace(r[len(cmd)+1:]), nil
+	case <-time.After(c.commandTimeout):
+		return "", fmt.Errorf("otgw: %s=%s: no response in %v", cmd, arg, c.commandTimeout)
+	}
+}
+
+func (c *Client) write(line string) error {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.conn == nil {
+		return fmt.Errorf("otgw: not connected")
+	}
+	_, err := io.WriteString(c.conn, line)
+	return err
+}
+
+func (c *Client) open() (io.ReadWriteCloser, error) {
+	if c.address != "" {
+		return net.DialTimeout("tcp", c.address, dialTimeout)
+	}
+	return openSerial(c.device)
+}
+
+func (c *Client) endpoint() string {
+	if c.address != "" {
+		return "tcp://" + c.address
+	}
+	return c.device
+}
+
+func (c *Client) connect() {
+	var conn io.ReadWriteCloser
+	for {
+		var err error
+		if conn, err = c.open(); err == nil {
+			c.connMu.Lock()
+			c.conn = conn
+			c.connMu.Unlock()
+			logger.L().Infof("Connected to OpenTherm Gateway: %v", c.endpoint())
+			break
+		}
+		logger.L().Warnf("Connection to OpenTherm Gateway failed, retrying in %v: %v", reconnectInterval, err)
+		time.Sleep(reconnectInterval)
+	}
+
+	go c.reader(conn)
+
+	// switch to summary mode with the first status report and restore overrides
+	c.requestStatus()
+	c.refresh()
+}
+
+// requestStatus asks for a status report. In summary mode the gateway reports
+// status only in response to PS=1, so it is requested on every refresh.
+func (c *Client) requestStatus() {
+	if err := c.write("PS=1\r\n"); err != nil {
+		logger.L().Warnf("Failed to request OTGW status: %v", err)
+	}
+}
+
+func (c *Client) reader(conn io.ReadWriteCloser) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		c.handleLine(strings.TrimSpace(scanner.Text()))
+	}
+	logger.L().Warnf("Connection to OpenTherm Gateway lost: %v", scanner.Err())
+
+	c.connMu.Lock()
+	conn.Close()
+	if c.conn == conn {
+		c.conn = nil
+	}
+	c.connMu.Unlock()
+	c.connect()
+}
+
+func (c *Client) handleLine(line string) {
+	if line == "" {
+		return
+	}
+
+	c.mu.RLock()
+	pending := c.pending
+	c.mu.RUnlock()
+
+	if pending != "" {
+		_, isErr := gatewayErrors[line]
+		if isErr || strings.HasPrefix(line, pending+":") {
+			select {
+			case c.resp <- line:
+			default:
+			}
+			return
+		}
+	}
+
+	if strings.Count(line, ",") >= statusFields-1 {
+		st, err := ParseStatus(line)
+		if err != nil {
+			logger.L().Debugf("otgw: %v", err)
+			return
+		}
+		c.mu.Lock()
+		c.status = st
+		f := c.onStatus
+		c.mu.Unlock()
+		if f != nil {
+			f(st)
+		}
+		return
+	}
+
+	logger.L().Debugf("otgw: %s", line)
+}
+
+func (c *Client) refresher() {
+	ticker := time.NewTicker(c.refreshInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.requestStatus()
+		c.refresh()
+	}
+}
+
+func (c *Client) refresh() {
+	c.mu.RLock()
+	overrides := make(map[string]string, len(c.overrides))
+	for k, v := range c.overrides {
+		overrides[k] = v
+	}
+	c.mu.RUnlock()
+
+	for cmd, arg := range overrides {
+		if _, err := c.Command(cmd, arg); err != nil {
+			logger.L().Warnf("Failed to refresh OTGW override: %v", err)
+		}
+	}
+}
+
+func boolArg(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
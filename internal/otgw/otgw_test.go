@@ -0,0 +1,329 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package otgw
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testStatus = "00000011/00001010,45.00,00000011/00000011,100.00,20/0,20.00,35.00,1.50,20.50,44.00," +
+	"50.00,5.00,38.00,60/40,80/20,50.00,75.00,1234,100,50,20,321,200,100,50"
+
+// fakeGateway is a local TCP server, which answers gateway commands.
+// Like the gateway in summary mode, it reports status only in response to PS=1.
+type fakeGateway struct {
+	t  *testing.T
+	ln net.Listener
+
+	mu      sync.Mutex
+	replies map[string]string
+	status  string
+	conn    net.Conn
+
+	lines chan string
+}
+
+func newFakeGateway(t *testing.T) *fakeGateway {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &fakeGateway{
+		t: t, ln: ln, replies: make(map[string]string), status: testStatus, lines: make(chan string, 100),
+	}
+	t.Cleanup(func() {
+		ln.Close()
+		g.drop()
+	})
+	go g.serve()
+	return g
+}
+
+func (g *fakeGateway) serve() {
+	for {
+		conn, err := g.ln.Accept()
+		if err != nil {
+			return
+		}
+		g.mu.Lock()
+		g.conn = conn
+		g.mu.Unlock()
+		go g.handle(conn)
+	}
+}
+
+func (g *fakeGateway) handle(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		g.lines <- line
+		cmd, arg, _ := strings.Cut(line, "=")
+		g.mu.Lock()
+		reply, ok := g.replies[cmd]
+		if !ok && line == "PS=1" {
+			reply, ok = g.status, true
+		}
+		g.mu.Unlock()
+		if !ok {
+			reply = cmd + ": " + arg
+		}
+		fmt.Fprintf(conn, "%s\r\n", reply)
+	}
+}
+
+// reply makes gateway answer `cmd` with the given line.
+func (g *fakeGateway) reply(cmd, line string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.replies[cmd] = line
+}
+
+// setStatus changes status line, reported in response to PS=1.
+func (g *fakeGateway) setStatus(line string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.status = line
+}
+
+// drop closes the current client connection.
+func (g *fakeGateway) drop() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.conn != nil {
+		g.conn.Close()
+	}
+}
+
+// expect waits for the line, received from the client.
+func (g *fakeGateway) expect(want string) {
+	g.t.Helper()
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case line := <-g.lines:
+			if line == want {
+				return
+			}
+		case <-timeout:
+			g.t.Fatalf("gateway didn't receive %q", want)
+		}
+	}
+}
+
+func connectedClient(t *testing.T, g *fakeGateway, refresh time.Duration) *Client {
+	t.Helper()
+	c := NewClient(g.ln.Addr().String(), "", refresh, 500*time.Millisecond)
+	g.expect("PS=1")
+	return c
+}
+
+func TestCommandRoundTrip(t *testing.T) {
+	g := newFakeGateway(t)
+	c := connectedClient(t, g, time.Hour)
+
+	tests := []struct {
+		name string
+		send func() error
+		want string
+	}{
+		{"control setpoint", func() error { return c.SetControlSetpoint(45) }, "CS=45.0"},
+		{"CH enable", func() error { return c.SetCHEnable(true) }, "CH=1"},
+		{"CH disable", func() error { return c.SetCHEnable(false) }, "CH=0"},
+		{"max modulation", func() error { return c.SetMaxModulation(70.4) }, "MM=70"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.send(); err != nil {
+				t.Fatal(err)
+			}
+			g.expect(tt.want)
+		})
+	}
+
+	v, err := c.Command("CS", "50.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "50.5" {
+		t.Errorf("response value = %q, want 50.5", v)
+	}
+}
+
+func TestGatewayErrors(t *testing.T) {
+	g := newFakeGateway(t)
+	c := connectedClient(t, g, time.Hour)
+
+	for code := range gatewayErrors {
+		t.Run(code, func(t *testing.T) {
+			g.reply("CS", code)
+			_, err := c.Command("CS", "45.0")
+			if err == nil || !strings.Contains(err.Error(), code) {
+				t.Errorf("error = %v, want %s", err, code)
+			}
+		})
+	}
+
+	g.reply("CS", "unrelated")
+	if _, err := c.Command("CS", "45.0"); err == nil || !strings.Contains(err.Error(), "no response") {
+		t.Errorf("error = %v, want timeout", err)
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	st, err := ParseStatus(testStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := []struct {
+		name      string
+		got, want float64
+	}{
+		{"master status", float64(st.MasterStatus), 0b11},
+		{"slave status", float64(st.SlaveStatus), 0b1010},
+		{"control setpoint", st.ControlSetpoint, 45},
+		{"max modulation", st.MaxRelModulation, 100},
+		{"room setpoint", st.RoomSetpoint, 20},
+		{"modulation", st.RelModulation, 35},
+		{"pressure", st.CHPressure, 1.5},
+		{"room temperature", st.RoomTemperature, 20.5},
+		{"boiler water", st.BoilerWaterTemperature, 44},
+		{"DHW temperature", st.DHWTemperature, 50},
+		{"outside", st.OutsideTemperature, 5},
+		{"return water", st.ReturnWaterTemperature, 38},
+		{"DHW setpoint", st.DHWSetpoint, 50},
+		{"max CH setpoint", st.MaxCHSetpoint, 75},
+		{"burner starts", float64(st.BurnerStarts), 1234},
+		{"burner hours", float64(st.BurnerHours), 321},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+
+	fields := strings.Split(testStatus, ",")
+	invalid := map[string]string{
+		"too few fields": strings.Join(fields[:10], ","),
+		"flags":          strings.Replace(testStatus, "00000011/00001010", "00000011", 1),
+		"binary flags":   strings.Replace(testStatus, "00000011/00001010", "2/0", 1),
+		"float":          strings.Replace(testStatus, "45.00", "x", 1),
+		"counter":        strings.Replace(testStatus, "1234", "-1", 1),
+	}
+	for name, line := range invalid {
+		if _, err := ParseStatus(line); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestStatusReport(t *testing.T) {
+	g := newFakeGateway(t)
+	c := connectedClient(t, g, 50*time.Millisecond)
+	got := make(chan Status, 10)
+	c.OnStatus(func(st Status) { got <- st })
+
+	// status is polled on every refresh, so later changes are reported too
+	waitStatus := func(cs, flow float64) {
+		t.Helper()
+		timeout := time.After(3 * time.Second)
+		for {
+			select {
+			case st := <-got:
+				if st.ControlSetpoint == cs && c.Status().BoilerWaterTemperature == flow {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("no status report with CS=%v, flow=%v", cs, flow)
+			}
+		}
+	}
+	waitStatus(45, 44)
+	g.setStatus(strings.Replace(strings.Replace(testStatus, "45.00", "52.00", 1), "44.00", "48.00", 1))
+	waitStatus(52, 48)
+}
+
+func TestOverrideRefresh(t *testing.T) {
+	g := newFakeGateway(t)
+	c := connectedClient(t, g, 50*time.Millisecond)
+	if err := c.SetControlSetpoint(52); err != nil {
+		t.Fatal(err)
+	}
+	g.expect("CS=52.0")
+	// repeated by refresher
+	g.expect("CS=52.0")
+
+	// DHW setpoint is kept by the boiler and isn't repeated
+	if err := c.SetDHWSetpoint(55); err != nil {
+		t.Fatal(err)
+	}
+	g.expect("SW=55.0")
+	timeout := time.After(200 * time.Millisecond)
+	for {
+		select {
+		case line := <-g.lines:
+			if line == "SW=55.0" {
+				t.Fatal("SW is refreshed")
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	g := newFakeGateway(t)
+	c := connectedClient(t, g, time.Hour)
+	if err := c.SetCHEnable(true); err != nil {
+		t.Fatal(err)
+	}
+	g.expect("CH=1")
+
+	g.drop()
+	// status reports and overrides are restored on the new connection
+	g.expect("PS=1")
+	g.expect("CH=1")
+	if err := c.SetControlSetpoint(40); err != nil {
+		t.Fatal(err)
+	}
+	g.expect("CS=40.0")
+}
+
+func TestNotConnected(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	start := time.Now()
+	c := NewClient(addr, "", time.Hour, 100*time.Millisecond)
+	if time.Since(start) > time.Second {
+		t.Error("NewClient blocks on unreachable gateway")
+	}
+	if err := c.SetControlSetpoint(45); err == nil || !strings.Contains(err.Error(), "not connected") {
+		t.Errorf("error = %v, want not connected", err)
+	}
+}
@@ -0,0 +1,29 @@
+//go:build !linux
+
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package otgw
+
+import "os"
+
+// openSerial opens serial device as is, it is expected to be configured
+// for 9600 baud, 8N1 beforehand (e.g. with stty).
+func openSerial(device string) (*os.File, error) {
+	return os.OpenFile(device, os.O_RDWR, 0)
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package otgw
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antst/mzotbc/internal/opentherm"
+)
+
+// Number of fields in PS=1 summary report:
+// status, control setpoint, remote parameter flags, max relative modulation,
+// boiler capacity/min modulation, room setpoint, relative modulation,
+// CH water pressure, room temperature, boiler water temperature, DHW temperature,
+// outside temperature, return water temperature, DHW setpoint bounds,
+// max CH setpoint bounds, DHW setpoint, max CH water setpoint,
+// burner starts, CH pump starts, DHW pump starts, DHW burner starts,
+// burner hours, CH pump hours, DHW pump hours, DHW burner hours.
+const statusFields = 25
+
+// Status is a parsed PS=1 summary report of the gateway.
+type Status struct {
+	MasterStatus           uint8     `json:"master_status"`
+	SlaveStatus            uint8     `json:"slave_status"`
+	ControlSetpoint        float64   `json:"control_setpoint"`
+	MaxRelModulation       float64   `json:"max_rel_modulation"`
+	RoomSetpoint           float64   `json:"room_setpoint"`
+	RelModulation          float64   `json:"rel_modulation"`
+	CHPressure             float64   `json:"ch_pressure"`
+	RoomTemperature        float64   `json:"room_temperature"`
+	BoilerWaterTemperature float64   `json:"boiler_water_temperature"`
+	DHWTemperature         float64   `json:"dhw_temperature"`
+	OutsideTemperature     float64   `json:"outside_temperature"`
+	ReturnWaterTemperature float64   `json:"return_water_temperature"`
+	DHWSetpoint            float64   `json:"dhw_setpoint"`
+	MaxCHSetpoint          float64   `json:"max_ch_setpoint"`
+	BurnerStarts           uint16    `json:"burner_starts"`
+	BurnerHours            uint16    `json:"burner_hours"`
+	Timestamp              time.Time `json:"timestamp"`
+}
+
+// BoilerState converts status report to the boiler state.
+func (s Status) BoilerState() opentherm.BoilerState {
+	return opentherm.BoilerState{
+		MasterStatus:           s.MasterStatus,
+		SlaveStatus:            s.SlaveStatus,
+		ControlSetpoint:        s.ControlSetpoint,
+		MaxRelModulation:       s.MaxRelModulation,
+		RelModulation:          s.RelModulation,
+		CHPressure:             s.CHPressure,
+		RoomSetpoint:           s.RoomSetpoint,
+		RoomTemperature:        s.RoomTemperature,
+		BoilerWaterTemperature: s.BoilerWaterTemperature,
+		DHWTemperature:         s.DHWTemperature,
+		OutsideTemperature:     s.OutsideTemperature,
+		ReturnWaterTemperature: s.ReturnWaterTemperature,
+		DHWSetpoint:            s.DHWSetpoint,
+		MaxCHSetpoint:          s.MaxCHSetpoint,
+		BurnerStarts:           s.BurnerStarts,
+		BurnerHours:            s.BurnerHours,
+		Updated:                s.Timestamp,
+	}
+}
+
+// ParseStatus parses PS=1 summary line, e.g.
+// `00000011/00001010,45.00,00000011/00000011,100.00,...`
+func ParseStatus(line string) (Status, error) {
+	var st Status
+	f := strings.Split(line, ",")
+	if len(f) < statusFields {
+		return st, fmt.Errorf("malformed status report, %d fields: %q", len(f), line)
+	}
+
+	var err error
+	if st.MasterStatus, st.SlaveStatus, err = parseFlags(f[0]); err != nil {
+		return st, err
+	}
+
+	floats := []struct {
+		idx int
+		dst *float64
+	}{
+		{1, &st.ControlSetpoint},
+		{3, &st.MaxRelModulation},
+		{5, &st.RoomSetpoint},
+		{6, &st.RelModulation},
+		{7, &st.CHPressure},
+		{8, &st.RoomTemperature},
+		{9, &st.BoilerWaterTemperature},
+		{10, &st.DHWTemperature},
+		{11, &st.OutsideTemperature},
+		{12, &st.ReturnWaterTemperature},
+		{15, &st.DHWSetpoint},
+		{16, &st.MaxCHSetpoint},
+	}
+	for _, v := range floats {
+		if *v.dst, err = strconv.ParseFloat(f[v.idx], 64); err != nil {
+			return st, fmt.Errorf("status field %d: %w", v.idx, err)
+		}
+	}
+
+	counters := []struct {
+		idx int
+		dst *uint16
+	}{
+		{17, &st.BurnerStarts},
+		{21, &st.BurnerHours},
+	}
+	for _, v := range counters {
+		n, err := strconv.ParseUint(f[v.idx], 10, 16)
+		if err != nil {
+			return st, fmt.Errorf("status field %d: %w", v.idx, err)
+		}
+		*v.dst = uint16(n)
+	}
+
+	st.Timestamp = time.Now()
+	return st, nil
+}
+
+func parseFlags(s string) (uint8, uint8, error) {
+	hb, lb, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("malformed status flags: %q", s)
+	}
+	h, err := strconv.ParseUint(hb, 2, 8)
+	if err != nil {
+		return 0, 0, fmt.Errorf("status flags: %w", err)
+	}
+	l, err := strconv.ParseUint(lb, 2, 8)
+	if err != nil {
+		return 0, 0, fmt.Errorf("status flags: %w", err)
+	}
+	return uint8(h), uint8(l), nil
+}
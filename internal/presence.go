@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"sync"
+	"time"
+
+	"github.com/antst/mzotbc/internal/config"
+	"github.com/antst/mzotbc/internal/logger"
+	"github.com/antst/mzotbc/internal/safe_mqtt"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// presenceTracker tracks occupancy from a group of presence inputs.
+// Until any input reports, the place is considered occupied.
+type presenceTracker struct {
+	mu       sync.RWMutex
+	name     string
+	cfg      *config.PresenceConfig
+	inputs   []*bool
+	lastSeen time.Time
+	onChange func()
+}
+
+func newPresenceTracker(
+	_name string, _cfg *config.PresenceConfig, _mqtt safe_mqtt.MqttClient, _onChange func(),
+) *presenceTracker {
+	p := &presenceTracker{
+		name:     _name,
+		cfg:      _cfg,
+		inputs:   make([]*bool, len(_cfg.Sensors)),
+		onChange: _onChange,
+	}
+	for i, s := range _cfg.Sensors {
+		_mqtt.SafeSubscribe(s.Topic, mqttQoS, p.updateHandler(i, s))
+	}
+	return p
+}
+
+func (p *presenceTracker) updateHandler(idx int, cfg *config.PresenceSensorConfig) mqtt.MessageHandler {
+	return func(client mqtt.Client, message mqtt.Message) {
+		present, err := extractBoolPlainOrJson(message, cfg.JSONEntry)
+		if err != nil {
+			logger.L().Error(err)
+			return
+		}
+
+		now := clk.Now()
+		p.mu.Lock()
+		wasPresent := p.anyPresent()
+		p.inputs[idx] = &present
+		isPresent := p.anyPresent()
+		if wasPresent && !isPresent {
+			p.lastSeen = now
+		}
+		p.mu.Unlock()
+
+		if wasPresent == isPresent {
+			return
+		}
+		logger.L().Infof("Presence `%s`: %v", p.name, isPresent)
+		p.onChange()
+		if !isPresent {
+			// re-evaluate, once grace period is over
+			afterFunc(p.cfg.Grace, p.onChange)
+		}
+	}
+}
+
+// anyPresent must be called with lock held.
+func (p *presenceTracker) anyPresent() bool {
+	known := false
+	for _, v := range p.inputs {
+		if v == nil {
+			continue
+		}
+		if *v {
+			return true
+		}
+		known = true
+	}
+	return !known
+}
+
+// occupied reports whether place is occupied, taking grace period into account.
+func (p *presenceTracker) occupied(now time.Time) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.anyPresent() || now.Sub(p.lastSeen) < p.cfg.Grace
+}
+
+// known reports whether any input has reported its state.
+func (p *presenceTracker) known() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, v := range p.inputs {
+		if v != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,378 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pborman/getopt/v2"
+
+	"github.com/antst/mzotbc/internal/config"
+	"github.com/antst/mzotbc/internal/db"
+	"github.com/antst/mzotbc/internal/logger"
+	"github.com/antst/mzotbc/internal/safe_mqtt"
+)
+
+// replayRecord is a recorded state of a zone. BoilerTSet is Tset, which controller
+// actually commanded at that moment, if known.
+type replayRecord struct {
+	At          time.Time
+	Zone        string
+	Temperature float64
+	Setpoint    float64
+	Outside     float64
+	BoilerTSet  *float64
+}
+
+type replayer struct {
+	c     *ThermoController
+	clock *manualClock
+	state *thermoState
+	zones []string
+	out   *csv.Writer
+
+	samples                    int
+	sumDiff, sumAbs, sumSq, mx float64
+}
+
+// Replay feeds recorded history through the current zone and aggregation logic
+// and compares resulting boiler Tset with the one, which was actually commanded.
+func Replay() {
+	input := getopt.StringLong("replay-input", 0, "", "CSV or JSONL file with recorded history, DB is used by default")
+	dbFile := getopt.StringLong("replay-db", 0, "", "DB file with recorded history, `db_file` by default")
+	from := getopt.StringLong("replay-from", 0, "", "start of the replayed period")
+	to := getopt.StringLong("replay-to", 0, "", "end of the replayed period")
+	output := getopt.StringLong("replay-output", 0, "replay.csv", "CSV file with replayed Tset")
+
+	cfg := config.Get()
+	since, until, err := parsePeriod(*from, *to)
+	if err != nil {
+		logger.L().Panic(err)
+	}
+
+	var records []*replayRecord
+	if *input != "" {
+		records, err = loadReplayFile(*input, since, until)
+	} else {
+		records, err = loadReplayDB(openHistoryDB(cfg, *dbFile), since, until)
+	}
+	if err != nil {
+		logger.L().Panic(err)
+	}
+	if len(records) == 0 {
+		logger.L().Warn("Nothing to replay")
+		return
+	}
+	logger.L().Infof("Replaying %d records from %v to %v", len(records),
+		records[0].At.Format(time.DateTime), records[len(records)-1].At.Format(time.DateTime))
+
+	scratch, cleanup := offlineDBFile("replay")
+	defer cleanup()
+	prepareReplayConfig(cfg, scratch)
+	f, err := os.Create(*output)
+	if err != nil {
+		logger.L().Panic(err)
+	}
+	defer f.Close()
+
+	safe_mqtt.UseBroker(safe_mqtt.NewBroker())
+	mc := &manualClock{}
+	mc.Set(records[0].At)
+	clk = mc
+
+	r := &replayer{
+		c:     newThermoController(cfg),
+		clock: mc,
+		state: &thermoState{OT: minValidTemp, tSet: defaultTSet, sentTSet: defaultTSet},
+		out:   csv.NewWriter(f),
+	}
+	r.run(records)
+	r.out.Flush()
+	if err := r.out.Error(); err != nil {
+		logger.L().Error(err)
+	}
+	r.writeSummary(os.Stdout)
+}
+
+// prepareReplayConfig disables everything, what doesn't depend on recorded inputs only:
+// recorded setpoints already include schedules, learning must not run on replayed data.
+// Return limit and heat sources of a cascade are kept, as recorded Tset is sent after them.
+func prepareReplayConfig(cfg *config.Config, dbFile string) {
+	orig := cfg.Boiler
+	prepareOfflineConfig(cfg, dbFile)
+	cfg.Boiler.ReturnLimit = orig.ReturnLimit
+	for _, src := range orig.Sources {
+		cfg.Boiler.Sources = append(cfg.Boiler.Sources, &config.HeatSourceConfig{
+			Name:         src.Name,
+			Capacity:     src.Capacity,
+			BoilerConfig: *offlineBoiler(simBoilerTopic+"/"+src.Name, &src.BoilerConfig),
+		})
+	}
+	cfg.Boiler.Sequencing = orig.Sequencing
+	cfg.DHW = nil
+	cfg.OptimumStart = nil
+	cfg.Autotune = nil
+	for _, z := range cfg.Zones {
+		z.Schedule = ""
+	}
+}
+
+func loadReplayDB(q *db.Queries, since, until time.Time) ([]*replayRecord, error) {
+	ctx := context.Background()
+	history, err := q.ListHistory(ctx, db.ListHistoryParams{Since: since.UTC(), Until: until.UTC()})
+	if err != nil {
+		return nil, fmt.Errorf("zone history: %w", err)
+	}
+	boiler, err := q.ListBoilerHistory(ctx, db.ListBoilerHistoryParams{Since: since.UTC(), Until: until.UTC()})
+	if err != nil {
+		return nil, fmt.Errorf("boiler history: %w", err)
+	}
+
+	records := make([]*replayRecord, 0, len(history))
+	cursor := newBoilerCursor(boiler)
+	for _, h := range history {
+		r := &replayRecord{
+			At:          h.RecordedAt.Local(),
+			Zone:        h.ZoneName,
+			Temperature: h.Temperature,
+			Setpoint:    h.Setpoint,
+			Outside:     h.OutsideTemperature,
+		}
+		if b := cursor.at(h.RecordedAt); b != nil {
+			r.BoilerTSet = &b.Tset
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// loadReplayFile reads CSV file with header (`time,zone,temperature,setpoint,outside[,boiler_tset]`)
+// or JSON lines with the same fields.
+func loadReplayFile(name string, since, until time.Time) ([]*replayRecord, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []*replayRecord
+	if strings.HasSuffix(name, ".jsonl") || strings.HasSuffix(name, ".json") {
+		records, err = readReplayJSONL(f)
+	} else {
+		records, err = readReplayCSV(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	filtered := records[:0]
+	for _, r := range records {
+		if !r.At.Before(since) && r.At.Before(until) {
+			filtered = append(filtered, r)
+		}
+	}
+	sort.SliceStable(filtered, func(i, j int) bool { return filtered[i].At.Before(filtered[j].At) })
+	return filtered, nil
+}
+
+func readReplayJSONL(r io.Reader) ([]*replayRecord, error) {
+	var records []*replayRecord
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var v struct {
+			Time        string   `json:"time"`
+			Zone        string   `json:"zone"`
+			Temperature float64  `json:"temperature"`
+			Setpoint    float64  `json:"setpoint"`
+			Outside     float64  `json:"outside"`
+			BoilerTSet  *float64 `json:"boiler_tset"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &v); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		at, err := parseControlTime(v.Time)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, &replayRecord{
+			At: at, Zone: v.Zone, Temperature: v.Temperature, Setpoint: v.Setpoint, Outside: v.Outside,
+			BoilerTSet: v.BoilerTSet,
+		})
+	}
+	return records, scanner.Err()
+}
+
+func readReplayCSV(r io.Reader) ([]*replayRecord, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	col := make(map[string]int)
+	for i, name := range rows[0] {
+		col[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"time", "zone", "temperature", "setpoint", "outside"} {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf("missing column `%s`", name)
+		}
+	}
+
+	records := make([]*replayRecord, 0, len(rows)-1)
+	for n, row := range rows[1:] {
+		at, err := parseControlTime(row[col["time"]])
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", n+2, err)
+		}
+		r := &replayRecord{At: at, Zone: row[col["zone"]]}
+		for name, dst := range map[string]*float64{
+			"temperature": &r.Temperature, "setpoint": &r.Setpoint, "outside": &r.Outside,
+		} {
+			if *dst, err = strconv.ParseFloat(row[col[name]], 64); err != nil {
+				return nil, fmt.Errorf("row %d, `%s`: %w", n+2, name, err)
+			}
+		}
+		if i, ok := col["boiler_tset"]; ok && row[i] != "" {
+			v, err := strconv.ParseFloat(row[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("row %d, `boiler_tset`: %w", n+2, err)
+			}
+			r.BoilerTSet = &v
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+func (r *replayer) run(records []*replayRecord) {
+	for name := range r.c.zones {
+		r.zones = append(r.zones, name)
+	}
+	sort.Strings(r.zones)
+	header := []string{"time", "outside", "actual_tset", "replay_tset", "diff", "replay_ch_enable"}
+	for _, name := range r.zones {
+		header = append(header, name+"_temperature", name+"_setpoint", name+"_tset")
+	}
+	r.write(header)
+
+	unknown := make(map[string]bool)
+	for i := 0; i < len(records); {
+		at := records[i].At
+		r.clock.Set(at)
+		ot := records[i].Outside
+		var actual *float64
+		for ; i < len(records) && records[i].At.Equal(at); i++ {
+			rec := records[i]
+			if rec.BoilerTSet != nil {
+				actual = rec.BoilerTSet
+			}
+			zone, ok := r.c.zones[rec.Zone]
+			if !ok {
+				if !unknown[rec.Zone] {
+					logger.L().Warnf("Zone `%s` of recorded history is not configured, skipped", rec.Zone)
+					unknown[rec.Zone] = true
+				}
+				continue
+			}
+			zone.feed(at, rec.Setpoint, rec.Temperature)
+		}
+		r.step(at, ot, actual)
+	}
+}
+
+// step runs zone and aggregation logic on the current inputs, followed by return limit,
+// heat pump and cascade, like recorded Tset was.
+func (r *replayer) step(at time.Time, ot float64, actual *float64) {
+	c := r.c
+	for _, zone := range c.zones {
+		if tr, ok := c.calculateSetpoint(zone, ot); ok {
+			c.zoneTRs[zone] = tr
+		}
+	}
+	r.state.OT = ot
+	r.state.tSet, r.state.chEnable = c.boilerSetpoint(ot)
+	c.update(r.state)
+	tSet, chEnable := r.state.sentTSet, r.state.sentCHEnable
+
+	row := []string{at.Format(time.DateTime), fmtFloat(ot), "", fmtFloat(tSet), "", strconv.FormatBool(chEnable)}
+	if actual != nil {
+		d := tSet - *actual
+		row[2], row[4] = fmtFloat(*actual), fmtFloat(d)
+		r.samples++
+		r.sumDiff += d
+		r.sumAbs += math.Abs(d)
+		r.sumSq += d * d
+		r.mx = max(r.mx, math.Abs(d))
+	}
+	for _, name := range r.zones {
+		zone := c.zones[name]
+		zone.mu.RLock()
+		row = append(row, fmtFloat(zone.averageTemperature), fmtFloat(zone.effectiveSetpoint), fmtFloat(c.zoneTRs[zone]))
+		zone.mu.RUnlock()
+	}
+	r.write(row)
+}
+
+func (r *replayer) write(row []string) {
+	if err := r.out.Write(row); err != nil {
+		logger.L().Error(err)
+	}
+}
+
+// writeSummary writes comparison with commanded Tset as `scope,metric,value` CSV.
+func (r *replayer) writeSummary(w io.Writer) {
+	out := csv.NewWriter(w)
+	defer out.Flush()
+	_ = out.Write([]string{"scope", "metric", "value"})
+	_ = out.Write([]string{"replay", "samples", strconv.Itoa(r.samples)})
+	if r.samples == 0 {
+		return
+	}
+	n := float64(r.samples)
+	_ = out.Write([]string{"replay", "mean_diff", fmtFloat(r.sumDiff / n)})
+	_ = out.Write([]string{"replay", "mean_abs_diff", fmtFloat(r.sumAbs / n)})
+	_ = out.Write([]string{"replay", "rmse", fmtFloat(math.Sqrt(r.sumSq / n))})
+	_ = out.Write([]string{"replay", "max_abs_diff", fmtFloat(r.mx)})
+}
+
+// feed sets zone inputs directly, bypassing MQTT.
+func (z *ZoneController) feed(at time.Time, setpoint, temperature float64) {
+	z.mu.Lock()
+	z.setpoint, z.setpointTimestamp = setpoint, at
+	z.averageTemperature, z.averageTimestamp = temperature, at
+	z.recordTemperature(at, temperature)
+	z.mu.Unlock()
+	z.updateWindow(at)
+}
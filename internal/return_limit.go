@@ -0,0 +1,181 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/antst/mzotbc/internal/config"
+	"github.com/antst/mzotbc/internal/logger"
+)
+
+// returnReport is published to `<control_topic>/condensing`.
+type returnReport struct {
+	Return               *float64 `json:"return,omitempty"`
+	Reduction            float64  `json:"reduction"`
+	HeatingHoursToday    float64  `json:"heating_hours_today"`
+	CondensingHoursToday float64  `json:"condensing_hours_today"`
+	CondensingPctToday   float64  `json:"condensing_pct_today"`
+	HeatingHours         float64  `json:"heating_hours"`
+	CondensingHours      float64  `json:"condensing_hours"`
+	CondensingPct        float64  `json:"condensing_pct"`
+}
+
+// condensingTime is time of heating with known return temperature, and part of it in condensing range.
+type condensingTime struct {
+	heating    time.Duration
+	condensing time.Duration
+}
+
+func (t condensingTime) pct() float64 {
+	if t.heating == 0 {
+		return 0
+	}
+	return math.Round(float64(t.condensing) / float64(t.heating) * 100)
+}
+
+// returnLimiter reduces Tset of a condensing boiler, while return temperature is above the limit,
+// and accounts time spent in the condensing range.
+type returnLimiter struct {
+	cfg        *config.ReturnLimitConfig
+	reduction  float64
+	adjustedAt time.Time
+	countedAt  time.Time
+	day        int
+	today      condensingTime
+	total      condensingTime
+	saved      time.Duration
+	last       []byte
+}
+
+func (c *ThermoController) initReturnLimit() {
+	r := &returnLimiter{cfg: c.cfg.Boiler.ReturnLimit}
+	r.total.heating = c.readSeconds("heating_seconds")
+	r.total.condensing = c.readSeconds("condensing_seconds")
+	r.saved = r.total.heating
+	c.returnLimit = r
+}
+
+func (c *ThermoController) readSeconds(name string) time.Duration {
+	sec, err := strconv.ParseInt(c.readValueWithDefault(name, "0"), 10, 64)
+	if err != nil {
+		logger.L().Warnf("Invalid `%s`: %v", name, err)
+		return 0
+	}
+	return time.Duration(sec) * time.Second
+}
+
+func (c *ThermoController) writeSeconds(name string, d time.Duration) {
+	if err := c.writeValue(name, strconv.FormatInt(int64(d.Seconds()), 10)); err != nil {
+		logger.L().Error(err)
+	}
+}
+
+// limitReturn reduces Tset, while boiler return temperature is above the limit and comfort
+// error of the worst zone is within tolerance.
+func (c *ThermoController) limitReturn(tSet float64, chEnable bool, now time.Time) float64 {
+	r := c.returnLimit
+	st := c.boilerState()
+	ret, fresh := st.ReturnWaterTemperature, now.Sub(st.Updated) < flowValidity && st.ReturnWaterTemperature > 0
+
+	c.countCondensing(fresh && chEnable, ret, now)
+
+	if now.Sub(r.adjustedAt) >= r.cfg.Interval {
+		reduction := r.reduction
+		switch {
+		case !chEnable:
+			reduction = 0
+		case fresh && ret > *r.cfg.Limit && c.maxDiff <= *r.cfg.Tolerance:
+			reduction = min(reduction+*r.cfg.Step, *r.cfg.MaxReduction)
+		case !fresh || ret < *r.cfg.Limit-*r.cfg.Hysteresis || c.maxDiff > *r.cfg.Tolerance:
+			reduction = max(reduction-*r.cfg.Step, 0)
+		}
+		if reduction != r.reduction {
+			logger.L().Infof("Return temperature %.1f, Tset reduction %.1f -> %.1f", ret, r.reduction, reduction)
+		}
+		r.reduction, r.adjustedAt = reduction, now
+	}
+
+	if fresh {
+		c.publishCondensing(&ret)
+	} else {
+		c.publishCondensing(nil)
+	}
+	if !chEnable || r.reduction == 0 {
+		return tSet
+	}
+	return max(tSet-r.reduction, minTSet)
+}
+
+// countCondensing accounts time of heating and of return temperature below the limit.
+func (c *ThermoController) countCondensing(heating bool, ret float64, now time.Time) {
+	r := c.returnLimit
+	if day := now.YearDay(); day != r.day {
+		r.day, r.today = day, condensingTime{}
+	}
+	if heating && !r.countedAt.IsZero() {
+		dt := min(now.Sub(r.countedAt), flowValidity)
+		r.today.heating += dt
+		r.total.heating += dt
+		if ret < *r.cfg.Limit {
+			r.today.condensing += dt
+			r.total.condensing += dt
+		}
+	}
+	r.countedAt = now
+
+	if r.total.heating-r.saved < time.Minute {
+		return
+	}
+	c.writeSeconds("heating_seconds", r.total.heating)
+	c.writeSeconds("condensing_seconds", r.total.condensing)
+	r.saved = r.total.heating
+}
+
+// publishCondensing publishes return temperature limiting and condensing time, if they have changed.
+func (c *ThermoController) publishCondensing(ret *float64) {
+	r := c.returnLimit
+	hours := func(d time.Duration) float64 { return math.Round(d.Hours()*100) / 100 }
+	report := returnReport{
+		Reduction:            r.reduction,
+		HeatingHoursToday:    hours(r.today.heating),
+		CondensingHoursToday: hours(r.today.condensing),
+		CondensingPctToday:   r.today.pct(),
+		HeatingHours:         hours(r.total.heating),
+		CondensingHours:      hours(r.total.condensing),
+		CondensingPct:        r.total.pct(),
+	}
+	if ret != nil {
+		report.Return = config.GetPTR(math.Round(*ret*10) / 10)
+	}
+	payload, err := json.Marshal(report)
+	if err != nil {
+		logger.L().Error(err)
+		return
+	}
+	if bytes.Equal(payload, r.last) {
+		return
+	}
+	r.last = payload
+	c.mqtt.SafePublish(c.cfg.MQTTConfig.ControlTopic+"/condensing", mqttQoS, true, payload)
+}
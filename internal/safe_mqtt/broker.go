@@ -0,0 +1,234 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package safe_mqtt
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/antst/mzotbc/internal/logger"
+)
+
+// brokerQueueSize is a number of messages, queued for delivery to a client.
+const brokerQueueSize = 1024
+
+var (
+	brokerMu sync.RWMutex
+	broker   *Broker
+)
+
+// UseBroker makes InitMQTTClient attach new clients to the in-process broker,
+// instead of connecting to the real one.
+func UseBroker(b *Broker) {
+	brokerMu.Lock()
+	defer brokerMu.Unlock()
+	broker = b
+}
+
+func activeBroker() *Broker {
+	brokerMu.RLock()
+	defer brokerMu.RUnlock()
+	return broker
+}
+
+// Broker is a minimal in-process MQTT broker: it routes messages by topic
+// filters (with `+` and `#` wildcards) and keeps retained messages.
+type Broker struct {
+	mu       sync.RWMutex
+	subs     []*subscription
+	retained map[string]*message
+}
+
+type subscription struct {
+	filter   string
+	callback mqtt.MessageHandler
+	client   *brokerClient
+}
+
+func NewBroker() *Broker {
+	return &Broker{retained: make(map[string]*message)}
+}
+
+// Client returns new client of the broker. Messages are delivered to every
+// client from its own goroutine, in order, the same way paho does it.
+func (b *Broker) Client(clientID string) MqttClient {
+	c := &brokerClient{id: clientID, broker: b, queue: make(chan delivery, brokerQueueSize)}
+	go c.dispatch()
+	return c
+}
+
+func (b *Broker) publish(m *message) {
+	b.mu.Lock()
+	if m.retained {
+		if len(m.payload) == 0 {
+			delete(b.retained, m.topic)
+		} else {
+			b.retained[m.topic] = m
+		}
+	}
+	subs := make([]*subscription, 0, len(b.subs))
+	for _, s := range b.subs {
+		if MatchTopic(s.filter, m.topic) {
+			subs = append(subs, s)
+		}
+	}
+	b.mu.Unlock()
+
+	// retained flag is set only on delivery of stored messages to new subscribers
+	live := *m
+	live.retained = false
+	for _, s := range subs {
+		s.client.enqueue(delivery{callback: s.callback, msg: &live})
+	}
+}
+
+func (b *Broker) subscribe(s *subscription) {
+	b.mu.Lock()
+	b.subs = append(b.subs, s)
+	var retained []*message
+	for topic, m := range b.retained {
+		if MatchTopic(s.filter, topic) {
+			retained = append(retained, m)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, m := range retained {
+		s.client.enqueue(delivery{callback: s.callback, msg: m})
+	}
+}
+
+func (b *Broker) unsubscribe(c *brokerClient, filters ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subs := b.subs[:0]
+	for _, s := range b.subs {
+		drop := false
+		for _, f := range filters {
+			if s.client == c && s.filter == f {
+				drop = true
+				break
+			}
+		}
+		if !drop {
+			subs = append(subs, s)
+		}
+	}
+	b.subs = subs
+}
+
+// MatchTopic reports whether topic matches MQTT topic filter.
+func MatchTopic(filter, topic string) bool {
+	fl := strings.Split(filter, "/")
+	tl := strings.Split(topic, "/")
+	for i, f := range fl {
+		switch {
+		case f == "#":
+			return true
+		case i >= len(tl):
+			return false
+		case f != "+" && f != tl[i]:
+			return false
+		}
+	}
+	return len(fl) == len(tl)
+}
+
+type delivery struct {
+	callback mqtt.MessageHandler
+	msg      *message
+}
+
+type brokerClient struct {
+	id     string
+	broker *Broker
+	queue  chan delivery
+}
+
+func (c *brokerClient) enqueue(d delivery) {
+	select {
+	case c.queue <- d:
+	default:
+		logger.L().Warnf("In-process MQTT: queue of client %s is full, message to `%s` dropped", c.id, d.msg.topic)
+	}
+}
+
+func (c *brokerClient) dispatch() {
+	for d := range c.queue {
+		d.callback(nil, d.msg)
+	}
+}
+
+func (c *brokerClient) SafePublish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	var data []byte
+	switch p := payload.(type) {
+	case []byte:
+		data = append([]byte(nil), p...)
+	case string:
+		data = []byte(p)
+	default:
+		data = []byte(fmt.Sprint(p))
+	}
+	c.broker.publish(&message{topic: topic, qos: qos, retained: retained, payload: data})
+	return doneToken{}
+}
+
+func (c *brokerClient) SafeSubscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	c.broker.subscribe(&subscription{filter: topic, callback: callback, client: c})
+	return doneToken{}
+}
+
+func (c *brokerClient) SafeUnsubscribe(topics ...string) mqtt.Token {
+	c.broker.unsubscribe(c, topics...)
+	return doneToken{}
+}
+
+// message implements mqtt.Message.
+type message struct {
+	topic    string
+	qos      byte
+	retained bool
+	payload  []byte
+}
+
+func (m *message) Duplicate() bool   { return false }
+func (m *message) Qos() byte         { return m.qos }
+func (m *message) Retained() bool    { return m.retained }
+func (m *message) Topic() string     { return m.topic }
+func (m *message) MessageID() uint16 { return 0 }
+func (m *message) Payload() []byte   { return m.payload }
+func (m *message) Ack()              {}
+
+// doneToken is a token of completed operation, in-process broker never fails.
+type doneToken struct{}
+
+var closedChan = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+func (doneToken) Wait() bool                       { return true }
+func (doneToken) WaitTimeout(_ time.Duration) bool { return true }
+func (doneToken) Done() <-chan struct{}            { return closedChan }
+func (doneToken) Error() error                     { return nil }
@@ -55,6 +55,10 @@ var (
 )
 
 func InitMQTTClient(url, clientID string) MqttClient {
+	if b := activeBroker(); b != nil {
+		return b.Client(clientID)
+	}
+
 	opts := mqtt.NewClientOptions().
 		AddBroker(url).
 		SetClientID(clientID).
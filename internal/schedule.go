@@ -0,0 +1,83 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"time"
+
+	"github.com/antst/mzotbc/internal/config"
+)
+
+const daysPerWeek = 7
+
+// weeklySchedule is a weekly setpoint profile.
+type weeklySchedule struct {
+	name    string
+	entries []*config.ScheduleEntry
+}
+
+// scheduleSlot is an entry of the schedule, placed at the particular moment.
+type scheduleSlot struct {
+	entry *config.ScheduleEntry
+	start time.Time
+}
+
+func newWeeklySchedule(name string, entries []*config.ScheduleEntry) *weeklySchedule {
+	return &weeklySchedule{name: name, entries: entries}
+}
+
+// active returns slot, which is active at `t`.
+func (s *weeklySchedule) active(t time.Time) (scheduleSlot, bool) {
+	var best scheduleSlot
+	for d := 0; d <= daysPerWeek; d++ {
+		day := t.AddDate(0, 0, -d)
+		for _, e := range s.entries {
+			if !e.Days.Contains(day.Weekday()) {
+				continue
+			}
+			if start := e.Time.On(day); !start.After(t) && (best.entry == nil || start.After(best.start)) {
+				best = scheduleSlot{entry: e, start: start}
+			}
+		}
+		if best.entry != nil {
+			break
+		}
+	}
+	return best, best.entry != nil
+}
+
+// next returns the first slot, which starts after `t`.
+func (s *weeklySchedule) next(t time.Time) (scheduleSlot, bool) {
+	var best scheduleSlot
+	for d := 0; d <= daysPerWeek; d++ {
+		day := t.AddDate(0, 0, d)
+		for _, e := range s.entries {
+			if !e.Days.Contains(day.Weekday()) {
+				continue
+			}
+			if start := e.Time.On(day); start.After(t) && (best.entry == nil || start.Before(best.start)) {
+				best = scheduleSlot{entry: e, start: start}
+			}
+		}
+		if best.entry != nil {
+			break
+		}
+	}
+	return best, best.entry != nil
+}
@@ -50,6 +50,8 @@ type SensorController struct {
 	value       float64
 	timestamp   time.Time
 	controlChan chan<- bool
+	// recordedAt is timestamp of the value, last stored to sensor history
+	recordedAt time.Time
 }
 
 func NewSensorController(
@@ -65,7 +67,7 @@ func NewSensorController(
 
 	if s.readState() {
 		logger.L().Debugf("Loaded previous state from DB for sensor %v: %v", s.name, s.value)
-		s.timestamp = time.Now()
+		s.timestamp = clk.Now()
 	}
 
 	s.mqtt = safe_mqtt.InitMQTTClient(_mqttCfg.URL, sensorControlPrefix+s.name+"-"+uuid.New().String())
@@ -87,6 +89,7 @@ func (s *SensorController) ValueUpdateHandler(client mqtt.Client, message mqtt.M
 	s.lock.Lock()
 	oldValue := s.value
 	s.value = t0*(*s.cfg.Scale) + (*s.cfg.Offset)
+	s.timestamp = clk.Now()
 	s.lock.Unlock()
 	if err := s.writeState(); err != nil {
 		logger.L().Error(err)
@@ -117,6 +120,10 @@ func (s *SensorController) readState() bool {
 func (s *SensorController) controlUpdateHandler(client mqtt.Client, message mqtt.Message) {
 	topic := message.Topic()[strings.LastIndex(message.Topic(), "/")+1:]
 	logger.L().Infof("Sensor %v got MQTT control request: %v : %v", s.name, topic, string(message.Payload()))
+	old, accepted := s.controlValue(topic), false
+	defer func() {
+		auditControl(s.queries, message.Topic(), old, s.controlValue(topic), string(message.Payload()), accepted)
+	}()
 
 	value, err := strconv.ParseFloat(string(message.Payload()), 64)
 	if err != nil {
@@ -137,6 +144,20 @@ func (s *SensorController) controlUpdateHandler(client mqtt.Client, message mqtt
 	}
 
 	logger.L().Infof("Updated %s for sensor `%v` to %v", topic, s.name, value)
+	accepted = true
+}
+
+// controlValue returns current value of the control topic for the audit log.
+func (s *SensorController) controlValue(topic string) string {
+	switch topic {
+	case "weight":
+		return formatControlValue(s.cfg.Weight)
+	case "offset":
+		return formatControlValue(s.cfg.Offset)
+	case "scale":
+		return formatControlValue(s.cfg.Scale)
+	}
+	return ""
 }
 
 func sensorsMean(sensors []*SensorController) (float64, time.Time) {
@@ -156,5 +177,5 @@ func sensorsMean(sensors []*SensorController) (float64, time.Time) {
 		return 0, zeroTS
 	}
 
-	return v / wt, time.Now()
+	return v / wt, clk.Now()
 }
@@ -0,0 +1,430 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pborman/getopt/v2"
+
+	"github.com/antst/mzotbc/internal/config"
+	"github.com/antst/mzotbc/internal/logger"
+	"github.com/antst/mzotbc/internal/safe_mqtt"
+)
+
+const (
+	simBoilerTopic    = "simulation/boiler"
+	radiatorExponent  = 1.3
+	radiatorNominalDT = 50.0
+	// simBelowBand is how far below setpoint zone is counted as too cold
+	simBelowBand = 0.5
+)
+
+// simZone is RC model of a zone: radiator heats thermal mass, which loses heat to outside.
+type simZone struct {
+	name        string
+	cfg         *config.SimZoneConfig
+	zone        *config.ZoneConfig
+	temperature float64
+	setpoint    float64
+	heat        float64
+
+	// comfort metrics, weighted by time in seconds
+	seconds, errSum, absErrSum, under, over, below, energy float64
+}
+
+type simulation struct {
+	cfg   *config.Config
+	sim   *config.SimulationConfig
+	mqtt  safe_mqtt.MqttClient
+	zones []*simZone
+	trace *csv.Writer
+
+	mu       sync.Mutex
+	tSet     float64
+	chEnable bool
+	flow     float64
+
+	// boiler metrics
+	seconds, chOn, tSetOnSum float64
+	starts                   int
+}
+
+// Simulate runs controller in accelerated time against a simulated building
+// (see config.SimulationConfig), instead of MQTT broker and boiler.
+func Simulate() {
+	duration := getopt.DurationLong("sim-duration", 0, 0, "simulated time span")
+	speed := getopt.IntLong("sim-speed", 0, 0, "simulation speed, times faster than real time")
+	output := getopt.StringLong("sim-output", 0, "", "CSV file with simulation traces")
+
+	cfg := config.Get()
+	if cfg.Simulation == nil {
+		cfg.Simulation = &config.SimulationConfig{}
+		cfg.Simulation.FillDefaults(cfg.Zones)
+	}
+	if *duration > 0 {
+		cfg.Simulation.Duration = *duration
+	}
+	if *speed > 0 {
+		cfg.Simulation.Speed = float64(*speed)
+	}
+	if *output != "" {
+		cfg.Simulation.Output = *output
+	}
+	start, err := simulationStart(cfg.Simulation.Start)
+	if err != nil {
+		logger.L().Panicf("Invalid simulation start `%v`: %v", cfg.Simulation.Start, err)
+	}
+	dbFile, cleanup := offlineDBFile("simulation")
+	defer cleanup()
+	prepareOfflineConfig(cfg, dbFile)
+
+	f, err := os.Create(cfg.Simulation.Output)
+	if err != nil {
+		logger.L().Panic(err)
+	}
+	defer f.Close()
+
+	safe_mqtt.UseBroker(safe_mqtt.NewBroker())
+	clk = newScaledClock(start, cfg.Simulation.Speed)
+	logger.L().Infof("Simulating %v from %v, %v times faster than real time",
+		cfg.Simulation.Duration, start.Format(time.DateTime), cfg.Simulation.Speed)
+
+	s := newSimulation(cfg, f)
+	c := newThermoController(cfg)
+	s.publishSetpoints()
+	s.publishSensors(clk.Now())
+	go c.Run()
+
+	s.run(start.Add(cfg.Simulation.Duration))
+	s.trace.Flush()
+	if err := s.trace.Error(); err != nil {
+		logger.L().Error(err)
+	}
+	s.writeMetrics(os.Stdout)
+}
+
+func simulationStart(val string) (time.Time, error) {
+	if val == "" {
+		y, m, d := time.Now().Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, time.Local), nil
+	}
+	return parseControlTime(val)
+}
+
+// offlineDBFile creates scratch DB file for offline modes, returned func removes it.
+func offlineDBFile(mode string) (string, func()) {
+	dir, err := os.MkdirTemp("", "mzotbc-"+mode)
+	if err != nil {
+		logger.L().Panic(err)
+	}
+	return filepath.Join(dir, "mzotbc.db"), func() { _ = os.RemoveAll(dir) }
+}
+
+// prepareOfflineConfig replaces boiler and DB, so controller runs without them.
+// Boiler commands are published to the in-process broker under simBoilerTopic.
+func prepareOfflineConfig(cfg *config.Config, dbFile string) {
+	cfg.Boiler = offlineBoiler(simBoilerTopic, cfg.Boiler)
+	cfg.DBFile = dbFile
+	cfg.HTTP = nil
+}
+
+// offlineBoiler returns boiler, which publishes commands under the topic, with modulation
+// and heat pump settings of the configured one.
+func offlineBoiler(topic string, cfg *config.BoilerConfig) *config.BoilerConfig {
+	boiler := &config.BoilerConfig{
+		Type: config.BoilerTypeTemplate,
+		Templates: map[string]*config.CommandTemplate{
+			config.BoilerCmdTSet:     {Topic: topic + "/tset", Payload: "{{.Value}}"},
+			config.BoilerCmdCHEnable: {Topic: topic + "/ch_enable", Payload: "{{if .On}}1{{else}}0{{end}}"},
+		},
+		MaxModulation: cfg.MaxModulation,
+		HeatPump:      cfg.HeatPump,
+	}
+	boiler.FillDefaults()
+	return boiler
+}
+
+func newSimulation(cfg *config.Config, w io.Writer) *simulation {
+	s := &simulation{
+		cfg:   cfg,
+		sim:   cfg.Simulation,
+		mqtt:  safe_mqtt.InitMQTTClient(cfg.MQTTConfig.URL, "otbs-simulation"),
+		trace: csv.NewWriter(w),
+	}
+
+	names := make([]string, 0, len(cfg.Zones))
+	for name := range cfg.Zones {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		z := &simZone{name: name, cfg: s.sim.Zones[name], zone: cfg.Zones[name]}
+		z.temperature, z.setpoint = *z.cfg.Initial, *z.cfg.Setpoint
+		s.zones = append(s.zones, z)
+	}
+	s.flow = s.meanTemperature()
+
+	s.mqtt.SafeSubscribe(simBoilerTopic+"/+", mqttQoS, s.boilerHandler)
+	s.mqtt.SafeSubscribe(cfg.MQTTConfig.ControlTopic+"/zone/+/status", mqttQoS, s.statusHandler)
+
+	header := []string{"time", "outside", "tset", "ch_enable", "flow"}
+	for _, z := range s.zones {
+		header = append(header, z.name+"_temperature", z.name+"_setpoint", z.name+"_heat")
+	}
+	s.write(header)
+	return s
+}
+
+func (s *simulation) boilerHandler(client mqtt.Client, message mqtt.Message) {
+	v, err := strconv.ParseFloat(string(message.Payload()), 64)
+	if err != nil {
+		logger.L().Error(err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch message.Topic() {
+	case simBoilerTopic + "/tset":
+		s.tSet = v
+	case simBoilerTopic + "/ch_enable":
+		if v != 0 && !s.chEnable {
+			s.starts++
+		}
+		s.chEnable = v != 0
+	}
+}
+
+// statusHandler tracks effective setpoints of zones, comfort is measured against them.
+func (s *simulation) statusHandler(client mqtt.Client, message mqtt.Message) {
+	parts := strings.Split(message.Topic(), "/")
+	name := parts[len(parts)-2]
+	var st zoneStatus
+	if err := json.Unmarshal(message.Payload(), &st); err != nil {
+		logger.L().Error(err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, z := range s.zones {
+		if z.name == name {
+			z.setpoint = st.EffectiveSetpoint
+		}
+	}
+}
+
+func (s *simulation) run(end time.Time) {
+	last := clk.Now()
+	for {
+		time.Sleep(clk.Real(s.sim.Step))
+		now := clk.Now()
+		if now.After(end) {
+			return
+		}
+		s.step(now, now.Sub(last))
+		s.publishSensors(now)
+		last = now
+	}
+}
+
+// step advances building model by `dt`.
+func (s *simulation) step(now time.Time, dt time.Duration) {
+	ot := s.outside(now)
+	sec := dt.Seconds()
+
+	s.mu.Lock()
+	target := s.meanTemperature()
+	if s.chEnable {
+		target = s.tSet
+	}
+	s.flow += (target - s.flow) * (1 - math.Exp(-sec/s.sim.FlowLag.Seconds()))
+
+	s.seconds += sec
+	if s.chEnable {
+		s.chOn += sec
+		s.tSetOnSum += s.tSet * sec
+	}
+
+	row := []string{
+		now.Format(time.DateTime), fmtFloat(ot), fmtFloat(s.tSet), strconv.FormatBool(s.chEnable), fmtFloat(s.flow),
+	}
+	for _, z := range s.zones {
+		z.heat = 0
+		if s.flow > z.temperature {
+			z.heat = *z.cfg.Radiator * math.Pow((s.flow-z.temperature)/radiatorNominalDT, radiatorExponent)
+		}
+		loss := *z.cfg.HeatLoss * (z.temperature - ot)
+		z.temperature += (z.heat - loss) * sec / (*z.cfg.ThermalMass * 1000)
+
+		e := z.setpoint - z.temperature
+		z.seconds += sec
+		z.errSum += e * sec
+		z.absErrSum += math.Abs(e) * sec
+		z.under += max(e, 0) * sec
+		z.over += max(-e, 0) * sec
+		if e > simBelowBand {
+			z.below += sec
+		}
+		z.energy += z.heat * sec
+
+		row = append(row, fmtFloat(z.temperature), fmtFloat(z.setpoint), fmtFloat(z.heat))
+	}
+	s.mu.Unlock()
+
+	s.write(row)
+}
+
+// outside returns outside temperature of the daily profile.
+func (s *simulation) outside(t time.Time) float64 {
+	o := s.sim.Outside
+	minutes := float64(config.ClockOf(t)) + float64(t.Second())/60
+	const day = 24 * 60
+	if len(o.Points) == 0 {
+		phase := 2 * math.Pi * (minutes - float64(*o.Coldest)) / day
+		return *o.Mean - *o.Amplitude*math.Cos(phase)
+	}
+
+	points := make([]*config.SimOutsidePoint, len(o.Points))
+	copy(points, o.Points)
+	sort.Slice(points, func(i, j int) bool { return points[i].Time < points[j].Time })
+	// interpolate cyclically between the previous and the next point
+	prev, next := points[len(points)-1], points[0]
+	for i, p := range points {
+		if float64(p.Time) > minutes {
+			next = p
+			if i > 0 {
+				prev = points[i-1]
+			}
+			break
+		}
+		prev, next = p, points[(i+1)%len(points)]
+	}
+	span := math.Mod(float64(next.Time-prev.Time)+day, day)
+	if span == 0 {
+		return prev.Temperature
+	}
+	pos := math.Mod(minutes-float64(prev.Time)+day, day)
+	return prev.Temperature + (next.Temperature-prev.Temperature)*pos/span
+}
+
+// meanTemperature must be called with lock held, or before simulation runs.
+func (s *simulation) meanTemperature() float64 {
+	if len(s.zones) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, z := range s.zones {
+		sum += z.temperature
+	}
+	return sum / float64(len(s.zones))
+}
+
+// publishSetpoints publishes initial setpoints of zones without schedule.
+func (s *simulation) publishSetpoints() {
+	for _, z := range s.zones {
+		c := z.zone.Setpoint
+		if c.Topic == "" || z.zone.Schedule != "" {
+			continue
+		}
+		s.mqtt.SafePublish(c.Topic, mqttQoS, false, simPayload(c.JSONEntry, (*z.cfg.Setpoint-*c.Offset) / *c.Scale))
+	}
+}
+
+// publishSensors reports simulated temperatures to all zone and outside sensors.
+func (s *simulation) publishSensors(now time.Time) {
+	ot := s.outside(now)
+	for _, c := range s.cfg.Outside.TemperatureSensors {
+		s.mqtt.SafePublish(c.Topic, mqttQoS, false, simPayload(c.JSONEntry, (ot-*c.Offset) / *c.Scale))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, z := range s.zones {
+		for _, c := range z.zone.Sensors {
+			s.mqtt.SafePublish(c.Topic, mqttQoS, false, simPayload(c.JSONEntry, (z.temperature-*c.Offset) / *c.Scale))
+		}
+	}
+}
+
+func (s *simulation) write(row []string) {
+	if err := s.trace.Write(row); err != nil {
+		logger.L().Error(err)
+	}
+}
+
+// writeMetrics writes comfort and boiler metrics as `scope,metric,value` CSV.
+func (s *simulation) writeMetrics(w io.Writer) {
+	out := csv.NewWriter(w)
+	defer out.Flush()
+	row := func(scope, metric string, v float64) {
+		if err := out.Write([]string{scope, metric, fmtFloat(v)}); err != nil {
+			logger.L().Error(err)
+		}
+	}
+
+	_ = out.Write([]string{"scope", "metric", "value"})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, z := range s.zones {
+		if z.seconds == 0 {
+			continue
+		}
+		row(z.name, "mean_error", z.errSum/z.seconds)
+		row(z.name, "mean_abs_error", z.absErrSum/z.seconds)
+		row(z.name, "underheat_degree_hours", z.under/3600)
+		row(z.name, "overheat_degree_hours", z.over/3600)
+		row(z.name, "time_below_pct", 100*z.below/z.seconds)
+		row(z.name, "heat_kwh", z.energy/3.6e6)
+	}
+	if s.seconds > 0 {
+		row("boiler", "ch_on_pct", 100*s.chOn/s.seconds)
+		row("boiler", "ch_starts", float64(s.starts))
+		if s.chOn > 0 {
+			row("boiler", "mean_tset_on", s.tSetOnSum/s.chOn)
+		}
+	}
+}
+
+func simPayload(jsonEntry *string, v float64) string {
+	if jsonEntry == nil {
+		return fmtFloat(v)
+	}
+	payload, err := json.Marshal(map[string]float64{*jsonEntry: math.Round(v*100) / 100})
+	if err != nil {
+		logger.L().Error(err)
+	}
+	return string(payload)
+}
+
+func fmtFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
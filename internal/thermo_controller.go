@@ -24,6 +24,7 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/antst/mzotbc/internal/config"
@@ -49,30 +50,54 @@ const (
 )
 
 type ThermoController struct {
-	cfg         *config.Config
-	queries     *db.Queries
-	mqtt        safe_mqtt.MqttClient
-	zones       map[string]*ZoneController
-	outside     *OutsideController
-	boiler      *BoilerController
-	outsideChan chan float64
-	zoneChan    chan *ZoneController
-	updateMap   map[*ZoneController]bool
-	zoneTRs     map[*ZoneController]float64
-	enabled     bool
-	forceChan   chan bool
+	cfg          *config.Config
+	queries      *db.Queries
+	mqtt         safe_mqtt.MqttClient
+	zones        map[string]*ZoneController
+	outside      *OutsideController
+	boiler       *BoilerController
+	dhw          *DHWController
+	outsideChan  chan float64
+	zoneChan     chan *ZoneController
+	updateMap    map[*ZoneController]bool
+	zoneTRs      map[*ZoneController]float64
+	enabled      bool
+	forceChan    chan bool
+	modeMu       sync.RWMutex
+	mode         string
+	holidayUntil time.Time
+	presence     *presenceTracker
+	modelMu      sync.RWMutex
+	heatingModel *thermo_model.Model
+	fittedModel  *thermo_model.Model
+	builtinModel *thermo_model.Model
+	heatPump     *heatPump
+	forecast     *outsideForecast
+	circuits     []*heatingCircuit
+	cascade      *cascade
+	returnLimit  *returnLimiter
+	maxDiff      float64
+	alarms       *alarmManager
 }
 
 type thermoState struct {
-	OT          float64
-	tSet        float64
-	chEnable    bool
-	forceUpdate bool
+	OT            float64
+	tSet          float64
+	chEnable      bool
+	maxModulation float64
+	forceUpdate   bool
+	// sentTSet and sentCHEnable are the last values, sent to the boiler
+	sentTSet     float64
+	sentCHEnable bool
 }
 
 func NewThermoController() *ThermoController {
+	return newThermoController(config.Get())
+}
+
+func newThermoController(cfg *config.Config) *ThermoController {
 	c := &ThermoController{
-		cfg:         config.Get(),
+		cfg:         cfg,
 		forceChan:   make(chan bool, 2),
 		outsideChan: make(chan float64, 3),
 		zoneChan:    make(chan *ZoneController, 100),
@@ -81,13 +106,55 @@ func NewThermoController() *ThermoController {
 		updateMap:   make(map[*ZoneController]bool),
 	}
 
+	// control handlers record changes to DB
+	c.queries = db.OpenDatabase(c.cfg.DBFile)
 	c.mqtt = safe_mqtt.InitMQTTClient(c.cfg.MQTTConfig.URL, "otbs-"+uuid.New().String())
 	c.setupMQTTSubscriptions()
-	c.queries = db.OpenDatabase(c.cfg.DBFile)
+	if c.cfg.Presence != nil {
+		c.presence = newPresenceTracker("house", c.cfg.Presence, c.mqtt, c.requestUpdate)
+	}
+	c.alarms = newAlarmManager(c.cfg.MQTTConfig, c.queries)
 	c.outside = NewOutsideController(c.cfg.Outside, c.cfg.MQTTConfig, c.queries, c.outsideChan)
-	c.boiler = NewBoilerController(c.cfg.Boiler, c.cfg.MQTTConfig, c.queries)
+	if len(c.cfg.Boiler.Sources) > 0 {
+		if c.cfg.Boiler.HeatPump != nil {
+			logger.L().Panic("Boiler with heat sources can't be a heat pump, configure `heat_pump` in the source")
+		}
+		c.cascade = newCascade(c.cfg.Boiler, c.cfg.MQTTConfig, c.queries, c.alarms, c.requestUpdate)
+		// DHW is on the first source
+		c.boiler = c.cascade.sources[0].boiler
+	} else {
+		c.boiler = NewBoilerController(c.cfg.Boiler, c.cfg.MQTTConfig, c.queries, c.alarms)
+	}
+	if c.cfg.Boiler.HeatPump != nil {
+		c.heatPump = newHeatPump(c.cfg.Boiler.HeatPump, c.cfg.MQTTConfig, c.boiler, c.requestUpdate)
+	}
+	if c.cfg.DHW != nil {
+		c.dhw = NewDHWController(c.cfg.DHW, c.cfg.MQTTConfig, c.queries, c.boiler, c.requestUpdate)
+	}
 	c.initializeZones()
+	c.initializeCircuits()
+	c.restoreMode()
+	c.initHeatingModel()
+	if c.cfg.Boiler.ReturnLimit != nil {
+		c.initReturnLimit()
+	}
 	c.setEnabled(c.readValueWithDefault("enabled", "true"))
+	if c.cfg.OptimumStart != nil {
+		go c.optimumLearner()
+	}
+	if (c.cfg.OptimumStart != nil || c.cfg.Autotune != nil || c.cfg.MPC != nil) && c.cfg.HistoryInterval < 0 {
+		logger.L().Warn("Optimum start, autotune and MPC need zone history, but history recording is disabled")
+	}
+	if c.cfg.Autotune != nil {
+		c.restoreHeatingParameters()
+		go c.autotuner()
+	}
+	if c.cfg.MPC != nil {
+		go c.mpcLearner()
+	}
+	if c.cfg.HTTP != nil {
+		startHTTPAPI(c.cfg.HTTP, c.queries)
+	}
 	return c
 }
 
@@ -96,11 +163,26 @@ func (c *ThermoController) setupMQTTSubscriptions() {
 	c.mqtt.SafeSubscribe(controlTopic+"/default_heating_parameter", 1, c.controlUpdateHandler)
 	c.mqtt.SafeSubscribe(controlTopic+"/log_level", 1, c.controlUpdateHandler)
 	c.mqtt.SafeSubscribe(controlTopic+"/enable", 1, c.controlUpdateHandler)
+	c.mqtt.SafeSubscribe(controlTopic+"/mode", 1, c.controlUpdateHandler)
+	c.mqtt.SafeSubscribe(controlTopic+"/holiday_until", 1, c.controlUpdateHandler)
+	c.mqtt.SafeSubscribe(controlTopic+"/heating_model", 1, c.controlUpdateHandler)
+	if c.cfg.MPC != nil && c.cfg.MPC.ForecastTopic != "" {
+		c.forecast = &outsideForecast{}
+		c.mqtt.SafeSubscribe(c.cfg.MPC.ForecastTopic, 1, c.forecast.updateHandler)
+	}
 }
 
 func (c *ThermoController) initializeZones() {
 	for s, cfg := range c.cfg.Zones {
-		zone := newZoneController(s, cfg, c.cfg.MQTTConfig, c.queries, c.zoneChan)
+		var schedule *weeklySchedule
+		if cfg.Schedule != "" {
+			entries, ok := c.cfg.Schedules[cfg.Schedule]
+			if !ok {
+				logger.L().Panicf("Zone `%s` refers to unknown schedule `%s`", s, cfg.Schedule)
+			}
+			schedule = newWeeklySchedule(cfg.Schedule, entries)
+		}
+		zone := newZoneController(s, cfg, c.cfg.MQTTConfig, c.queries, c.zoneChan, schedule)
 		c.zones[s] = zone
 		c.zoneTRs[zone] = 0.0
 		c.updateMap[zone] = false
@@ -108,10 +190,18 @@ func (c *ThermoController) initializeZones() {
 }
 
 func (c *ThermoController) Run() {
-	state := &thermoState{OT: minValidTemp, tSet: defaultTSet}
-	timer := time.NewTimer(timerDuration)
-	ticker := time.NewTicker(tickerDuration)
+	state := &thermoState{OT: minValidTemp, tSet: defaultTSet, sentTSet: defaultTSet}
+	timer := newTimer(timerDuration)
+	ticker := newTicker(tickerDuration)
 	defer ticker.Stop()
+	keepalive := newTicker(c.cfg.Boiler.KeepaliveInterval())
+	defer keepalive.Stop()
+	var historyC <-chan time.Time
+	if c.cfg.HistoryInterval > 0 {
+		historyTicker := newTicker(c.cfg.HistoryInterval)
+		defer historyTicker.Stop()
+		historyC = historyTicker.C
+	}
 
 	for {
 		select {
@@ -130,11 +220,33 @@ func (c *ThermoController) Run() {
 		case <-timer.C:
 			c.handleUpdate(state)
 		case <-ticker.C:
-			c.update(state.tSet, state.chEnable)
+			c.checkHoliday(clk.Now())
+			// preheat starts at the predicted time, MPC plan, smoothed emitter Tset
+			// and PI integral move with time, not on an input change
+			timed := c.cfg.OptimumStart != nil || c.cfg.MPC != nil
+			for _, zone := range c.zones {
+				if timed || zone.smoothedEmitter() || zone.cfg.PI != nil {
+					c.updateMap[zone] = true
+					c.resetTimer(timer)
+				}
+			}
+		case <-keepalive.C:
+			c.update(state)
+		case <-historyC:
+			c.recordHistory(state, clk.Now())
 		}
 	}
 }
 
+// requestUpdate asks control loop for an immediate update. It never blocks:
+// when a request is already pending, it covers this one too.
+func (c *ThermoController) requestUpdate() {
+	select {
+	case c.forceChan <- true:
+	default:
+	}
+}
+
 func (c *ThermoController) resetTimer(timer *time.Timer) {
 	if !timer.Stop() {
 		select {
@@ -142,7 +254,7 @@ func (c *ThermoController) resetTimer(timer *time.Timer) {
 		default:
 		}
 	}
-	timer.Reset(timerDuration)
+	timer.Reset(clk.Real(timerDuration))
 }
 
 func (c *ThermoController) handleUpdate(state *thermoState) {
@@ -160,14 +272,19 @@ func (c *ThermoController) handleUpdate(state *thermoState) {
 		}
 
 		c.updateMap[zone] = false
-		if newTR, ok := c.calculateSetpoint(zone, state.OT); ok && newTR != c.zoneTRs[zone] {
+		newTR, ok := c.calculateSetpoint(zone, state.OT)
+		if !ok {
+			continue
+		}
+		zone.publishStatus(newTR)
+		if newTR != c.zoneTRs[zone] {
 			c.zoneTRs[zone] = newTR
 			needTRupdate = true
 		}
 	}
 
-	if needTRupdate || state.forceUpdate {
-		newTSet, newChEnable := c.averageSetpoints()
+	if needTRupdate || state.forceUpdate || c.cfg.MPC != nil {
+		newTSet, newChEnable := c.boilerSetpoint(state.OT)
 		if newTSet != state.tSet || newChEnable != state.chEnable || state.forceUpdate {
 			logger.L().Infof(
 				"Updated values: Tset: %.2f -> %.2f, chEnable: %v -> %v",
@@ -175,7 +292,7 @@ func (c *ThermoController) handleUpdate(state *thermoState) {
 			)
 			state.tSet = newTSet
 			state.chEnable = newChEnable
-			c.update(newTSet, newChEnable)
+			c.update(state)
 		}
 		logger.L().Info("Update completed")
 	}
@@ -183,22 +300,53 @@ func (c *ThermoController) handleUpdate(state *thermoState) {
 	state.forceUpdate = false
 }
 
-func (c *ThermoController) update(tSet float64, chEnable bool) {
-	if !c.enabled {
-		c.boiler.Update(defaultTSet, false)
+func (c *ThermoController) update(state *thermoState) {
+	mm := c.maxModulation(state, clk.Now())
+	if mm != state.maxModulation && c.cfg.Boiler.MaxModulation != nil {
+		state.maxModulation = mm
+		c.mqtt.SafePublish(
+			c.cfg.MQTTConfig.ControlTopic+"/max_modulation", mqttQoS, true, strconv.FormatFloat(mm, 'f', 0, 64),
+		)
+	}
+
+	tSet, chEnable := c.commanded(state)
+	if c.returnLimit != nil {
+		tSet = c.limitReturn(tSet, chEnable, clk.Now())
+	}
+	if c.cascade != nil {
+		state.sentTSet, state.sentCHEnable = c.cascade.update(tSet, chEnable, mm, state.OT, clk.Now())
 		return
 	}
-	c.boiler.Update(tSet, chEnable)
+	if c.heatPump != nil {
+		tSet, chEnable = c.heatPump.command(tSet, chEnable, clk.Now())
+	}
+	state.sentTSet, state.sentCHEnable = tSet, chEnable
+	c.boiler.Update(tSet, chEnable, mm)
+}
+
+// commanded returns Tset and CH enable, which are sent to the boiler.
+func (c *ThermoController) commanded(state *thermoState) (float64, bool) {
+	if !c.enabled {
+		return defaultTSet, false
+	}
+	chEnable := state.chEnable
+	if chEnable && c.dhw != nil && c.dhw.PauseCH() {
+		logger.L().Debug("CH demand is paused while DHW is heating")
+		chEnable = false
+	}
+	return state.tSet, chEnable
 }
 
 func (c *ThermoController) controlUpdateHandler(client mqtt.Client, message mqtt.Message) {
 	topic := message.Topic()[strings.LastIndex(message.Topic(), "/")+1:]
 	logger.L().Infof("main: Got MQTT control request: %v : %v", topic, string(message.Payload()))
+	old, accepted := c.controlValue(topic), false
 	switch topic {
 	case "default_heating_parameter":
 		if hp, err := strconv.ParseFloat(string(message.Payload()), 64); err == nil {
 			c.cfg.DefaultHeatingParameter = &hp
 			logger.L().Infof("Updated default heating parameter to %v", hp)
+			accepted = true
 		} else {
 			logger.L().Error(err)
 		}
@@ -207,13 +355,46 @@ func (c *ThermoController) controlUpdateHandler(client mqtt.Client, message mqtt
 			logger.L().Errorf("Wrong log level `%v`", string(message.Payload()))
 		} else {
 			logger.L().Infof("Updated loglevel to `%v`", c.cfg.LogLevel.String())
+			accepted = true
 		}
 	case "enable":
-		c.setEnabled(string(message.Payload()))
+		accepted = c.setEnabled(string(message.Payload()))
+	case "mode":
+		accepted = c.setMode(string(message.Payload()))
+	case "holiday_until":
+		accepted = c.setHolidayUntil(string(message.Payload()))
+	case "heating_model":
+		accepted = c.setHeatingModel(string(message.Payload()))
 	}
+	auditControl(c.queries, message.Topic(), old, c.controlValue(topic), string(message.Payload()), accepted)
 }
 
-func (c *ThermoController) setEnabled(val string) {
+// controlValue returns current value of the control topic for the audit log.
+func (c *ThermoController) controlValue(topic string) string {
+	switch topic {
+	case "default_heating_parameter":
+		return formatControlValue(c.cfg.DefaultHeatingParameter)
+	case "log_level":
+		return c.cfg.LogLevel.String()
+	case "enable":
+		return strconv.FormatBool(c.enabled)
+	case "mode", "holiday_until":
+		c.modeMu.RLock()
+		defer c.modeMu.RUnlock()
+		if topic == "mode" {
+			return c.mode
+		}
+		if c.holidayUntil.IsZero() {
+			return ""
+		}
+		return c.holidayUntil.Format(time.RFC3339)
+	case "heating_model":
+		return c.heatingModelName()
+	}
+	return ""
+}
+
+func (c *ThermoController) setEnabled(val string) bool {
 	switch strings.ToLower(val) {
 	case "true", "on":
 		c.mqtt.SafePublish(c.cfg.MQTTConfig.ControlTopic+"/active", 1, true, "ON")
@@ -223,10 +404,11 @@ func (c *ThermoController) setEnabled(val string) {
 		c.enabled = false
 	default:
 		logger.L().Warnf("Invalid value for enabled_heating: %v", val)
-		return
+		return false
 	}
 	c.writeValue("enabled", strconv.FormatBool(c.enabled))
-	c.forceChan <- true
+	c.requestUpdate()
+	return true
 }
 
 func (c *ThermoController) averageSetpoints() (float64, bool) {
@@ -241,42 +423,28 @@ func (c *ThermoController) averageSetpoints() (float64, bool) {
 		if f < minT && f > 10.0 {
 			minT, minZone = f, zone
 		}
-		md := zone.setpoint - zone.averageTemperature
-		if md > maxDiff {
+		md := zone.effectiveSetpoint - zone.averageTemperature
+		if md > maxDiff && !zone.windowOpen(clk.Now()) {
 			maxDiffZone, maxDiff = zone, md
 		}
 	}
 
-	tCut := minT + 0.7*(maxT-minT)
-	tAvg, weight := 0.0, 0.0
-	pwr := 3.0
-	for zone, f := range c.zoneTRs {
-		if f >= tCut {
-			tAvg += *zone.cfg.Weight * math.Pow(f, pwr)
-			weight += *zone.cfg.Weight
-		}
-	}
-
-	tSet := defaultTSet
-	if weight > 0 {
-		tAvg /= weight
-		tSet = boundTset(math.Round(math.Pow(tAvg, 1.0/pwr)*2) / 2)
-	}
-
+	tSet := c.circuitSetpoint(clk.Now())
 	chEnable := tSet >= minEnableTemp
 
+	c.maxDiff = maxDiff
 	c.mqtt.SafePublish(c.cfg.MQTTConfig.ControlTopic+"/maxdiff", 1, false, ThermoMarshalHelper(maxDiff, maxDiffZone))
 
 	if maxZone != nil {
 		logger.L().Infof(
-			"Zone with MAX demand `%s`: SP=%.2f, T=%.2f Tset=%.2f", maxZone.name, maxZone.setpoint,
+			"Zone with MAX demand `%s`: SP=%.2f, T=%.2f Tset=%.2f", maxZone.name, maxZone.effectiveSetpoint,
 			maxZone.averageTemperature, maxT,
 		)
 		c.mqtt.SafePublish(c.cfg.MQTTConfig.ControlTopic+"/maxcs", 1, false, ThermoMarshalHelper(maxT, maxZone))
 	}
 	if minZone != nil {
 		logger.L().Infof(
-			"Zone with MIN demand `%s`: SP=%.2f, T=%.2f Tset=%.2f", minZone.name, minZone.setpoint,
+			"Zone with MIN demand `%s`: SP=%.2f, T=%.2f Tset=%.2f", minZone.name, minZone.effectiveSetpoint,
 			minZone.averageTemperature, minT,
 		)
 		c.mqtt.SafePublish(c.cfg.MQTTConfig.ControlTopic+"/mincs", 1, false, ThermoMarshalHelper(minT, minZone))
@@ -285,6 +453,34 @@ func (c *ThermoController) averageSetpoints() (float64, bool) {
 	return tSet, chEnable
 }
 
+// aggregateTsets is a power mean of zone Tsets, of zones with the highest demand.
+func aggregateTsets(trs map[*ZoneController]float64, now time.Time) float64 {
+	cut := cutTsets(trs, now)
+	cutMax, cutMin := -1000.0, 1000.0
+	for _, f := range cut {
+		cutMax = max(cutMax, f)
+		if f > 10.0 {
+			cutMin = min(cutMin, f)
+		}
+	}
+	tCut := cutMin + 0.7*(cutMax-cutMin)
+	tAvg, weight := 0.0, 0.0
+	pwr := 3.0
+	for zone, f := range trs {
+		if cut[zone] >= tCut {
+			tAvg += *zone.cfg.Weight * math.Pow(f, pwr)
+			weight += *zone.cfg.Weight
+		}
+	}
+
+	tSet := defaultTSet
+	if weight > 0 {
+		tAvg /= weight
+		tSet = boundTset(math.Round(math.Pow(tAvg, 1.0/pwr)*2) / 2)
+	}
+	return tSet
+}
+
 func ThermoMarshalHelper(t float64, z *ZoneController) []byte {
 	report := struct {
 		Zone        string  `json:"zone"`
@@ -293,7 +489,7 @@ func ThermoMarshalHelper(t float64, z *ZoneController) []byte {
 		CS          float64 `json:"CS"`
 	}{
 		CS:          t,
-		Setpoint:    z.setpoint,
+		Setpoint:    z.effectiveSetpoint,
 		Temperature: z.averageTemperature,
 		Zone:        z.name,
 	}
@@ -307,27 +503,78 @@ func ThermoMarshalHelper(t float64, z *ZoneController) []byte {
 
 func (c *ThermoController) calculateSetpoint(zone *ZoneController, OT float64) (float64, bool) {
 	sp, rt, ok := zone.getPair()
+	sp = c.effectiveSetpoint(zone, sp, OT)
 	hp := c.getHeatingParameter(zone)
-	dT := (rt - sp) * 1.5
+	dT := zone.emitterError(rt-sp) * *zone.cfg.RoomCompensation
 	hp -= dT
 
+	if ok && zone.windowOpen(clk.Now()) {
+		logger.L().Debugf("Zone \"%s\" has open window, demand is suspended", zone.name)
+		return fallbackTSet, true
+	}
+
 	if ok && OT > minValidTemp {
-		tset := thermo_model.CalculateSetpoint(hp, zone.setpoint, OT, zone.averageTemperature)
+		hp += zone.piOutput(sp, rt, clk.Now(), OT > sp-3.0 || rt > sp+2.0)
+		tset := c.currentHeatingModel().CalculateSetpoint(hp, sp, OT, rt)
 		tset = boundTset(tset)
 		if OT > sp-3.0 || rt > sp+2.0 {
 			tset = fallbackTSet
 		}
+		tset = zone.emitterTset(tset, clk.Now())
 		logger.L().Debugf("Update TSet for zone \"%s\" with SP=%.2f, T=%.2f : %.2f", zone.name, sp, rt, tset)
 		return tset, true
 	}
 	return 0.0, false
 }
 
-func (c *ThermoController) getHeatingParameter(zone *ZoneController) float64 {
-	if zone.cfg.HeatingParameter != nil {
-		return *zone.cfg.HeatingParameter
+// effectiveSetpoint applies controller level adjustments to the zone setpoint
+// and stores result in the zone, together with the reason.
+func (c *ThermoController) effectiveSetpoint(zone *ZoneController, sp, OT float64) float64 {
+	reasons := []string{zone.setpointSource()}
+
+	if psp, ok := c.preheatSetpoint(zone, sp, OT, clk.Now()); ok {
+		sp = psp
+		reasons = append(reasons, "preheat")
+	}
+
+	sp, mode, absolute := c.applyMode(zone, sp)
+	if mode != config.ModeComfort {
+		reasons = append(reasons, "mode:"+mode)
+	}
+
+	if !absolute {
+		if setback, ok := c.presenceSetback(zone, clk.Now()); ok {
+			sp -= setback
+			reasons = append(reasons, "unoccupied")
+		}
+	}
+
+	zone.setEffectiveSetpoint(sp, strings.Join(reasons, ","))
+	return sp
+}
+
+// presenceSetback returns setback of the zone, if it is not occupied. Zone presence
+// inputs take precedence over the house level presence.
+func (c *ThermoController) presenceSetback(zone *ZoneController, now time.Time) (float64, bool) {
+	if zone.presence != nil {
+		if !zone.presence.occupied(now) {
+			return *zone.cfg.Presence.Setback, true
+		}
+		if zone.presence.known() {
+			return 0, false
+		}
+	}
+	if c.presence != nil && !c.presence.occupied(now) {
+		if zone.cfg.Presence != nil {
+			return *zone.cfg.Presence.Setback, true
+		}
+		return *c.cfg.Presence.Setback, true
 	}
-	return *c.cfg.DefaultHeatingParameter
+	return 0, false
+}
+
+func (c *ThermoController) getHeatingParameter(zone *ZoneController) float64 {
+	return zone.cfg.EffectiveHeatingParameter(*c.cfg.DefaultHeatingParameter)
 }
 
 func (s *ThermoController) writeValue(name, value string) error {
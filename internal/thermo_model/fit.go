@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package thermo_model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Sample is a steady-state observation: flow temperature, which held the room at setpoint
+// `SP` with heating parameter `HP` and outside temperature `OT`.
+type Sample struct {
+	HP, SP, OT float64
+	Flow       float64
+}
+
+// Fit finds coefficients of the curve type by least squares. Ridge regularisation pulls
+// coefficients towards `prior` (or zero, if prior is nil or of other type), it keeps fit
+// well-posed when history covers narrow range of setpoints and heating parameters.
+// `ridge` is a weight of the prior relative to a single sample.
+func Fit(curve string, samples []Sample, prior *Model, ridge float64) (*Model, error) {
+	terms := Terms(curve)
+	if terms == nil {
+		return nil, fmt.Errorf("unknown heating model type `%s`", curve)
+	}
+	k := len(terms)
+	if len(samples) < k {
+		return nil, fmt.Errorf("need at least %d samples, got %d", k, len(samples))
+	}
+	p := make([]float64, k)
+	if prior != nil && prior.Type == curve {
+		copy(p, prior.Coeff)
+	}
+
+	// columns are scaled to unit RMS, so ridge weight is the same for all terms
+	x := make([][]float64, len(samples))
+	scale := make([]float64, k)
+	for i, s := range samples {
+		x[i] = make([]float64, k)
+		for j, t := range terms {
+			v := t.value(s.HP, s.SP, s.OT)
+			x[i][j] = v
+			scale[j] += v * v
+		}
+	}
+	for j := range scale {
+		scale[j] = math.Sqrt(scale[j] / float64(len(samples)))
+		if scale[j] == 0 {
+			scale[j] = 1
+		}
+	}
+
+	// normal equations (XᵀX + λI)·b = Xᵀy + λ·p, in scaled coefficients b = c·scale
+	a := make([][]float64, k)
+	for j := range a {
+		a[j] = make([]float64, k+1)
+		a[j][j] = ridge
+		a[j][k] = ridge * p[j] * scale[j]
+	}
+	for i, s := range samples {
+		for j := 0; j < k; j++ {
+			xj := x[i][j] / scale[j]
+			for l := 0; l < k; l++ {
+				a[j][l] += xj * x[i][l] / scale[l]
+			}
+			a[j][k] += xj * s.Flow
+		}
+	}
+
+	b, err := solve(a)
+	if err != nil {
+		return nil, err
+	}
+	for j := range b {
+		b[j] /= scale[j]
+	}
+	return &Model{Type: curve, Terms: terms, Coeff: b}, nil
+}
+
+// RMSE returns root mean square error of the model on samples.
+func (m *Model) RMSE(samples []Sample) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, s := range samples {
+		d := m.CalculateSetpoint(s.HP, s.SP, s.OT, s.SP) - s.Flow
+		sum += d * d
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
+
+// solve solves linear system, given as augmented matrix, by Gaussian elimination with partial pivoting.
+func solve(a [][]float64) ([]float64, error) {
+	n := len(a)
+	for col := 0; col < n; col++ {
+		pivot := col
+		for r := col + 1; r < n; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return nil, errors.New("samples don't determine the curve, try larger ridge or simpler model")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		for r := col + 1; r < n; r++ {
+			f := a[r][col] / a[col][col]
+			for c := col; c <= n; c++ {
+				a[r][c] -= f * a[col][c]
+			}
+		}
+	}
+	x := make([]float64, n)
+	for r := n - 1; r >= 0; r-- {
+		v := a[r][n]
+		for c := r + 1; c < n; c++ {
+			v -= a[r][c] * x[c]
+		}
+		x[r] = v / a[r][r]
+	}
+	return x, nil
+}
+
+// Approximate fits curve of given type to this model over the usual range of inputs,
+// e.g. to get a prior for fitting of a simpler curve.
+func (m *Model) Approximate(curve string) (*Model, error) {
+	var samples []Sample
+	for hp := 5.0; hp <= 40; hp += 2.5 {
+		for sp := 15.0; sp <= 24; sp++ {
+			for ot := -20.0; ot <= 15; ot += 2.5 {
+				samples = append(samples, Sample{HP: hp, SP: sp, OT: ot, Flow: m.CalculateSetpoint(hp, sp, ot, sp)})
+			}
+		}
+	}
+	return Fit(curve, samples, nil, 0)
+}
@@ -18,11 +18,22 @@
 
 package thermo_model
 
+import "fmt"
+
 const (
-	maxPower = 3
 	nelCoeff = 14
+
+	// TypePolynomial is the builtin term structure, cubic in hp, sp and ot
+	TypePolynomial = "polynomial"
+	// TypeLinear is a simpler curve: c0 + c1·sp + c2·ot + c3·hp·sp + c4·hp·ot
+	TypeLinear = "linear"
 )
 
+// Term is a monomial hp^HP · sp^SP · ot^OT of the curve.
+type Term struct {
+	HP, SP, OT int
+}
+
 var (
 	mCoeff = [nelCoeff]float64{
 		1.665451e-04, -6.595542e-04, 1.326216e-03, 1.243637e-01,
@@ -34,23 +45,82 @@ var (
 	mTermsHP = [nelCoeff]int{2, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0}
 	mTermsSP = [nelCoeff]int{0, 2, 1, 1, 0, 0, 0, 3, 2, 1, 1, 0, 0, 0}
 	mTermsOT = [nelCoeff]int{0, 0, 1, 0, 2, 1, 0, 0, 0, 1, 0, 2, 1, 0}
+
+	linearTerms = []Term{{0, 0, 0}, {0, 1, 0}, {0, 0, 1}, {1, 1, 0}, {1, 0, 1}}
+
+	builtin = &Model{Type: TypePolynomial, Terms: Terms(TypePolynomial), Coeff: mCoeff[:]}
+
+	// lowTemperature is a curve family of heat pumps: Tset = sp + 0.055·hp·(sp - ot),
+	// e.g. 45°C at -10°C outside with heating parameter 15
+	lowTemperature = &Model{Type: TypeLinear, Terms: linearTerms, Coeff: []float64{0, 1, 0, 0.055, -0.055}}
 )
 
-func CalculateSetpoint(hp, sp, ot, rt float64) float64 {
-	var hpPwr, spPwr, otPwr [maxPower + 1]float64
-	hpPwr[0], spPwr[0], otPwr[0] = 1.0, 1.0, 1.0
-	hpPwr[1], spPwr[1], otPwr[1] = hp, sp, ot
-
-	for i := 2; i <= maxPower; i++ {
-		hpPwr[i] = hpPwr[i-1] * hp
-		spPwr[i] = spPwr[i-1] * sp
-		otPwr[i] = otPwr[i-1] * ot
+// Model is a heating curve: Tset as a sum of weighted monomials of heating parameter,
+// setpoint and outside temperature.
+type Model struct {
+	Type  string
+	Terms []Term
+	Coeff []float64
+}
+
+// Terms returns term structure of the curve type, or nil for unknown type.
+func Terms(curve string) []Term {
+	switch curve {
+	case TypePolynomial:
+		terms := make([]Term, nelCoeff)
+		for i := range terms {
+			terms[i] = Term{HP: mTermsHP[i], SP: mTermsSP[i], OT: mTermsOT[i]}
+		}
+		return terms
+	case TypeLinear:
+		return linearTerms
 	}
+	return nil
+}
 
-	tset := 0.0
-	for i := 0; i < nelCoeff; i++ {
-		tset += mCoeff[i] * hpPwr[mTermsHP[i]] * spPwr[mTermsSP[i]] * otPwr[mTermsOT[i]]
+// Builtin returns the partly reverse engineered polynomial curve.
+func Builtin() *Model {
+	return builtin
+}
+
+// LowTemperature returns builtin heating curve of heat pumps.
+func LowTemperature() *Model {
+	return lowTemperature
+}
+
+// NewModel creates curve of given type with given coefficients.
+func NewModel(curve string, coeff []float64) (*Model, error) {
+	terms := Terms(curve)
+	if terms == nil {
+		return nil, fmt.Errorf("unknown heating model type `%s`", curve)
 	}
+	if len(coeff) != len(terms) {
+		return nil, fmt.Errorf("heating model `%s` needs %d coefficients, got %d", curve, len(terms), len(coeff))
+	}
+	return &Model{Type: curve, Terms: terms, Coeff: coeff}, nil
+}
 
+func (m *Model) CalculateSetpoint(hp, sp, ot, rt float64) float64 {
+	tset := 0.0
+	for i, t := range m.Terms {
+		tset += m.Coeff[i] * t.value(hp, sp, ot)
+	}
 	return tset
 }
+
+func (t Term) value(hp, sp, ot float64) float64 {
+	return pow(hp, t.HP) * pow(sp, t.SP) * pow(ot, t.OT)
+}
+
+func pow(v float64, n int) float64 {
+	r := 1.0
+	for i := 0; i < n; i++ {
+		r *= v
+	}
+	return r
+}
+
+// CalculateSetpoint calculates Tset with the builtin curve.
+func CalculateSetpoint(hp, sp, ot, rt float64) float64 {
+	return builtin.CalculateSetpoint(hp, sp, ot, rt)
+}
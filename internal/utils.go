@@ -22,6 +22,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
@@ -57,6 +58,36 @@ func extractF64PlainOrJson(message mqtt.Message, JSONEntry *string) (float64, er
 	return t0, nil
 }
 
+// extractBoolPlainOrJson parses on/off style payloads: `ON`, `true`, `1`, `heat`, etc.
+func extractBoolPlainOrJson(message mqtt.Message, JSONEntry *string) (bool, error) {
+	var v interface{} = string(message.Payload())
+	if JSONEntry != nil {
+		var valMap map[string]interface{}
+		if err := json.Unmarshal(message.Payload(), &valMap); err != nil {
+			return false, errors.Wrapf(err, "json unmarshal error with : %v : %v", message.Topic(), string(message.Payload()))
+		}
+		var ok bool
+		if v, ok = valMap[*JSONEntry]; !ok {
+			return false, fmt.Errorf("not found: `%v` in `%v`: %v", *JSONEntry, message.Topic(), string(message.Payload()))
+		}
+	}
+
+	switch t := v.(type) {
+	case bool:
+		return t, nil
+	case float64:
+		return t != 0, nil
+	case string:
+		switch strings.ToLower(strings.TrimSpace(t)) {
+		case "on", "true", "1", "yes", "heat", "open", "active", "home", "occupied", "detected":
+			return true, nil
+		case "off", "false", "0", "no", "idle", "closed", "inactive", "not_home", "away", "unoccupied", "clear":
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("cannot cast `%v` to bool in : %v : %v", v, message.Topic(), string(message.Payload()))
+}
+
 //func mean(vals []float64) float64 {
 //	mea
 //}
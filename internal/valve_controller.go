@@ -17,3 +17,91 @@
  */
 
 package internal
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/antst/mzotbc/internal/config"
+	"github.com/antst/mzotbc/internal/logger"
+	"github.com/antst/mzotbc/internal/safe_mqtt"
+)
+
+// mixingValve drives mixing valve actuator. Analog actuator gets position directly, 3-point
+// actuator gets open/close pulses and the position is estimated from the stroke time.
+type mixingValve struct {
+	name string
+	cfg  *config.MixingValveConfig
+	mqtt safe_mqtt.MqttClient
+
+	mu       sync.Mutex
+	position float64
+	moving   bool
+}
+
+func newMixingValve(_name string, _cfg *config.MixingValveConfig, _mqtt safe_mqtt.MqttClient) *mixingValve {
+	v := &mixingValve{name: _name, cfg: _cfg, mqtt: _mqtt, position: -1}
+	if _cfg.Type == config.ValveThreePoint {
+		// position of 3-point actuator is unknown, full stroke to the closed end stop
+		v.position = 100
+	}
+	v.set(0)
+	return v
+}
+
+func (v *mixingValve) currentPosition() float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return max(v.position, 0)
+}
+
+// set moves the valve to the position, 0–100%.
+func (v *mixingValve) set(pos float64) {
+	if v.cfg.Type == config.ValveAnalog {
+		pos = math.Round(pos)
+		v.mu.Lock()
+		changed := pos != v.position
+		v.position = pos
+		v.mu.Unlock()
+		if changed {
+			v.mqtt.SafePublish(v.cfg.OutputTopic, mqttQoS, true, strconv.FormatFloat(pos, 'f', 0, 64))
+		}
+		return
+	}
+	v.pulse(pos)
+}
+
+// pulse moves 3-point actuator towards the position, unless it is still moving.
+func (v *mixingValve) pulse(pos float64) {
+	v.mu.Lock()
+	if v.moving {
+		v.mu.Unlock()
+		return
+	}
+	d := time.Duration(math.Abs(pos-v.position) / 100 * float64(v.cfg.RunTime))
+	if d < v.cfg.MinPulse {
+		v.mu.Unlock()
+		return
+	}
+	if pos <= 0 || pos >= 100 {
+		// overrun at the end stop corrects drift of the estimated position
+		d += v.cfg.RunTime / 10
+	}
+	topic := v.cfg.OpenTopic
+	if pos < v.position {
+		topic = v.cfg.CloseTopic
+	}
+	v.position, v.moving = pos, true
+	v.mu.Unlock()
+
+	logger.L().Debugf("Mixing valve `%s`: move to %.0f%% for %v", v.name, pos, d)
+	v.mqtt.SafePublish(topic, mqttQoS, false, v.cfg.OnPayload)
+	afterFunc(d, func() {
+		v.mqtt.SafePublish(topic, mqttQoS, false, v.cfg.OffPayload)
+		v.mu.Lock()
+		v.moving = false
+		v.mu.Unlock()
+	})
+}
@@ -20,6 +20,7 @@ package internal
 
 import (
 	"context"
+	"fmt"
 	"strconv"
 	"strings"
 	"sync"
@@ -51,6 +52,33 @@ type ZoneController struct {
 	averageFunc        func([]*SensorController) (float64, time.Time)
 	controlChan        chan<- *ZoneController
 	childChan          chan bool
+	controlTopic       string
+	schedule           *weeklySchedule
+	slot               scheduleSlot
+	overrideUntil      time.Time
+	effectiveSetpoint  float64
+	setpointReason     string
+	window             windowState
+	presence           *presenceTracker
+	lastStatus         zoneStatus
+	heatup             heatupModel
+	thermal            heatupModel
+	emitter            emitterState
+	spike              emitterState
+	next               nextChange
+	lastOptimum        []byte
+	pi                 piState
+}
+
+type zoneScheduleReport struct {
+	Schedule      string     `json:"schedule"`
+	Setpoint      float64    `json:"setpoint"`
+	SlotSetpoint  float64    `json:"slot_setpoint"`
+	SlotStart     time.Time  `json:"slot_start"`
+	NextChange    *time.Time `json:"next_change,omitempty"`
+	NextSetpoint  *float64   `json:"next_setpoint,omitempty"`
+	Override      bool       `json:"override"`
+	OverrideUntil *time.Time `json:"override_until,omitempty"`
 }
 
 func (z *ZoneController) getPair() (float64, float64, bool) {
@@ -59,6 +87,13 @@ func (z *ZoneController) getPair() (float64, float64, bool) {
 	return z.setpoint, z.averageTemperature, z.setpointTimestamp.After(zeroTS) && z.averageTimestamp.After(zeroTS)
 }
 
+func (z *ZoneController) setEffectiveSetpoint(sp float64, reason string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.effectiveSetpoint = sp
+	z.setpointReason = reason
+}
+
 func (z *ZoneController) childProcessor() {
 	for range z.childChan {
 		z.updateAverage()
@@ -78,7 +113,7 @@ func (z *ZoneController) LinkAverageFun() {
 
 func newZoneController(
 	_name string, _cfg *config.ZoneConfig, _mqttCfg *config.MQTTConfig, _q *db.Queries,
-	_controlChan chan<- *ZoneController,
+	_controlChan chan<- *ZoneController, _schedule *weeklySchedule,
 ) *ZoneController {
 	z := &ZoneController{
 		name:              _name,
@@ -86,53 +121,84 @@ func newZoneController(
 		queries:           _q,
 		setpointTimestamp: zeroTS,
 		averageTimestamp:  zeroTS,
+		overrideUntil:     zeroTS,
 		controlChan:       _controlChan,
 		childChan:         make(chan bool, childChanBuffer),
+		controlTopic:      _mqttCfg.ControlTopic + "/zone/" + _name + "/",
+		schedule:          _schedule,
 	}
 
 	z.LinkAverageFun()
 	if err := z.readState(); err == nil {
 		logger.L().Debugf("Loaded previous state from DB for zone %v: %v", z.name, z.setpoint)
-		z.setpointTimestamp = time.Now()
+		z.setpointTimestamp = clk.Now()
 	}
 	z.mqtt = safe_mqtt.InitMQTTClient(_mqttCfg.URL, "otbs-zone-"+z.name+"-"+uuid.New().String())
 
-	z.mqtt.SafeSubscribe(_cfg.Setpoint.Topic, mqttQoS, z.setpointUpdateHandler)
+	if _cfg.Setpoint.Topic != "" {
+		z.mqtt.SafeSubscribe(_cfg.Setpoint.Topic, mqttQoS, z.setpointUpdateHandler)
+	}
 
-	zoneMQTTgroup := _mqttCfg.ControlTopic + "/zone/" + z.name + "/"
+	zoneMQTTgroup := z.controlTopic
 	z.mqtt.SafeSubscribe(zoneMQTTgroup+"sensors_average_type", mqttQoS, z.controlUpdateHandler)
 	z.mqtt.SafeSubscribe(zoneMQTTgroup+"weight", mqttQoS, z.controlUpdateHandler)
 	z.mqtt.SafeSubscribe(zoneMQTTgroup+"heating_parameter", mqttQoS, z.controlUpdateHandler)
+	if _cfg.PI != nil {
+		z.restorePI()
+		for _, t := range []string{"pi_kp", "pi_ki", "pi_max_output", "pi_band", "pi_reset"} {
+			z.mqtt.SafeSubscribe(zoneMQTTgroup+t, mqttQoS, z.controlUpdateHandler)
+		}
+	}
+	if z.schedule == nil {
+		z.mqtt.SafeSubscribe(zoneMQTTgroup+"next_setpoint", mqttQoS, z.controlUpdateHandler)
+	}
+
+	z.initWindow()
+	if _cfg.Presence != nil {
+		z.presence = newPresenceTracker(z.name, _cfg.Presence, z.mqtt, func() { z.childChan <- true })
+	}
 
 	z.sensors = make([]*SensorController, len(z.cfg.Sensors))
 	for i, sensor := range z.cfg.Sensors {
-		sName := "zone-" + z.name + "-"
-		if sensor.Name == "" {
-			sName += strconv.Itoa(i + 1)
-		} else {
-			sName += sensor.Name
-		}
-
-		z.sensors[i] = NewSensorController(sName, sensor, _mqttCfg, z.queries, z.childChan)
+		z.sensors[i] = NewSensorController(zoneSensorName(z.name, i, sensor), sensor, _mqttCfg, z.queries, z.childChan)
 	}
 	go z.childProcessor()
+	if z.schedule != nil {
+		z.applySchedule(clk.Now())
+		go z.scheduler()
+	}
 	z.updateAverage()
 
 	return z
 }
 
+// zoneSensorName is a name of i-th sensor of the zone, under which its state and history are stored.
+func zoneSensorName(zone string, i int, sensor *config.SensorConfig) string {
+	if sensor.Name == "" {
+		return "zone-" + zone + "-" + strconv.Itoa(i+1)
+	}
+	return "zone-" + zone + "-" + sensor.Name
+}
+
 func (z *ZoneController) updateAverage() {
 	v, t := z.averageFunc(z.sensors)
 	if t.After(zeroTS) {
 		z.mu.Lock()
 		z.averageTimestamp = t
 		z.averageTemperature = v
+		z.recordTemperature(t, v)
 		z.mu.Unlock()
+		z.updateWindow(t)
 		z.controlChan <- z
 	}
 }
 
 func (z *ZoneController) setpointUpdateHandler(client mqtt.Client, message mqtt.Message) {
+	if z.schedule != nil && message.Retained() {
+		// stale value, delivered on subscribe, must not override the schedule
+		logger.L().Debugf("Ignore retained setpoint for scheduled zone %s", z.name)
+		return
+	}
 	t0, err := extractF64PlainOrJson(message, z.cfg.Setpoint.JSONEntry)
 	if err != nil {
 		logger.L().Error(err)
@@ -142,14 +208,21 @@ func (z *ZoneController) setpointUpdateHandler(client mqtt.Client, message mqtt.
 	z.mu.Lock()
 	oldSP := z.setpoint
 	z.setpoint = t0*(*z.cfg.Setpoint.Scale) + (*z.cfg.Setpoint.Offset)
-	z.setpointTimestamp = time.Now()
+	z.setpointTimestamp = clk.Now()
 	logger.L().Debugf("Got setpoint for zone %s : %f", z.name, z.setpoint)
+	if z.schedule != nil && z.slot.entry != nil {
+		z.setOverride(z.setpointTimestamp)
+	}
+	newSP := z.setpoint
 	z.mu.Unlock()
 
 	if err := z.writeState(); err != nil {
 		logger.L().Error(err)
 	}
-	if t0 != oldSP {
+	if z.schedule != nil {
+		z.publishSchedule()
+	}
+	if newSP != oldSP {
 		z.childChan <- true
 	}
 }
@@ -171,16 +244,28 @@ func (z *ZoneController) readState() error {
 	return nil
 }
 
+// writeValue persists named zone value, e.g. state of controllers.
+func (z *ZoneController) writeValue(name string, value float64) error {
+	return z.queries.UpsertZoneValue(
+		context.Background(), db.UpsertZoneValueParams{ZoneName: z.name, Name: name, Value: value},
+	)
+}
+
+func (z *ZoneController) readValue(name string) (float64, error) {
+	return z.queries.GetZoneValue(context.Background(), db.GetZoneValueParams{ZoneName: z.name, Name: name})
+}
+
 func (z *ZoneController) controlUpdateHandler(client mqtt.Client, message mqtt.Message) {
 	topic := message.Topic()[strings.LastIndex(message.Topic(), "/")+1:]
 	logger.L().Infof("Zone %v got MQTT control request: %v : %v", z.name, topic, string(message.Payload()))
+	old, accepted := z.controlValue(topic), false
 
 	switch topic {
 	case "weight", "heating_parameter":
 		value, err := strconv.ParseFloat(string(message.Payload()), 64)
 		if err != nil {
 			logger.L().Error(err)
-			return
+			break
 		}
 		if topic == "weight" {
 			z.cfg.Weight = &value
@@ -188,11 +273,40 @@ func (z *ZoneController) controlUpdateHandler(client mqtt.Client, message mqtt.M
 			z.cfg.HeatingParameter = &value
 		}
 		logger.L().Infof("Updated %s for zone `%v` to %v", topic, z.name, value)
+		accepted = true
 	case "sensors_average_type":
 		z.cfg.SensorsAverageType = string(message.Payload())
 		z.LinkAverageFun()
 		logger.L().Infof("Updated sensors average type to `%v`", z.cfg.SensorsAverageType)
+		accepted = true
+	case "pi_kp", "pi_ki", "pi_max_output", "pi_band", "pi_reset":
+		accepted = z.piControlUpdate(topic, string(message.Payload()))
+	case "next_setpoint":
+		accepted = z.setNextChange(string(message.Payload()))
 	default:
 		logger.L().Errorf("Unknown control topic: %s", topic)
 	}
+	auditControl(z.queries, message.Topic(), old, z.controlValue(topic), string(message.Payload()), accepted)
+}
+
+// controlValue returns current value of the control topic for the audit log.
+func (z *ZoneController) controlValue(topic string) string {
+	switch topic {
+	case "weight":
+		return formatControlValue(z.cfg.Weight)
+	case "heating_parameter":
+		return formatControlValue(z.cfg.HeatingParameter)
+	case "sensors_average_type":
+		return z.cfg.SensorsAverageType
+	case "pi_kp", "pi_ki", "pi_max_output", "pi_band", "pi_reset":
+		return z.piControlValue(topic)
+	case "next_setpoint":
+		z.mu.RLock()
+		defer z.mu.RUnlock()
+		if z.next.at.IsZero() {
+			return ""
+		}
+		return fmt.Sprintf("%v at %v", z.next.setpoint, z.next.at.Format(time.RFC3339))
+	}
+	return ""
 }
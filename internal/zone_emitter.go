@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"math"
+	"time"
+
+	"github.com/antst/mzotbc/internal/config"
+)
+
+// slowEmitterTime is a time constant, from which emitter is considered slow
+const slowEmitterTime = time.Hour
+
+// emitterState is a Tset, smoothed with a time constant.
+type emitterState struct {
+	tset float64
+	at   time.Time
+}
+
+func (s *emitterState) smooth(tset float64, now time.Time, tc time.Duration) float64 {
+	if s.at.IsZero() || now.Before(s.at) {
+		*s = emitterState{tset: tset, at: now}
+		return tset
+	}
+	alpha := 1 - math.Exp(-now.Sub(s.at).Seconds()/tc.Seconds())
+	s.tset += alpha * (tset - s.tset)
+	s.at = now
+	return math.Round(s.tset*10) / 10
+}
+
+// emitterError applies emitter dead band to the room temperature error.
+func (z *ZoneController) emitterError(e float64) float64 {
+	return deadbandError(z.cfg.Emitter, e)
+}
+
+// deadbandError applies dead band of the emitter, if any, to the room temperature error.
+func deadbandError(emitter *config.EmitterConfig, e float64) float64 {
+	if emitter == nil {
+		return e
+	}
+	db := *emitter.Deadband
+	if math.Abs(e) <= db {
+		return 0
+	}
+	return e - math.Copysign(db, e)
+}
+
+// emitterTset caps zone Tset by the emitter max flow and smooths it with the emitter time constant.
+func (z *ZoneController) emitterTset(tset float64, now time.Time) float64 {
+	e := z.cfg.Emitter
+	if e == nil {
+		return tset
+	}
+	tset = min(tset, *e.MaxFlow)
+	if e.TimeConstant <= 0 {
+		return tset
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.emitter.smooth(tset, now, e.TimeConstant)
+}
+
+// smoothedEmitter reports whether zone Tset is smoothed, so it changes with time.
+func (z *ZoneController) smoothedEmitter() bool {
+	return z.cfg.Emitter != nil && z.cfg.Emitter.TimeConstant > 0
+}
+
+func (z *ZoneController) emitterTimeConstant() time.Duration {
+	if z.cfg.Emitter == nil {
+		return 0
+	}
+	return z.cfg.Emitter.TimeConstant
+}
+
+// cutTsets returns zone Tsets, which select zones for the boiler Tset average. When some
+// zones have slow emitters, Tsets of faster zones are smoothed with the slowest time constant,
+// so their short spikes don't push slow zones out of the average, while sustained demand does.
+func cutTsets(trs map[*ZoneController]float64, now time.Time) map[*ZoneController]float64 {
+	var slowest time.Duration
+	for zone := range trs {
+		slowest = max(slowest, zone.emitterTimeConstant())
+	}
+	if slowest < slowEmitterTime {
+		return trs
+	}
+
+	cut := make(map[*ZoneController]float64, len(trs))
+	for zone, f := range trs {
+		if zone.emitterTimeConstant() >= slowEmitterTime {
+			cut[zone] = f
+			continue
+		}
+		zone.mu.Lock()
+		cut[zone] = zone.spike.smooth(f, now, slowest)
+		zone.mu.Unlock()
+	}
+	return cut
+}
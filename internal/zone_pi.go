@@ -0,0 +1,155 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/antst/mzotbc/internal/logger"
+)
+
+const (
+	piIntegralValue = "pi_integral"
+	piSaveInterval  = 5 * time.Minute
+	// piMaxStep caps the integrated interval, e.g. a long gap between updates
+	piMaxStep = 15 * time.Minute
+)
+
+type piState struct {
+	integral float64
+	output   float64
+	last     time.Time
+	savedAt  time.Time
+}
+
+func (z *ZoneController) restorePI() {
+	v, err := z.readValue(piIntegralValue)
+	if err != nil {
+		return
+	}
+	z.pi.integral = v
+	logger.L().Debugf("Loaded PI integral from DB for zone %v: %v", z.name, v)
+}
+
+func (z *ZoneController) savePI(integral float64) {
+	if err := z.writeValue(piIntegralValue, integral); err != nil {
+		logger.L().Error(err)
+	}
+}
+
+// piOutput updates PI controller with the current error and returns its output,
+// which trims the heating parameter. Integral is frozen (anti-windup), when demand
+// is on hold, error is out of the band or output is saturated in direction of the error.
+func (z *ZoneController) piOutput(sp, rt float64, now time.Time, hold bool) float64 {
+	cfg := z.cfg.PI
+	if cfg == nil {
+		return 0
+	}
+	kp, ki, limit := *cfg.Kp, *cfg.Ki, *cfg.MaxOutput
+	e := sp - rt
+
+	z.mu.Lock()
+	dt := min(now.Sub(z.pi.last), piMaxStep)
+	if z.pi.last.IsZero() {
+		// nothing to integrate before the first update
+		dt = 0
+	}
+	z.pi.last = now
+	if !hold && ki != 0 && dt > 0 && math.Abs(e) <= *cfg.Band {
+		next := z.pi.integral + e*dt.Hours()
+		out := kp*e + ki*next
+		if !(out > limit && e > 0) && !(out < -limit && e < 0) {
+			z.pi.integral = next
+		}
+	}
+	if ki > 0 {
+		z.pi.integral = max(min(z.pi.integral, limit/ki), -limit/ki)
+	}
+	z.pi.output = max(min(kp*e+ki*z.pi.integral, limit), -limit)
+	out, integral := z.pi.output, z.pi.integral
+	save := now.Sub(z.pi.savedAt) >= piSaveInterval
+	if save {
+		z.pi.savedAt = now
+	}
+	z.mu.Unlock()
+
+	if save {
+		z.savePI(integral)
+	}
+	logger.L().Debugf("PI of zone \"%s\": e=%.2f, integral=%.3f, output=%.2f", z.name, e, integral, out)
+	return out
+}
+
+// piControlUpdate handles `pi_*` control topics of the zone.
+func (z *ZoneController) piControlUpdate(topic, payload string) bool {
+	if z.cfg.PI == nil {
+		logger.L().Warnf("Zone %s has no PI controller configured", z.name)
+		return false
+	}
+	if topic == "pi_reset" {
+		z.mu.Lock()
+		z.pi.integral = 0
+		z.mu.Unlock()
+		z.savePI(0)
+		logger.L().Infof("Reset PI integral of zone `%v`", z.name)
+		z.childChan <- true
+		return true
+	}
+
+	value, err := strconv.ParseFloat(payload, 64)
+	if err != nil {
+		logger.L().Error(err)
+		return false
+	}
+	switch topic {
+	case "pi_kp":
+		z.cfg.PI.Kp = &value
+	case "pi_ki":
+		z.cfg.PI.Ki = &value
+	case "pi_max_output":
+		z.cfg.PI.MaxOutput = &value
+	case "pi_band":
+		z.cfg.PI.Band = &value
+	}
+	logger.L().Infof("Updated %s for zone `%v` to %v", topic, z.name, value)
+	z.childChan <- true
+	return true
+}
+
+// piControlValue returns current value of `pi_*` control topic, integral for `pi_reset`.
+func (z *ZoneController) piControlValue(topic string) string {
+	if z.cfg.PI == nil {
+		return ""
+	}
+	switch topic {
+	case "pi_kp":
+		return formatControlValue(z.cfg.PI.Kp)
+	case "pi_ki":
+		return formatControlValue(z.cfg.PI.Ki)
+	case "pi_max_output":
+		return formatControlValue(z.cfg.PI.MaxOutput)
+	case "pi_band":
+		return formatControlValue(z.cfg.PI.Band)
+	}
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	return strconv.FormatFloat(z.pi.integral, 'f', 3, 64)
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/antst/mzotbc/internal/logger"
+)
+
+const scheduleTickerDuration = 30 * time.Second
+
+func (z *ZoneController) scheduler() {
+	ticker := newTicker(scheduleTickerDuration)
+	defer ticker.Stop()
+	for range ticker.C {
+		z.applySchedule(clk.Now())
+	}
+}
+
+// applySchedule sets zone setpoint from the active schedule slot, unless
+// there is an external override, which lasts until the next slot starts.
+func (z *ZoneController) applySchedule(now time.Time) {
+	slot, ok := z.schedule.active(now)
+	if !ok {
+		return
+	}
+
+	z.mu.Lock()
+	oldSP := z.setpoint
+	slotChanged := slot.entry != z.slot.entry || !slot.start.Equal(z.slot.start)
+	z.slot = slot
+	if z.overrideUntil.After(zeroTS) && !now.Before(z.overrideUntil) {
+		logger.L().Infof("Setpoint override for zone %s is over", z.name)
+		z.overrideUntil = zeroTS
+	}
+	if z.overrideUntil.Equal(zeroTS) {
+		z.setpoint = slot.entry.Setpoint
+		z.setpointTimestamp = now
+	}
+	newSP := z.setpoint
+	z.mu.Unlock()
+
+	if slotChanged {
+		logger.L().Infof("Zone %s: schedule `%s` slot %v, setpoint %.1f", z.name, z.schedule.name,
+			slot.start.Format(time.DateTime), slot.entry.Setpoint)
+		z.publishSchedule()
+	}
+	if newSP != oldSP {
+		if err := z.writeState(); err != nil {
+			logger.L().Error(err)
+		}
+		z.childChan <- true
+	}
+}
+
+// setOverride marks current setpoint as override until the next slot of the schedule.
+// It must be called with lock held.
+func (z *ZoneController) setOverride(now time.Time) {
+	if z.setpoint == z.slot.entry.Setpoint {
+		z.overrideUntil = zeroTS
+		return
+	}
+	next, ok := z.schedule.next(now)
+	if !ok {
+		return
+	}
+	z.overrideUntil = next.start
+	logger.L().Infof("Zone %s: setpoint %.1f overrides schedule until %v", z.name, z.setpoint,
+		next.start.Format(time.DateTime))
+}
+
+func (z *ZoneController) publishSchedule() {
+	z.mu.RLock()
+	report := zoneScheduleReport{
+		Schedule:     z.schedule.name,
+		Setpoint:     z.setpoint,
+		SlotSetpoint: z.slot.entry.Setpoint,
+		SlotStart:    z.slot.start,
+		Override:     z.overrideUntil.After(zeroTS),
+	}
+	if report.Override {
+		until := z.overrideUntil
+		report.OverrideUntil = &until
+	}
+	z.mu.RUnlock()
+
+	if next, ok := z.schedule.next(clk.Now()); ok {
+		report.NextChange = &next.start
+		report.NextSetpoint = &next.entry.Setpoint
+	}
+
+	payload, err := json.Marshal(report)
+	if err != nil {
+		logger.L().Error(err)
+		return
+	}
+	z.mqtt.SafePublish(z.controlTopic+"schedule_slot", mqttQoS, true, payload)
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/antst/mzotbc/internal/logger"
+)
+
+// zoneStatus is published to `<control_topic>/zone/<name>/status`.
+type zoneStatus struct {
+	Setpoint          float64 `json:"setpoint"`
+	EffectiveSetpoint float64 `json:"effective_setpoint"`
+	Reason            string  `json:"reason"`
+	Temperature       float64 `json:"temperature"`
+	TSet              float64 `json:"tset"`
+	WindowOpen        bool    `json:"window_open"`
+	PIOutput          float64 `json:"pi_output,omitempty"`
+	Emitter           string  `json:"emitter,omitempty"`
+}
+
+// setpointSource describes where zone setpoint comes from.
+func (z *ZoneController) setpointSource() string {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	switch {
+	case z.schedule == nil:
+		return "setpoint"
+	case z.overrideUntil.After(zeroTS):
+		return "override"
+	default:
+		return "schedule"
+	}
+}
+
+// publishStatus publishes zone status, if it has changed.
+func (z *ZoneController) publishStatus(tSet float64) {
+	z.mu.Lock()
+	st := zoneStatus{
+		Setpoint:          z.setpoint,
+		EffectiveSetpoint: z.effectiveSetpoint,
+		Reason:            z.setpointReason,
+		Temperature:       z.averageTemperature,
+		TSet:              tSet,
+		WindowOpen:        z.isWindowOpen(clk.Now()),
+		PIOutput:          math.Round(z.pi.output*100) / 100,
+	}
+	if z.cfg.Emitter != nil {
+		st.Emitter = z.cfg.Emitter.Type
+	}
+	if st == z.lastStatus {
+		z.mu.Unlock()
+		return
+	}
+	z.lastStatus = st
+	z.mu.Unlock()
+
+	payload, err := json.Marshal(st)
+	if err != nil {
+		logger.L().Error(err)
+		return
+	}
+	z.mqtt.SafePublish(z.controlTopic+"status", mqttQoS, true, payload)
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright (c) 2023. Anton Starikov -- All Rights Reserved
+ *
+ * This file is part of MZOTBC project.
+ *
+ * MZOTBC is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as the Free Software Foundation,
+ * either version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package internal
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/antst/mzotbc/internal/config"
+	"github.com/antst/mzotbc/internal/logger"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type tempSample struct {
+	t time.Time
+	v float64
+}
+
+// windowState tracks open window of the zone, guarded by zone lock.
+type windowState struct {
+	history   []tempSample
+	dropUntil time.Time
+	contacts  []bool
+	open      bool
+}
+
+type windowReport struct {
+	Open    bool       `json:"open"`
+	Reason  string     `json:"reason,omitempty"`
+	Until   *time.Time `json:"until,omitempty"`
+	Contact bool       `json:"contact"`
+}
+
+func (z *ZoneController) initWindow() {
+	if z.cfg.Window == nil {
+		return
+	}
+	z.window.contacts = make([]bool, len(z.cfg.Window.Contacts))
+	for i, c := range z.cfg.Window.Contacts {
+		z.mqtt.SafeSubscribe(c.Topic, mqttQoS, z.contactUpdateHandler(i, c))
+	}
+}
+
+func (z *ZoneController) contactUpdateHandler(idx int, cfg *config.ContactConfig) mqtt.MessageHandler {
+	return func(client mqtt.Client, message mqtt.Message) {
+		open, err := extractBoolPlainOrJson(message, cfg.JSONEntry)
+		if err != nil {
+			logger.L().Error(err)
+			return
+		}
+		if cfg.Invert {
+			open = !open
+		}
+
+		z.mu.Lock()
+		z.window.contacts[idx] = open
+		z.mu.Unlock()
+		if z.updateWindow(clk.Now()) {
+			z.childChan <- true
+		}
+	}
+}
+
+// recordTemperature keeps short history of the zone temperature and detects
+// open window by the temperature drop within configured period.
+// It must be called with lock held.
+func (z *ZoneController) recordTemperature(t time.Time, v float64) {
+	w := z.cfg.Window
+	if w == nil || !*w.DropDetection {
+		return
+	}
+
+	h := append(z.window.history, tempSample{t: t, v: v})
+	i := 0
+	for i < len(h) && t.Sub(h[i].t) > w.Period {
+		i++
+	}
+	h = h[i:]
+
+	maxV := v
+	for _, s := range h {
+		maxV = max(maxV, s.v)
+	}
+
+	if maxV-v >= *w.Drop && !t.Before(z.window.dropUntil) {
+		z.window.dropUntil = t.Add(w.SuspendFor)
+		logger.L().Infof(
+			"Zone %s: temperature dropped %.2f -> %.2f, open window, suspend demand until %v",
+			z.name, maxV, v, z.window.dropUntil.Format(time.DateTime),
+		)
+		h = h[:0]
+		afterFunc(w.SuspendFor, func() {
+			if z.updateWindow(clk.Now()) {
+				z.childChan <- true
+			}
+		})
+	}
+	z.window.history = h
+}
+
+// windowOpen reports whether zone demand is suspended because of open window.
+func (z *ZoneController) windowOpen(now time.Time) bool {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	return z.isWindowOpen(now)
+}
+
+func (z *ZoneController) isWindowOpen(now time.Time) bool {
+	if z.cfg.Window == nil {
+		return false
+	}
+	return z.contactOpen() || now.Before(z.window.dropUntil)
+}
+
+func (z *ZoneController) contactOpen() bool {
+	for _, c := range z.window.contacts {
+		if c {
+			return true
+		}
+	}
+	return false
+}
+
+// updateWindow publishes window state, if it has changed, and reports whether it has.
+func (z *ZoneController) updateWindow(now time.Time) bool {
+	if z.cfg.Window == nil {
+		return false
+	}
+
+	z.mu.Lock()
+	open := z.isWindowOpen(now)
+	changed := open != z.window.open
+	z.window.open = open
+	report := windowReport{Open: open, Contact: z.contactOpen()}
+	if now.Before(z.window.dropUntil) {
+		report.Reason = "temperature_drop"
+		until := z.window.dropUntil
+		report.Until = &until
+	}
+	if report.Contact {
+		report.Reason = "contact"
+	}
+	z.mu.Unlock()
+
+	if !changed {
+		return false
+	}
+	logger.L().Infof("Zone %s: window open: %v", z.name, open)
+	payload, err := json.Marshal(report)
+	if err != nil {
+		logger.L().Error(err)
+		return true
+	}
+	z.mqtt.SafePublish(z.controlTopic+"window", mqttQoS, true, payload)
+	return true
+}
@@ -19,6 +19,8 @@
 package main
 
 import (
+	"os"
+
 	"github.com/antst/mzotbc/internal"
 	"github.com/antst/mzotbc/internal/logger"
 )
@@ -28,6 +30,34 @@ var version = "devel"
 
 func main() {
 	logger.L().Warnf("OT Boiler Controller, version: %+v", version)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "simulate":
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+			internal.Simulate()
+			return
+		case "replay":
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+			internal.Replay()
+			return
+		case "fit":
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+			internal.Fit()
+			return
+		case "export":
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+			internal.Export()
+			return
+		case "audit":
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+			internal.Audit()
+			return
+		case "alarms":
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+			internal.Alarms()
+			return
+		}
+	}
 	c := internal.NewThermoController()
 	c.Run()
 }
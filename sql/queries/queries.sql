@@ -30,4 +30,92 @@ ON CONFLICT(name) DO UPDATE SET value=excluded.value,
                                 updated_at=CURRENT_TIMESTAMP;
 
 -- name: GetControllerValue :one
-SELECT value from controller where name=?;
\ No newline at end of file
+SELECT value from controller where name=?;
+
+-- name: UpsertZoneValue :exec
+INSERT INTO zone_value(zone_name, name, value, updated_at)
+VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+ON CONFLICT(zone_name, name) DO UPDATE SET value=excluded.value,
+                                           updated_at=CURRENT_TIMESTAMP;
+
+-- name: GetZoneValue :one
+SELECT value
+FROM zone_value
+WHERE zone_name = ? AND name = ?;
+
+-- name: InsertZoneHistory :exec
+INSERT INTO zone_history (zone_name, temperature, setpoint, effective_setpoint, tset,
+                          outside_temperature, flow_temperature, recorded_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: ListZoneHistory :many
+SELECT *
+FROM zone_history
+WHERE zone_name = ? AND recorded_at >= ?
+ORDER BY recorded_at;
+
+-- name: InsertHeatingParameterChange :exec
+INSERT INTO heating_parameter_change (zone_name, old_value, new_value, mean_error, samples, changed_at)
+VALUES (?, ?, ?, ?, ?, ?);
+
+-- name: ListHeatingParameterChanges :many
+SELECT *
+FROM heating_parameter_change
+ORDER BY zone_name, changed_at;
+
+-- name: ListHistory :many
+SELECT *
+FROM zone_history
+WHERE recorded_at >= sqlc.arg(since) AND recorded_at < sqlc.arg(until)
+ORDER BY recorded_at, zone_name;
+
+-- name: InsertBoilerHistory :exec
+INSERT INTO boiler_history (tset, ch_enable, flow_temperature, recorded_at)
+VALUES (?, ?, ?, ?);
+
+-- name: ListBoilerHistory :many
+SELECT *
+FROM boiler_history
+WHERE recorded_at >= sqlc.arg(since) AND recorded_at < sqlc.arg(until)
+ORDER BY recorded_at;
+
+-- name: InsertSensorHistory :exec
+INSERT INTO sensor_history (sensor_name, value, recorded_at)
+VALUES (?, ?, ?);
+
+-- name: ListSensorHistory :many
+SELECT *
+FROM sensor_history
+WHERE recorded_at >= sqlc.arg(since) AND recorded_at < sqlc.arg(until)
+ORDER BY recorded_at, sensor_name;
+
+-- name: InsertAlarm :execlastid
+INSERT INTO alarm (name, severity, message, raised_at)
+VALUES (?, ?, ?, ?);
+
+-- name: ClearAlarm :exec
+UPDATE alarm
+SET cleared_at = ?
+WHERE id = ?;
+
+-- name: ListActiveAlarms :many
+SELECT *
+FROM alarm
+WHERE cleared_at IS NULL
+ORDER BY raised_at;
+
+-- name: ListAlarms :many
+SELECT *
+FROM alarm
+WHERE raised_at >= sqlc.arg(since) AND raised_at < sqlc.arg(until)
+ORDER BY raised_at;
+
+-- name: InsertControlAudit :exec
+INSERT INTO control_audit (topic, old_value, new_value, accepted, changed_at)
+VALUES (?, ?, ?, ?, ?);
+
+-- name: ListControlAudit :many
+SELECT *
+FROM control_audit
+WHERE changed_at >= sqlc.arg(since) AND changed_at < sqlc.arg(until)
+ORDER BY changed_at, id;
@@ -14,4 +14,73 @@ CREATE TABLE IF NOT EXISTS controller (
   name TEXT NOT NULL PRIMARY KEY,
   value TEXT NOT NULL,
   updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-);
\ No newline at end of file
+);
+
+CREATE TABLE IF NOT EXISTS zone_value (
+  zone_name TEXT NOT NULL,
+  name TEXT NOT NULL,
+  value REAL NOT NULL,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (zone_name, name)
+);
+
+CREATE TABLE IF NOT EXISTS zone_history (
+  zone_name TEXT NOT NULL,
+  temperature REAL NOT NULL,
+  setpoint REAL NOT NULL,
+  effective_setpoint REAL NOT NULL,
+  tset REAL NOT NULL,
+  outside_temperature REAL NOT NULL,
+  flow_temperature REAL,
+  recorded_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS zone_history_zone_recorded ON zone_history (zone_name, recorded_at);
+
+CREATE TABLE IF NOT EXISTS heating_parameter_change (
+  zone_name TEXT NOT NULL,
+  old_value REAL NOT NULL,
+  new_value REAL NOT NULL,
+  mean_error REAL NOT NULL,
+  samples INTEGER NOT NULL,
+  changed_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS boiler_history (
+  tset REAL NOT NULL,
+  ch_enable BOOLEAN NOT NULL,
+  flow_temperature REAL,
+  recorded_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS boiler_history_recorded ON boiler_history (recorded_at);
+
+CREATE TABLE IF NOT EXISTS sensor_history (
+  sensor_name TEXT NOT NULL,
+  value REAL NOT NULL,
+  recorded_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS sensor_history_recorded ON sensor_history (recorded_at);
+
+CREATE TABLE IF NOT EXISTS alarm (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL,
+  severity TEXT NOT NULL,
+  message TEXT NOT NULL,
+  raised_at TIMESTAMP NOT NULL,
+  cleared_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS alarm_raised ON alarm (raised_at);
+
+CREATE TABLE IF NOT EXISTS control_audit (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  topic TEXT NOT NULL,
+  old_value TEXT NOT NULL,
+  new_value TEXT NOT NULL,
+  accepted BOOLEAN NOT NULL,
+  changed_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS control_audit_changed ON control_audit (changed_at);